@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Исход операции в журнале. В dry-run статуса нет.
+const (
+	statusPending = "pending"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// Одна запись об операции оператора. В dry-run пишем то, что было
+// бы сделано. У записи о начале и записи об исходе общий ID.
+type auditEvent struct {
+	ID       string                 `json:"id"`
+	Time     time.Time              `json:"time"`
+	Status   string                 `json:"status,omitempty"`
+	Operator string                 `json:"operator"`
+	Action   string                 `json:"action"`
+	Target   string                 `json:"target,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+	DryRun   bool                   `json:"dryRun"`
+	Error    string                 `json:"error,omitempty"`
+}
+
+/*
+Журнал действий оператора: по строке JSON на событие, файл только
+дописывается. До изменения пишется запись pending, после - исход.
+Если pending записать не удалось, операция не выполняется. pending
+без исхода значит, что процесс упал посреди операции: прежде чем
+повторять ее, проверьте базу.
+*/
+type auditTrail struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newAuditTrail(w io.Writer) *auditTrail {
+	return &auditTrail{w: w}
+}
+
+func (a *auditTrail) Write(ev auditEvent) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// событие должно пережить падение процесса сразу после операции
+	if s, ok := a.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"proj/internal/audit"
+	"proj/internal/handlers"
+	"proj/internal/rbac"
+	"proj/internal/session"
+	"proj/internal/types"
+	"proj/internal/user"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrUsage        = errors.New("invalid arguments")
+	ErrBadCSV       = errors.New("invalid grant file")
+	ErrEmptyReason  = errors.New("reason is required")
+	ErrBadPassword  = errors.New("password must be 1-72 bytes")
+	ErrUnknownLogin = errors.New("unknown login")
+)
+
+type command struct {
+	usage string
+	run   func(a *admin, ctx context.Context, args []string) error
+}
+
+var commands = map[string]command{
+	"create-user": {
+		usage: "-login <login> [-roles employee,finance]; password is read from stdin",
+		run:   (*admin).createUser,
+	},
+	"reset-password": {
+		usage: "-login <login>; password is read from stdin, all sessions are revoked",
+		run:   (*admin).resetPassword,
+	},
+	"adjust-balance": {
+		usage: "-login <login> -delta <+/-coins> -reason <text>",
+		run:   (*admin).adjustBalance,
+	},
+	"grant": {
+		usage: "-file <login,amount csv> -reason <text>",
+		run:   (*admin).grant,
+	},
+	"revoke-sessions": {
+		usage: "-login <login>",
+		run:   (*admin).revokeSessions,
+	},
+	"deactivate-user": {
+		usage: "-login <login>; the user can no longer receive coins",
+		run:   (*admin).deactivateUser,
+	},
+	"activate-user": {
+		usage: "-login <login>",
+		run:   (*admin).activateUser,
+	},
+	"inventory": {
+		usage: "-login <login>",
+		run:   (*admin).inventory,
+	},
+	"history": {
+		usage: "-login <login> [-direction all|sent|received] [-limit n] [-cursor c]",
+		run:   (*admin).history,
+	},
+}
+
+/*
+Операции оператора поверх тех же репозиториев, что и у сервера.
+Каждое изменение пишется в журнал аудита. В DryRun проверяем
+аргументы и читаем базу, но ничего не меняем.
+*/
+type admin struct {
+	Users    user.UserRepo
+	Sessions session.SessionManagerRepo
+	Audit    *auditTrail
+	Logger   *zap.SugaredLogger
+
+	Operator string
+	DryRun   bool
+
+	In  io.Reader
+	Out io.Writer
+}
+
+func (a *admin) Run(ctx context.Context, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %q", ErrUsage, name)
+	}
+
+	// изменения из утилиты попадают и в журнал аудита в базе,
+	// автор - оператор, а не пользователь магазина
+	ctx = audit.WithMeta(ctx, audit.Meta{Actor: "cli:" + a.Operator})
+	return cmd.run(a, ctx, args)
+}
+
+/*
+Выполняет изменение (в dry-run только описывает его) и пишет события
+аудита: pending до изменения и исход после. Изменение, которое уже
+закоммичено, не превращается в ошибку из-за журнала: иначе оператор
+повторит команду и, например, начислит монеты дважды.
+*/
+func (a *admin) do(action, target string, details map[string]interface{}, fn func() error) error {
+	ev := auditEvent{
+		ID:       uuid.New().String(),
+		Time:     time.Now().UTC(),
+		Operator: a.Operator,
+		Action:   action,
+		Target:   target,
+		Details:  details,
+		DryRun:   a.DryRun,
+	}
+
+	if a.DryRun {
+		fmt.Fprintf(a.Out, "dry run: would %s %s %v\n", action, target, details)
+		if err := a.Audit.Write(ev); err != nil {
+			a.Logger.Errorf("error to write audit event: %v. Event: %+v", err, ev)
+			return err
+		}
+		return nil
+	}
+
+	ev.Status = statusPending
+	if err := a.Audit.Write(ev); err != nil {
+		a.Logger.Errorf("error to write audit event: %v. Event: %+v", err, ev)
+		return fmt.Errorf("%w (nothing applied)", err)
+	}
+
+	err := fn()
+
+	ev.Time = time.Now().UTC()
+	ev.Status = statusDone
+	if err != nil {
+		ev.Status = statusFailed
+		ev.Error = err.Error()
+	}
+	if auditErr := a.Audit.Write(ev); auditErr != nil {
+		a.Logger.Errorf("error to write audit event: %v. Event: %+v", auditErr, ev)
+		if err == nil {
+			fmt.Fprintf(a.Out, "%s %s: applied, audit write failed: %v\n", action, target, auditErr)
+		}
+		return err
+	}
+
+	if err == nil {
+		fmt.Fprintf(a.Out, "%s %s: ok\n", action, target)
+	}
+	return err
+}
+
+func (a *admin) createUser(ctx context.Context, args []string) error {
+	fs := newFlagSet("create-user")
+	login := fs.String("login", "", "login of the new user")
+	roles := fs.String("roles", strings.Join(rbac.DefaultRoles, ","), "comma-separated roles")
+	if err := parse(fs, args, "login"); err != nil {
+		return err
+	}
+
+	roleList := splitList(*roles)
+	if err := rbac.ValidateRoles(roleList); err != nil {
+		return err
+	}
+	if len(*login) > handlers.UsernameMaxLen {
+		return fmt.Errorf("%w: login longer than %d", ErrUsage, handlers.UsernameMaxLen)
+	}
+	// сервер проверит то же при вставке, но dry-run должен ответить заранее
+	if _, err := a.Users.GetByLogin(ctx, *login); !errors.Is(err, user.ErrUserNotFound) {
+		if err == nil {
+			return user.ErrUserExists
+		}
+		return err
+	}
+	password, err := a.readPassword()
+	if err != nil {
+		return err
+	}
+
+	details := map[string]interface{}{"roles": roleList}
+	return a.do("create-user", *login, details, func() error {
+		_, err := a.Users.CreateUser(ctx, *login, password, roleList)
+		return err
+	})
+}
+
+func (a *admin) resetPassword(ctx context.Context, args []string) error {
+	fs := newFlagSet("reset-password")
+	login := fs.String("login", "", "login of the user")
+	if err := parse(fs, args, "login"); err != nil {
+		return err
+	}
+
+	u, err := a.lookup(ctx, *login)
+	if err != nil {
+		return err
+	}
+	password, err := a.readPassword()
+	if err != nil {
+		return err
+	}
+
+	// со старым паролем могли уже войти, поэтому отзываем все сессии
+	return a.do("reset-password", *login, map[string]interface{}{"userId": u.UserID}, func() error {
+		if err := a.Users.ResetPassword(ctx, *login, password); err != nil {
+			return err
+		}
+		_, err := a.Sessions.RevokeAll(ctx, u.UserID)
+		return err
+	})
+}
+
+func (a *admin) adjustBalance(ctx context.Context, args []string) error {
+	fs := newFlagSet("adjust-balance")
+	login := fs.String("login", "", "login of the user")
+	delta := fs.Int("delta", 0, "coins to add, negative to take away")
+	reason := fs.String("reason", "", "why the balance is adjusted, goes to the ledger")
+	if err := parse(fs, args, "login", "delta", "reason"); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*reason) == "" {
+		return ErrEmptyReason
+	}
+
+	u, err := a.lookup(ctx, *login)
+	if err != nil {
+		return err
+	}
+	return a.adjust(ctx, "adjust-balance", u, *delta, *reason)
+}
+
+func (a *admin) adjust(ctx context.Context, action string, u user.User, delta int, reason string) error {
+	// база проверит это еще раз под блокировкой
+	if u.AmountInWallet+delta < 0 {
+		return &user.InsufficientFundsError{Required: -delta, Available: u.AmountInWallet}
+	}
+
+	details := map[string]interface{}{
+		"userId":        u.UserID,
+		"delta":         delta,
+		"reason":        reason,
+		"balanceBefore": u.AmountInWallet,
+		"balanceAfter":  u.AmountInWallet + delta,
+	}
+	return a.do(action, u.Login, details, func() error {
+		return a.Users.AdjustBalance(ctx, u.UserID, delta, reason)
+	})
+}
+
+type grantRow struct {
+	line   int
+	login  string
+	amount int
+}
+
+/*
+Массовое начисление из CSV "login,amount", заголовок необязателен.
+Сначала разбираем весь файл и находим всех пользователей, потом
+начисляем одной транзакцией: опечатка, ошибка базы или Ctrl-C
+не должны оставить половину начислений сделанными, а повторный
+запуск - начислить первым строкам дважды.
+*/
+func (a *admin) grant(ctx context.Context, args []string) error {
+	fs := newFlagSet("grant")
+	file := fs.String("file", "", "csv file with login,amount rows")
+	reason := fs.String("reason", "", "why coins are granted, goes to the ledger")
+	if err := parse(fs, args, "file", "reason"); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*reason) == "" {
+		return ErrEmptyReason
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rows, err := parseGrants(f)
+	if err != nil {
+		return err
+	}
+
+	grants := make([]user.Grant, 0, len(rows))
+	lines := make([]map[string]interface{}, 0, len(rows))
+	total := 0
+	for _, row := range rows {
+		u, err := a.lookup(ctx, row.login)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", row.line, err)
+		}
+		grants = append(grants, user.Grant{UserID: u.UserID, Amount: row.amount})
+		lines = append(lines, map[string]interface{}{"login": u.Login, "userId": u.UserID, "amount": row.amount})
+		total += row.amount
+	}
+
+	details := map[string]interface{}{
+		"file":   *file,
+		"reason": *reason,
+		"total":  total,
+		"grants": lines,
+	}
+	err = a.do("grant", *file, details, func() error {
+		return a.Users.GrantBalances(ctx, grants, *reason)
+	})
+	if err != nil {
+		return fmt.Errorf("%w (no rows granted)", err)
+	}
+
+	if a.DryRun {
+		fmt.Fprintf(a.Out, "dry run: would grant %d coins to %d users\n", total, len(rows))
+		return nil
+	}
+	fmt.Fprintf(a.Out, "granted %d coins to %d users\n", total, len(rows))
+	return nil
+}
+
+func parseGrants(r io.Reader) ([]grantRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+
+	var rows []grantRow
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBadCSV, err)
+		}
+
+		amount, err := strconv.Atoi(strings.TrimSpace(rec[1]))
+		if err != nil {
+			if line == 1 {
+				// заголовок
+				continue
+			}
+			return nil, fmt.Errorf("%w: line %d: bad amount %q", ErrBadCSV, line, rec[1])
+		}
+		if amount <= 0 {
+			return nil, fmt.Errorf("%w: line %d: amount must be positive", ErrBadCSV, line)
+		}
+
+		rows = append(rows, grantRow{line: line, login: strings.TrimSpace(rec[0]), amount: amount})
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("%w: no rows", ErrBadCSV)
+	}
+	return rows, nil
+}
+
+func (a *admin) revokeSessions(ctx context.Context, args []string) error {
+	fs := newFlagSet("revoke-sessions")
+	login := fs.String("login", "", "login of the user")
+	if err := parse(fs, args, "login"); err != nil {
+		return err
+	}
+
+	u, err := a.lookup(ctx, *login)
+	if err != nil {
+		return err
+	}
+
+	return a.do("revoke-sessions", *login, map[string]interface{}{"userId": u.UserID}, func() error {
+		n, err := a.Sessions.RevokeAll(ctx, u.UserID)
+		if err == nil {
+			fmt.Fprintf(a.Out, "revoked %d sessions\n", n)
+		}
+		return err
+	})
+}
+
+func (a *admin) deactivateUser(ctx context.Context, args []string) error {
+	return a.setActive(ctx, "deactivate-user", args, false)
+}
+
+func (a *admin) activateUser(ctx context.Context, args []string) error {
+	return a.setActive(ctx, "activate-user", args, true)
+}
+
+func (a *admin) setActive(ctx context.Context, action string, args []string, active bool) error {
+	fs := newFlagSet(action)
+	login := fs.String("login", "", "login of the user")
+	if err := parse(fs, args, "login"); err != nil {
+		return err
+	}
+
+	u, err := a.lookup(ctx, *login)
+	if err != nil {
+		return err
+	}
+
+	return a.do(action, *login, map[string]interface{}{"userId": u.UserID}, func() error {
+		return a.Users.SetActive(ctx, *login, active)
+	})
+}
+
+func (a *admin) inventory(ctx context.Context, args []string) error {
+	fs := newFlagSet("inventory")
+	login := fs.String("login", "", "login of the user")
+	if err := parse(fs, args, "login"); err != nil {
+		return err
+	}
+
+	u, err := a.lookup(ctx, *login)
+	if err != nil {
+		return err
+	}
+	info, err := a.Users.Info(ctx, u.UserID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(a.Out, "%s (%s): %d coins\n", u.Login, u.UserID, info.Coins)
+	tw := tabwriter.NewWriter(a.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ITEM\tQUANTITY")
+	for _, it := range info.Inventory {
+		fmt.Fprintf(tw, "%s\t%d\n", it.Type, it.Quantity)
+	}
+	return tw.Flush()
+}
+
+func (a *admin) history(ctx context.Context, args []string) error {
+	fs := newFlagSet("history")
+	login := fs.String("login", "", "login of the user")
+	direction := fs.String("direction", types.DirectionAll, "all, sent or received")
+	limit := fs.Int("limit", 50, "page size")
+	cursor := fs.String("cursor", "", "next page cursor from the previous output")
+	if err := parse(fs, args, "login"); err != nil {
+		return err
+	}
+
+	u, err := a.lookup(ctx, *login)
+	if err != nil {
+		return err
+	}
+	page, err := a.Users.History(ctx, u.UserID, types.HistoryFilter{
+		Direction: *direction,
+		Limit:     *limit,
+		Cursor:    *cursor,
+	})
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(a.Out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tDIRECTION\tCOUNTERPARTY\tAMOUNT")
+	for _, e := range page.Items {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\n", e.ID, e.CreatedAt.Format(time.RFC3339), e.Direction, e.Counterparty, e.Amount)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if page.NextCursor != "" {
+		fmt.Fprintf(a.Out, "next page: -cursor %s\n", page.NextCursor)
+	}
+	return nil
+}
+
+func (a *admin) lookup(ctx context.Context, login string) (user.User, error) {
+	u, err := a.Users.GetByLogin(ctx, login)
+	if errors.Is(err, user.ErrUserNotFound) {
+		return user.User{}, fmt.Errorf("%w: %s", ErrUnknownLogin, login)
+	}
+	return u, err
+}
+
+// Пароль читаем из stdin, чтобы он не попал в историю shell и в ps.
+func (a *admin) readPassword() (string, error) {
+	line, err := bufio.NewReader(a.In).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	password := strings.TrimRight(line, "\r\n")
+	if password == "" || len(password) > handlers.PasswordMaxLen {
+		return "", ErrBadPassword
+	}
+	return password, nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// Разбирает флаги команды и проверяет, что обязательные заданы.
+func parse(fs *flag.FlagSet, args []string, required ...string) error {
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrUsage, fs.Name(), err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for _, name := range required {
+		if !set[name] {
+			return fmt.Errorf("%w: %s: -%s is required", ErrUsage, fs.Name(), name)
+		}
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"proj/internal/audit"
+	"proj/internal/session"
+	"proj/internal/user"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type testAdmin struct {
+	*admin
+	users    *user.MockUserRepo
+	sessions *session.MockSessionManagerRepo
+	audit    *bytes.Buffer
+}
+
+func newTestAdmin(t *testing.T, dryRun bool, stdin string) testAdmin {
+	ctrl := gomock.NewController(t)
+	users := user.NewMockUserRepo(ctrl)
+	sessions := session.NewMockSessionManagerRepo(ctrl)
+	audit := &bytes.Buffer{}
+
+	return testAdmin{
+		admin: &admin{
+			Users:    users,
+			Sessions: sessions,
+			Audit:    newAuditTrail(audit),
+			Logger:   zap.NewNop().Sugar(),
+			Operator: "ops",
+			DryRun:   dryRun,
+			In:       strings.NewReader(stdin),
+			Out:      &bytes.Buffer{},
+		},
+		users:    users,
+		sessions: sessions,
+		audit:    audit,
+	}
+}
+
+func (ta testAdmin) events(t *testing.T) []auditEvent {
+	var events []auditEvent
+	dec := json.NewDecoder(ta.audit)
+	for dec.More() {
+		var ev auditEvent
+		require.NoError(t, dec.Decode(&ev))
+		events = append(events, ev)
+	}
+	return events
+}
+
+func writeGrants(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "grants.csv")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+var errDiskFull = errors.New("no space left on device")
+
+// Принимает первые ok записей, дальше отказывает.
+type failingWriter struct {
+	ok int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.ok == 0 {
+		return 0, errDiskFull
+	}
+	w.ok--
+	return len(p), nil
+}
+
+var alice = user.User{UserID: "user1", Login: "alice", AmountInWallet: 100}
+
+func TestAdmin_Run(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"adjust balance": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().AdjustBalance(gomock.Any(), "user1", -30, "refund").
+				DoAndReturn(func(ctx context.Context, _ string, _ int, _ string) error {
+					// в журнале аудита в базе автор - оператор утилиты
+					require.Equal(t, "cli:ops", audit.MetaFrom(ctx).Actor)
+					return nil
+				})
+
+			err := ta.Run(context.Background(), "adjust-balance", []string{"-login", "alice", "-delta", "-30", "-reason", "refund"})
+			require.NoError(t, err)
+
+			events := ta.events(t)
+			require.Len(t, events, 2)
+			// до изменения - pending, после - исход с тем же id
+			require.Equal(t, statusPending, events[0].Status)
+			require.Equal(t, statusDone, events[1].Status)
+			require.Equal(t, events[0].ID, events[1].ID)
+			require.Equal(t, "ops", events[1].Operator)
+			require.Equal(t, "adjust-balance", events[1].Action)
+			require.EqualValues(t, 70, events[1].Details["balanceAfter"])
+			require.False(t, events[1].DryRun)
+		},
+
+		"dry run changes nothing but is audited": func(t *testing.T) {
+			ta := newTestAdmin(t, true, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+
+			err := ta.Run(context.Background(), "adjust-balance", []string{"-login", "alice", "-delta", "50", "-reason", "bonus"})
+			require.NoError(t, err)
+
+			events := ta.events(t)
+			require.Len(t, events, 1)
+			require.True(t, events[0].DryRun)
+		},
+
+		"adjust below zero rejected before write": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+
+			err := ta.Run(context.Background(), "adjust-balance", []string{"-login", "alice", "-delta", "-500", "-reason", "fine"})
+			require.ErrorIs(t, err, user.ErrInsufficientFunds)
+			require.Empty(t, ta.events(t))
+		},
+
+		"adjust requires reason": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+
+			err := ta.Run(context.Background(), "adjust-balance", []string{"-login", "alice", "-delta", "5"})
+			require.ErrorIs(t, err, ErrUsage)
+		},
+
+		"grant resolves every login first": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "ghost").Return(user.User{}, user.ErrUserNotFound)
+
+			path := writeGrants(t, "login,amount\nalice,10\nghost,20\n")
+			err := ta.Run(context.Background(), "grant", []string{"-file", path, "-reason", "hackathon"})
+			require.ErrorIs(t, err, ErrUnknownLogin)
+			require.Empty(t, ta.events(t))
+		},
+
+		"grant": func(t *testing.T) {
+			bob := user.User{UserID: "user2", Login: "bob"}
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "bob").Return(bob, nil)
+			ta.users.EXPECT().GrantBalances(gomock.Any(), []user.Grant{
+				{UserID: "user1", Amount: 10},
+				{UserID: "user2", Amount: 20},
+			}, "hackathon").Return(nil)
+
+			path := writeGrants(t, "alice,10\nbob, 20\n")
+			err := ta.Run(context.Background(), "grant", []string{"-file", path, "-reason", "hackathon"})
+			require.NoError(t, err)
+
+			events := ta.events(t)
+			require.Len(t, events, 2)
+			require.EqualValues(t, 30, events[1].Details["total"])
+		},
+
+		"grant failing mid-batch grants nothing": func(t *testing.T) {
+			bob := user.User{UserID: "user2", Login: "bob"}
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "bob").Return(bob, nil)
+			// строки уходят одним вызовом, по одной репозиторий не зовется
+			ta.users.EXPECT().GrantBalances(gomock.Any(), gomock.Len(2), "hackathon").Return(user.ErrInternalDB)
+
+			path := writeGrants(t, "alice,10\nbob,20\n")
+			err := ta.Run(context.Background(), "grant", []string{"-file", path, "-reason", "hackathon"})
+			require.ErrorIs(t, err, user.ErrInternalDB)
+			require.Contains(t, err.Error(), "no rows granted")
+
+			events := ta.events(t)
+			require.Len(t, events, 2)
+			require.Equal(t, user.ErrInternalDB.Error(), events[1].Error)
+		},
+
+		"reset password revokes sessions": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "n3w-password\n")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().ResetPassword(gomock.Any(), "alice", "n3w-password").Return(nil)
+			ta.sessions.EXPECT().RevokeAll(gomock.Any(), "user1").Return(int64(2), nil)
+
+			err := ta.Run(context.Background(), "reset-password", []string{"-login", "alice"})
+			require.NoError(t, err)
+
+			events := ta.events(t)
+			require.Len(t, events, 2)
+			// пароль в журнал не попадает
+			require.NotContains(t, ta.audit.String(), "n3w-password")
+		},
+
+		"create existing user": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "password\n")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+
+			err := ta.Run(context.Background(), "create-user", []string{"-login", "alice"})
+			require.ErrorIs(t, err, user.ErrUserExists)
+		},
+
+		"deactivate user": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().SetActive(gomock.Any(), "alice", false).Return(nil)
+
+			err := ta.Run(context.Background(), "deactivate-user", []string{"-login", "alice"})
+			require.NoError(t, err)
+
+			events := ta.events(t)
+			require.Len(t, events, 2)
+			require.Equal(t, "deactivate-user", events[1].Action)
+		},
+
+		"activate unknown user": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "ghost").Return(user.User{}, user.ErrUserNotFound)
+
+			err := ta.Run(context.Background(), "activate-user", []string{"-login", "ghost"})
+			require.ErrorIs(t, err, ErrUnknownLogin)
+			require.Empty(t, ta.events(t))
+		},
+
+		"failed operation is audited with error": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.sessions.EXPECT().RevokeAll(gomock.Any(), "user1").Return(int64(0), session.ErrInternalDB)
+
+			err := ta.Run(context.Background(), "revoke-sessions", []string{"-login", "alice"})
+			require.ErrorIs(t, err, session.ErrInternalDB)
+
+			events := ta.events(t)
+			require.Len(t, events, 2)
+			require.Equal(t, statusFailed, events[1].Status)
+			require.Equal(t, session.ErrInternalDB.Error(), events[1].Error)
+		},
+
+		"audit failure before the change applies nothing": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.Audit = newAuditTrail(&failingWriter{})
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+
+			err := ta.Run(context.Background(), "adjust-balance", []string{"-login", "alice", "-delta", "30", "-reason", "bonus"})
+			require.ErrorIs(t, err, errDiskFull)
+			require.Contains(t, err.Error(), "nothing applied")
+		},
+
+		"audit failure after the change is not an error": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+			ta.Audit = newAuditTrail(&failingWriter{ok: 1})
+			ta.users.EXPECT().GetByLogin(gomock.Any(), "alice").Return(alice, nil)
+			ta.users.EXPECT().AdjustBalance(gomock.Any(), "user1", 30, "bonus").Return(nil)
+
+			// монеты уже начислены: ошибка толкнула бы оператора повторить команду
+			err := ta.Run(context.Background(), "adjust-balance", []string{"-login", "alice", "-delta", "30", "-reason", "bonus"})
+			require.NoError(t, err)
+			require.Contains(t, ta.Out.(*bytes.Buffer).String(), "applied, audit write failed")
+		},
+
+		"unknown command": func(t *testing.T) {
+			ta := newTestAdmin(t, false, "")
+
+			err := ta.Run(context.Background(), "drop-database", nil)
+			require.ErrorIs(t, err, ErrUsage)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestParseGrants(t *testing.T) {
+	tests := map[string]struct {
+		data string
+		rows int
+		err  error
+	}{
+		"with header":     {data: "login,amount\nalice,10\n", rows: 1},
+		"without header":  {data: "alice,10\nbob,5\n", rows: 2},
+		"negative amount": {data: "alice,-10\n", err: ErrBadCSV},
+		"bad amount":      {data: "alice,10\nbob,ten\n", err: ErrBadCSV},
+		"wrong columns":   {data: "alice,10,extra\n", err: ErrBadCSV},
+		"empty":           {data: "login,amount\n", err: ErrBadCSV},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			rows, err := parseGrants(strings.NewReader(tt.data))
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, rows, tt.rows)
+		})
+	}
+}
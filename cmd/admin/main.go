@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"sort"
+	"syscall"
+
+	"proj/internal/app"
+	"proj/internal/keyring"
+	"proj/internal/session"
+	userrepo "proj/internal/user"
+
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultCfgPath   = "config/config.yaml"
+	defaultAuditPath = "admin-audit.jsonl"
+)
+
+/*
+Утилита оператора магазина: те же репозитории, что у сервера,
+вместо ручных запросов в postgres.
+
+	admin [-dry-run] [-operator name] <command> [flags]
+*/
+func main() {
+	cfgPath := flag.String("config", defaultCfgPath, "path to YAML config, empty - defaults and env only")
+	secretsDir := flag.String("secrets-dir", app.DefaultSecretsDir, "directory with secret files")
+	dryRun := flag.Bool("dry-run", false, "validate and show what would be done without changing anything")
+	operator := flag.String("operator", currentUser(), "who runs the command, goes to the audit trail")
+	auditPath := flag.String("audit-log", defaultAuditPath, "file the audit trail is appended to")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	// init logger
+	zapLogger, err := zap.NewProduction()
+	if err != nil {
+		panic(err)
+	}
+	logger := zapLogger.Sugar()
+	defer func() {
+		err = zapLogger.Sync()
+		if err != nil {
+			logger.Warnf("error to sync logger: %v", err)
+		}
+	}()
+
+	c, err := app.Load(app.LoadOptions{
+		Path:       *cfgPath,
+		SecretsDir: *secretsDir,
+	})
+	if err != nil {
+		logger.Fatalf("error to parsing config: %v", err)
+	}
+	if _, err := c.Validate(); err != nil {
+		logger.Fatalf("error to validate config: %v", err)
+	}
+
+	dsn := fmt.Sprintf(
+		"host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable",
+		c.CfgDB.Host, c.CfgDB.Port, c.CfgDB.Login, c.CfgDB.Password, c.CfgDB.Database,
+	)
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		logger.Fatalf("error to database start: %v", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Warnf("error to close database: %v", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	if err := db.PingContext(ctx); err != nil {
+		logger.Fatalf("failed to get response to ping: %v", err)
+	}
+
+	f, err := os.OpenFile(*auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		logger.Fatalf("error to open audit log: %v", err)
+	}
+	defer f.Close()
+
+	ur := userrepo.NewUserDBRepository(db, logger)
+	ur.Transfers = userrepo.TransferPolicy{
+		MaxAmount:  c.Transfers.MaxAmount,
+		DailyLimit: c.Transfers.DailyLimit,
+	}
+
+	a := &admin{
+		Users: ur,
+		// токены утилита не выпускает и не проверяет, ключи подписи не нужны
+		Sessions: session.NewSessionManager(db, logger, keyring.New()),
+		Audit:    newAuditTrail(f),
+		Logger:   logger,
+		Operator: *operator,
+		DryRun:   *dryRun,
+		In:       os.Stdin,
+		Out:      os.Stdout,
+	}
+
+	if err := a.Run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		logger.Fatalf("%s failed: %v", flag.Arg(0), err)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [flags] <command> [command flags]\n\nflags:\n", os.Args[0])
+	flag.PrintDefaults()
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(out, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s %s\n", name, commands[name].usage)
+	}
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return "unknown"
+}
@@ -1,24 +1,66 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"proj/internal/app"
+	"proj/internal/audit"
+	"proj/internal/catalog"
+	"proj/internal/dbtx"
 	"proj/internal/handlers"
+	"proj/internal/idempotency"
+	"proj/internal/keyring"
+	"proj/internal/ledger"
+	"proj/internal/metrics"
+	"proj/internal/middleware"
+	"proj/internal/migrate"
 	"proj/internal/session"
+	"proj/internal/tracing"
 	"proj/internal/user"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
 )
 
 const (
-	cfgPath = "config/config.yaml"
+	defaultCfgPath = "config/config.yaml"
+
+	// Подкоманды бинарника, без подкоманды поднимаем сервер
+	cmdReconcile = "reconcile"
+	cmdMigrate   = "migrate"
+
+	// Если в конфиге не задано server.shutdown_grace
+	defaultShutdownGrace = 15 * time.Second
+	// Сколько ждем отправки последних спанов при выходе
+	tracingShutdownTimeout = 5 * time.Second
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// Код выхода вместо logger.Fatalf: Fatalf завершает процесс сразу,
+// и отложенные закрытие базы и досылка спанов не выполняются.
+func run() int {
+	cfgPath := flag.String("config", defaultCfgPath, "path to YAML config, empty - defaults and env only")
+	secretsDir := flag.String("secrets-dir", app.DefaultSecretsDir, "directory with secret files")
+	printConfig := flag.Bool("print-config", false, "print resolved config with secrets redacted and exit")
+	flag.Parse()
+
 	// init logger
 	zapLogger, err := zap.NewProduction()
 	if err != nil {
@@ -34,46 +76,445 @@ func main() {
 		}
 	}()
 
-	// парсим конфиг
-	c, err := app.NewConfig(cfgPath)
+	// собираем конфиг: умолчания, файл, окружение, секреты
+	c, err := app.Load(app.LoadOptions{
+		Path:       *cfgPath,
+		SecretsDir: *secretsDir,
+	})
+	if err != nil {
+		logger.Errorf("error to parsing config: %v", err)
+		return 1
+	}
+	warnings, err := c.Validate()
+	for _, w := range warnings {
+		logger.Warnf("config: %s", w)
+	}
+
+	if *printConfig {
+		out, yamlErr := yaml.Marshal(c.Redacted())
+		if yamlErr != nil {
+			logger.Errorf("error to print config: %v", yamlErr)
+			return 1
+		}
+		fmt.Print(string(out))
+		if err != nil {
+			logger.Errorf("error to validate config: %v", err)
+			return 1
+		}
+		return 0
+	}
+	if err != nil {
+		logger.Errorf("error to validate config: %v", err)
+		return 1
+	}
+
+	// init tracing: без коллектора спаны можно писать в stdout или файл
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    c.Tracing.Exporter,
+		Endpoint:    c.Tracing.Endpoint,
+		Insecure:    c.Tracing.Insecure,
+		File:        c.Tracing.File,
+		ServiceName: c.Tracing.ServiceName,
+		SampleRatio: c.Tracing.SampleRatio,
+	})
 	if err != nil {
-		logger.Fatalf("error to parsing config: %v", err)
+		logger.Errorf("error to init tracing: %v", err)
+		return 1
 	}
+	// досылаем накопленные спаны, даже если коллектор уже недоступен
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warnf("error to flush traces: %v", err)
+		}
+	}()
 
 	// init db
 	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable",
 		c.CfgDB.Host, c.CfgDB.Port, c.CfgDB.Login, c.CfgDB.Password, c.CfgDB.Database,
 	)
-	db, err := sql.Open("postgres", dsn)
+	connector, err := pq.NewConnector(dsn)
 	if err != nil {
-		logger.Fatalf("error to database start: %v", err)
+		logger.Errorf("error to database start: %v", err)
+		return 1
 	}
+	// каждый SQL-запрос - спан в трассе HTTP-запроса
+	db := sql.OpenDB(tracing.WrapConnector(connector))
 
 	db.SetMaxOpenConns(c.MaxOpenConns)
+	if err := metrics.RegisterDB(db, c.CfgDB.Database); err != nil {
+		logger.Errorf("error to register database metrics: %v", err)
+		return 1
+	}
 
+	// без базы сервер бесполезен: пусть оркестратор перезапустит нас
 	err = db.Ping()
 	if err != nil {
-		logger.Infof("Failed to get response to ping: %v", err)
+		logger.Errorf("failed to get response to ping: %v", err)
+		return 1
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Warnf("error to close database: %v", err)
+		}
+	}()
+
+	mg, err := migrate.NewMigrator(db, logger)
+	if err != nil {
+		logger.Errorf("error to load migrations: %v", err)
+		return 1
+	}
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case cmdReconcile:
+			err = reconcile(context.Background(), ledger.NewLedgerDBRepository(db, logger), logger)
+		case cmdMigrate:
+			err = runMigrate(context.Background(), mg, flag.Args()[1:], logger)
+		default:
+			err = fmt.Errorf("unknown command: %s", flag.Arg(0))
+		}
+		if err != nil {
+			logger.Error(err)
+			return 1
+		}
+		return 0
 	}
 
-	sm := session.NewSessionManager(db, logger, c.Secret)
+	// реплики стартуют одновременно, миграции применит первая
+	// взявшая блокировку, остальные увидят их уже примененными
+	if c.CfgDB.AutoMigrate {
+		if _, err := mg.Up(context.Background()); err != nil {
+			logger.Errorf("error to apply migrations: %v", err)
+			return 1
+		}
+	} else if err := checkSchema(context.Background(), mg); err != nil {
+		logger.Errorf("%v, run `%s up`", err, cmdMigrate)
+		return 1
+	}
+
+	// ключи подписи токенов: на старте синхронизируемся с базой,
+	// дальше периодически подхватываем ротацию
+	kr, err := keyring.NewKeyDBRepository(db, logger, c.Secret, keyring.Options{
+		Alg:            c.JWT.Algorithm,
+		RotateEvery:    c.JWT.RotateEvery,
+		GracePeriod:    c.JWT.GracePeriod,
+		PreviousSecret: c.PreviousSecret,
+	})
+	if err != nil {
+		logger.Errorf("error to init signing keys: %v", err)
+		return 1
+	}
+	keys := keyring.New()
+	if err := kr.Sync(keys); err != nil {
+		// чаще всего secret сменили, а ключи в базе зашифрованы старым
+		if errors.Is(err, keyring.ErrCorruptedKey) {
+			logger.Errorf("error to load signing keys: %v: keys in signing_keys were sealed with another secret, "+
+				"start once with JWT_SECRET_PREVIOUS set to the old secret to re-seal them", err)
+			return 1
+		}
+		logger.Errorf("error to load signing keys: %v", err)
+		return 1
+	}
+	// SIGTERM/SIGINT отменяет ctx: фоновые задачи останавливаются,
+	// сервер дожидается начатых запросов
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	go syncSigningKeys(ctx, kr, keys, logger)
+
+	sm := session.NewSessionManager(db, logger, keys)
+	if c.Auth.AccessTokenTTL > 0 {
+		sm.AccessTTL = c.Auth.AccessTokenTTL
+	}
+	// иначе после ротации часть живых токенов перестанет проходить проверку
+	if kr.Options.GracePeriod < sm.AccessTTL {
+		logger.Errorf("jwt grace_period %s is shorter than access_token_ttl %s",
+			kr.Options.GracePeriod, sm.AccessTTL)
+		return 1
+	}
 	ur := user.NewUserDBRepository(db, logger)
+	ur.Registration = user.RegistrationPolicy{
+		AllowedLogins: c.Auth.AllowedLogins,
+		InviteTTL:     c.Auth.InviteTTL,
+	}
+	ur.Transfers = user.TransferPolicy{
+		MaxAmount:  c.Transfers.MaxAmount,
+		DailyLimit: c.Transfers.DailyLimit,
+	}
+	isolation, err := dbtx.ParseIsolation(c.Tx.Isolation)
+	if err != nil {
+		logger.Errorf("error to parse tx config: %v", err)
+		return 1
+	}
+	ur.Tx.Policy = dbtx.Policy{
+		Isolation:  isolation,
+		MaxRetries: c.Tx.MaxRetries,
+		BaseDelay:  c.Tx.BaseDelay,
+		MaxDelay:   c.Tx.MaxDelay,
+	}
+	ir := idempotency.NewIdempotencyDBRepository(db, logger, c.IdempotencyTTL, c.IdempotencyLease)
+
+	// периодически чистим истекшие ключи идемпотентности
+	go purgeIdempotencyKeys(ctx, ir, ir.TTL, logger)
+
+	errs := handlers.ErrorResponder{LegacyErrors: c.API.LegacyErrors}
 
 	userHandler := &handlers.UserHandlers{
-		Logger:   logger,
-		UserRepo: ur,
-		Sessions: sm,
+		ErrorResponder: errs,
+		Logger:         logger,
+		UserRepo:       ur,
+		Sessions:       sm,
+		Idempotency:    ir,
+
+		LegacyAutoRegister: c.Auth.LegacyAutoRegister,
+	}
+
+	cr := catalog.NewCatalogDBRepository(db, logger)
+
+	catalogHandler := &handlers.CatalogHandlers{
+		ErrorResponder: errs,
+		Logger:         logger,
+		Catalog:        cr,
+	}
+
+	ar := audit.NewAuditDBRepository(db, logger)
+	// события журнала встают в цепочку хэшей в фоне
+	go sealAudit(ctx, ar, audit.DefaultSealEvery, logger)
+
+	adminHandler := &handlers.AdminHandlers{
+		ErrorResponder: errs,
+		Logger:         logger,
+		Catalog:        cr,
+		Users:          ur,
+		Sessions:       sm,
+		Audit:          ar,
+	}
+
+	keysHandler := &handlers.KeysHandlers{
+		Logger: logger,
+		Keys:   keys,
+	}
+
+	timeouts := middleware.Timeouts{
+		Default: c.Timeouts.Default,
+		Routes:  c.Timeouts.Routes,
+	}
+	r := handlers.NewRouters(userHandler, catalogHandler, adminHandler, keysHandler, sm, timeouts, logger)
+	srv := newServer(c, r)
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		logger.Errorf("error to listen: %v", err)
+		return 1
 	}
 
-	r := handlers.NewRouters(userHandler, sm, logger)
 	logger.Infow("starting server",
 		"type", "START",
-		"addr", c.ServerPort,
+		"addr", srv.Addr,
 	)
+	grace := c.Server.ShutdownGrace
+	if grace <= 0 {
+		grace = defaultShutdownGrace
+	}
+	if err := serve(ctx, srv, ln, grace, logger); err != nil {
+		logger.Errorf("error to serve: %v", err)
+		return 1
+	}
+	logger.Info("server stopped")
+	return 0
+}
+
+func newServer(c *app.Config, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              c.ServerPort,
+		Handler:           h,
+		ReadTimeout:       c.Server.ReadTimeout,
+		ReadHeaderTimeout: c.Server.ReadHeaderTimeout,
+		WriteTimeout:      c.Server.WriteTimeout,
+		IdleTimeout:       c.Server.IdleTimeout,
+		MaxHeaderBytes:    c.Server.MaxHeaderBytes,
+	}
+}
+
+/*
+Сервер работает до отмены ctx. Дальше перестаем принимать новые
+соединения и ждем начатые запросы не дольше grace: покупка, которая
+уже пошла, должна успеть закоммититься.
+*/
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, grace time.Duration, logger *zap.SugaredLogger) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		// упали сами, а не по сигналу
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Infof("shutting down, waiting up to %s for in-flight requests", grace)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// не дождались: рвем оставшиеся соединения
+		logger.Warnf("error to shutdown gracefully: %v", err)
+		return srv.Close()
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func purgeIdempotencyKeys(ctx context.Context, ir idempotency.IdempotencyRepo, every time.Duration, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
 
-	err = http.ListenAndServe(c.ServerPort, r)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		n, err := ir.PurgeExpired(ctx)
+		if err != nil {
+			logger.Warnf("error to purge idempotency keys: %v", err)
+			continue
+		}
+		logger.Infof("purged %d expired idempotency keys", n)
+	}
+}
+
+func sealAudit(ctx context.Context, ar *audit.AuditDBRepository, every time.Duration, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := ar.Seal(ctx); err != nil {
+			logger.Warnf("error to seal audit events: %v", err)
+		}
+	}
+}
+
+func syncSigningKeys(ctx context.Context, kr *keyring.KeyDBRepository, keys *keyring.Keyring, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(kr.Options.SyncEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := kr.Sync(keys); err != nil {
+			logger.Warnf("error to sync signing keys: %v", err)
+		}
+	}
+}
+
+/*
+Подкоманда migrate:
+  - up 			-> применить все новые миграции
+  - down 		-> откатить последнюю
+  - to <version> 	-> привести схему к версии, 0 - откатить все
+  - status 		-> список миграций с временем применения
+*/
+func runMigrate(ctx context.Context, mg *migrate.Migrator, args []string, logger *zap.SugaredLogger) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s up|down|status|to <version>", cmdMigrate)
+	}
+
+	switch args[0] {
+	case "up":
+		n, err := mg.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("error to apply migrations: %w", err)
+		}
+		logger.Infof("applied %d migrations", n)
+	case "down":
+		if err := mg.Down(ctx); err != nil {
+			return fmt.Errorf("error to roll back migration: %w", err)
+		}
+	case "to":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: %s to <version>", cmdMigrate)
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad version %q: %w", args[1], err)
+		}
+		if err := mg.To(ctx, version); err != nil {
+			return fmt.Errorf("error to migrate to %d: %w", version, err)
+		}
+	case "status":
+		statuses, err := mg.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("error to get migrations status: %w", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, st := range statuses {
+			at := "pending"
+			if st.Applied() {
+				at = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", st.Version, st.Name, at)
+		}
+		if err := tw.Flush(); err != nil {
+			return fmt.Errorf("error to print status: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	return nil
+}
+
+// Без автомиграций сервер не должен стартовать на устаревшей схеме.
+func checkSchema(ctx context.Context, mg *migrate.Migrator) error {
+	statuses, err := mg.Status(ctx)
 	if err != nil {
-		panic("cant start server")
+		return err
+	}
+	for _, st := range statuses {
+		if !st.Applied() {
+			return fmt.Errorf("migration %d_%s is not applied", st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+// Сверка кэшированных балансов с журналом, отчет пишем в stdout.
+// Если нашли расхождения - завершаемся с ненулевым кодом.
+func reconcile(ctx context.Context, lr ledger.LedgerRepo, logger *zap.SugaredLogger) error {
+	rep, err := lr.Reconcile(ctx)
+	if err != nil {
+		return fmt.Errorf("error to reconcile ledger: %w", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(rep); err != nil {
+		return fmt.Errorf("error to print report: %w", err)
+	}
+
+	if !rep.OK() {
+		return fmt.Errorf("ledger is inconsistent: %d users, %d entries",
+			len(rep.Discrepancies), len(rep.UnbalancedEntries))
 	}
+	logger.Info("ledger is consistent")
+	return nil
 }
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// Сервер на свободном порту с медленным обработчиком: started
+// закрывается, когда запрос дошел, ответ уходит после release.
+func newSlowServer(t *testing.T) (*http.Server, net.Listener, chan struct{}, chan struct{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		select {
+		case <-release:
+			io.WriteString(w, "done")
+		case <-r.Context().Done():
+		}
+	})}
+	return srv, ln, started, release
+}
+
+type result struct {
+	body string
+	err  error
+}
+
+// Запрос в отдельной горутине, ответ читаем целиком.
+func get(url string) <-chan result {
+	ch := make(chan result, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		ch <- result{body: string(body), err: err}
+	}()
+	return ch
+}
+
+func TestServe(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"in-flight request completes": func(t *testing.T) {
+			srv, ln, started, release := newSlowServer(t)
+			addr := ln.Addr().String()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- serve(ctx, srv, ln, time.Minute, zap.NewNop().Sugar()) }()
+
+			resp := get("http://" + addr)
+			<-started
+			cancel()
+
+			// слушатель закрыт сразу, новые соединения не принимаются
+			require.Eventually(t, func() bool {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					return true
+				}
+				conn.Close()
+				return false
+			}, 5*time.Second, 10*time.Millisecond)
+
+			close(release)
+			res := <-resp
+			require.NoError(t, res.err)
+			require.Equal(t, "done", res.body)
+			require.NoError(t, <-done)
+		},
+
+		"connections are closed after grace": func(t *testing.T) {
+			srv, ln, started, release := newSlowServer(t)
+			defer close(release)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- serve(ctx, srv, ln, 50*time.Millisecond, zap.NewNop().Sugar()) }()
+
+			resp := get("http://" + ln.Addr().String())
+			<-started
+			cancel()
+
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+			case <-time.After(5 * time.Second):
+				t.Fatal("serve did not return after grace")
+			}
+			// обработчик еще ждет, соединение оборвал srv.Close
+			require.Error(t, (<-resp).err)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
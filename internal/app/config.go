@@ -1,37 +1,125 @@
 package app
 
 import (
-	"os"
-
-	"gopkg.in/yaml.v2"
+	"time"
 )
 
+/*
+Конфиг собирается слоями, каждый следующий перекрывает предыдущий:
+  - значения по умолчанию 	-> Default
+  - YAML-файл 				-> флаг -config
+  - переменные окружения 	-> тег env
+  - файлы секретов 			-> поля с тегом secret, см. Load
+*/
 type Config struct {
 	CfgDB        ConfigDB `yaml:"db"`
-	MaxOpenConns int      `yaml:"max_open_conns"`
-	Secret       string   `yaml:"secret"`
-	ServerPort   string   `yaml:"srv_port"`
+	MaxOpenConns int      `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	// Ключ шифрования ключей подписи в базе. В репозитории не храним:
+	// задается через JWT_SECRET или файл секрета
+	Secret string `yaml:"secret" env:"JWT_SECRET" secret:"true"`
+	// Прежний secret на время его смены: ключи подписи, зашифрованные
+	// им, перешифровываются текущим на старте. Потом можно убрать
+	PreviousSecret string `yaml:"previous_secret" env:"JWT_SECRET_PREVIOUS" secret:"true"`
+	ServerPort     string `yaml:"srv_port" env:"SRV_PORT"`
+
+	// Сколько храним ключи идемпотентности для sendCoin/buy
+	IdempotencyTTL time.Duration `yaml:"idempotency_ttl" env:"IDEMPOTENCY_TTL"`
+	// Через сколько ключ, оставшийся "в процессе", можно занять снова.
+	// Должно быть больше самого долгого таймаута sendCoin/buy
+	IdempotencyLease time.Duration `yaml:"idempotency_lease" env:"IDEMPOTENCY_LEASE"`
+
+	Auth      ConfigAuth      `yaml:"auth"`
+	JWT       ConfigJWT       `yaml:"jwt"`
+	API       ConfigAPI       `yaml:"api"`
+	Transfers ConfigTransfers `yaml:"transfers"`
+	Tx        ConfigTx        `yaml:"tx"`
+	Timeouts  ConfigTimeouts  `yaml:"timeouts"`
+	Server    ConfigServer    `yaml:"server"`
+	Tracing   ConfigTracing   `yaml:"tracing"`
+}
+
+type ConfigTracing struct {
+	// none, stdout, file или otlp
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// host:port коллектора для otlp
+	Endpoint string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	Insecure bool   `yaml:"insecure" env:"TRACING_INSECURE"`
+	// Куда дописываются спаны для exporter: file
+	File        string `yaml:"file" env:"TRACING_FILE"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+	// Доля записываемых трасс, от 0 до 1
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO"`
+}
+
+type ConfigServer struct {
+	ReadTimeout       time.Duration `yaml:"read_timeout" env:"SERVER_READ_TIMEOUT"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT"`
+	// Должен быть больше самого длинного таймаута маршрута,
+	// иначе клиент не получит 504
+	WriteTimeout   time.Duration `yaml:"write_timeout" env:"SERVER_WRITE_TIMEOUT"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT"`
+	MaxHeaderBytes int           `yaml:"max_header_bytes" env:"SERVER_MAX_HEADER_BYTES"`
+	// Сколько ждем завершения начатых запросов после SIGTERM
+	ShutdownGrace time.Duration `yaml:"shutdown_grace" env:"SERVER_SHUTDOWN_GRACE"`
+}
+
+type ConfigTimeouts struct {
+	// Срок запроса по умолчанию, 0 - без ограничения
+	Default time.Duration `yaml:"default" env:"TIMEOUTS_DEFAULT"`
+	// Сроки отдельных маршрутов по шаблону пути, например "/api/buy/{item}"
+	Routes map[string]time.Duration `yaml:"routes"`
+}
+
+type ConfigTx struct {
+	// read committed, repeatable read или serializable,
+	// пусто - уровень по умолчанию у базы
+	Isolation string `yaml:"isolation" env:"TX_ISOLATION"`
+	// Сколько раз повторяем транзакцию после дедлока
+	// или конфликта сериализации
+	MaxRetries int           `yaml:"max_retries" env:"TX_MAX_RETRIES"`
+	BaseDelay  time.Duration `yaml:"base_delay" env:"TX_BASE_DELAY"`
+	MaxDelay   time.Duration `yaml:"max_delay" env:"TX_MAX_DELAY"`
+}
+
+type ConfigTransfers struct {
+	// Максимум одного перевода, 0 - без ограничения
+	MaxAmount int `yaml:"max_amount" env:"TRANSFERS_MAX_AMOUNT"`
+	// Сколько можно отправить за сутки, 0 - без ограничения
+	DailyLimit int `yaml:"daily_limit" env:"TRANSFERS_DAILY_LIMIT"`
+}
+
+type ConfigAPI struct {
+	// Ошибки в старом формате {"errors": "..."} вместо problem+json
+	LegacyErrors bool `yaml:"legacy_errors" env:"API_LEGACY_ERRORS"`
+}
+
+type ConfigJWT struct {
+	// RS256 или EdDSA
+	Algorithm string `yaml:"algorithm" env:"JWT_ALGORITHM"`
+	// Как часто выпускается новый ключ подписи
+	RotateEvery time.Duration `yaml:"rotate_every" env:"JWT_ROTATE_EVERY"`
+	// Сколько старый ключ еще принимается после ротации
+	GracePeriod time.Duration `yaml:"grace_period" env:"JWT_GRACE_PERIOD"`
+}
+
+type ConfigAuth struct {
+	// /api/auth создает пользователя по неизвестному логину, как раньше
+	LegacyAutoRegister bool `yaml:"legacy_auto_register" env:"AUTH_LEGACY_AUTO_REGISTER"`
+	// Логины, которые могут зарегистрироваться без приглашения
+	AllowedLogins []string `yaml:"allowed_logins" env:"AUTH_ALLOWED_LOGINS"`
+	// Срок действия приглашения
+	InviteTTL time.Duration `yaml:"invite_ttl" env:"AUTH_INVITE_TTL"`
+	// Время жизни access-токена, дальше - через /api/token/refresh
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl" env:"AUTH_ACCESS_TOKEN_TTL"`
 }
 
 type ConfigDB struct {
-	Login    string `yaml:"login"`
-	Password string `yaml:"password"`
-	Port     uint   `yaml:"port"`
-	Database string `yaml:"database"`
-	Host     string `yaml:"host"`
-}
-
-func NewConfig(configPath string) (*Config, error) {
-	cfg, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, err
-	}
-
-	var c Config
-	err = yaml.Unmarshal(cfg, &c)
-	if err != nil {
-		return nil, err
-	}
-
-	return &c, nil
+	Login    string `yaml:"login" env:"DB_USER"`
+	Password string `yaml:"password" env:"DB_PASSWORD" secret:"true"`
+	Port     uint   `yaml:"port" env:"DB_PORT"`
+	Database string `yaml:"database" env:"DB_NAME"`
+	Host     string `yaml:"host" env:"DB_HOST"`
+	// Применять миграции при старте сервера. Иначе - только
+	// подкомандой migrate, а сервер не поднимется на старой схеме.
+	AutoMigrate bool `yaml:"auto_migrate" env:"DB_AUTO_MIGRATE"`
 }
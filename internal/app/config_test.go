@@ -0,0 +1,268 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const strongSecret = "0123456789abcdef0123456789abcdef"
+
+func lookupEnv(env map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, dir, name, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(data), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	cfgPath := writeFile(t, dir, "config.yaml", `
+db:
+  host: db
+  password: from-file
+srv_port: :9090
+tx:
+  max_retries: 5
+auth:
+  allowed_logins: [alice]
+`)
+	secrets := t.TempDir()
+	writeFile(t, secrets, "jwt_secret", strongSecret+"\n")
+	explicit := writeFile(t, dir, "db_password", "from-secret-file")
+
+	tests := map[string]struct {
+		opts  LoadOptions
+		check func(t *testing.T, c *Config)
+	}{
+		"defaults only": {
+			opts: LoadOptions{SecretsDir: t.TempDir()},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, Default(), *c)
+			},
+		},
+		"file overrides defaults": {
+			opts: LoadOptions{Path: cfgPath, SecretsDir: t.TempDir()},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, "db", c.CfgDB.Host)
+				require.Equal(t, ":9090", c.ServerPort)
+				require.Equal(t, 5, c.Tx.MaxRetries)
+				// не заданное в файле остается по умолчанию
+				require.Equal(t, uint(5432), c.CfgDB.Port)
+				require.Equal(t, 10*time.Millisecond, c.Tx.BaseDelay)
+			},
+		},
+		"env overrides file": {
+			opts: LoadOptions{
+				Path:       cfgPath,
+				SecretsDir: t.TempDir(),
+				LookupEnv: lookupEnv(map[string]string{
+					"DB_HOST":              "postgres.internal",
+					"DB_PORT":              "6432",
+					"TX_MAX_RETRIES":       "1",
+					"TX_BASE_DELAY":        "50ms",
+					"API_LEGACY_ERRORS":    "true",
+					"AUTH_ALLOWED_LOGINS":  "bob, carol",
+					"TRACING_EXPORTER":     "otlp",
+					"TRACING_SAMPLE_RATIO": "0.25",
+				}),
+			},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, "postgres.internal", c.CfgDB.Host)
+				require.Equal(t, uint(6432), c.CfgDB.Port)
+				require.Equal(t, 1, c.Tx.MaxRetries)
+				require.Equal(t, 50*time.Millisecond, c.Tx.BaseDelay)
+				require.True(t, c.API.LegacyErrors)
+				require.Equal(t, []string{"bob", "carol"}, c.Auth.AllowedLogins)
+				require.Equal(t, ":9090", c.ServerPort)
+				require.Equal(t, "otlp", c.Tracing.Exporter)
+				require.Equal(t, 0.25, c.Tracing.SampleRatio)
+			},
+		},
+		"secrets dir": {
+			opts: LoadOptions{
+				Path:       cfgPath,
+				SecretsDir: secrets,
+				LookupEnv: lookupEnv(map[string]string{
+					"JWT_SECRET":          "from-env",
+					"JWT_SECRET_PREVIOUS": "mysuperpupermegaultraSecret",
+				}),
+			},
+			check: func(t *testing.T, c *Config) {
+				// файл секрета важнее окружения, перевод строки обрезан
+				require.Equal(t, strongSecret, c.Secret)
+				require.Equal(t, "mysuperpupermegaultraSecret", c.PreviousSecret)
+				require.Equal(t, "from-file", c.CfgDB.Password)
+			},
+		},
+		"explicit secret file": {
+			opts: LoadOptions{
+				Path:       cfgPath,
+				SecretsDir: secrets,
+				LookupEnv:  lookupEnv(map[string]string{"DB_PASSWORD_FILE": explicit}),
+			},
+			check: func(t *testing.T, c *Config) {
+				require.Equal(t, "from-secret-file", c.CfgDB.Password)
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if tt.opts.LookupEnv == nil {
+				tt.opts.LookupEnv = lookupEnv(nil)
+			}
+			c, err := Load(tt.opts)
+			require.NoError(t, err)
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	dir := t.TempDir()
+	broken := writeFile(t, dir, "broken.yaml", "db: [")
+
+	tests := map[string]struct {
+		opts LoadOptions
+		err  error
+	}{
+		"missing file": {
+			opts: LoadOptions{Path: filepath.Join(dir, "nope.yaml")},
+			err:  ErrReadConfig,
+		},
+		"broken yaml": {
+			opts: LoadOptions{Path: broken},
+			err:  ErrParseConfig,
+		},
+		"bad env value": {
+			opts: LoadOptions{LookupEnv: lookupEnv(map[string]string{"TX_BASE_DELAY": "soon"})},
+			err:  ErrParseConfig,
+		},
+		"missing explicit secret file": {
+			opts: LoadOptions{LookupEnv: lookupEnv(map[string]string{"JWT_SECRET_FILE": filepath.Join(dir, "nope")})},
+			err:  ErrReadConfig,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			tt.opts.SecretsDir = dir
+			if tt.opts.LookupEnv == nil {
+				tt.opts.LookupEnv = lookupEnv(nil)
+			}
+			_, err := Load(tt.opts)
+			require.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() Config {
+		c := Default()
+		c.CfgDB.Host = "db"
+		c.CfgDB.Password = "love"
+		c.Secret = strongSecret
+		return c
+	}
+
+	tests := map[string]struct {
+		mutate   func(c *Config)
+		errs     []string
+		warnings []string
+	}{
+		"valid": {
+			mutate: func(c *Config) {},
+		},
+		"missing secret and host": {
+			mutate: func(c *Config) {
+				c.Secret = ""
+				c.CfgDB.Host = ""
+			},
+			errs: []string{"db.host is required", "secret is required"},
+		},
+		"bad values": {
+			mutate: func(c *Config) {
+				c.JWT.Algorithm = "HS256"
+				c.Tx.Isolation = "snapshot"
+				c.MaxOpenConns = 0
+				c.Timeouts.Routes = map[string]time.Duration{"/api/info": -time.Second}
+			},
+			errs: []string{"jwt.algorithm", "tx.isolation", "max_open_conns", "timeouts.routes[/api/info]"},
+		},
+		"weak secret and no password": {
+			mutate: func(c *Config) {
+				c.Secret = "mysuperpupermegaultraSecret"
+				c.CfgDB.Password = ""
+			},
+			warnings: []string{"db.password is empty", "secret is weak"},
+		},
+		"bad tracing": {
+			mutate: func(c *Config) {
+				c.Tracing.Exporter = "file"
+				c.Tracing.SampleRatio = 1.5
+			},
+			errs: []string{"tracing.file", "tracing.sample_ratio"},
+		},
+		"route timeout longer than write timeout": {
+			mutate: func(c *Config) {
+				c.Timeouts.Routes = map[string]time.Duration{"/api/history": time.Minute}
+			},
+			warnings: []string{"timeouts.routes[/api/history]"},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			c := valid()
+			tt.mutate(&c)
+
+			warnings, err := c.Validate()
+
+			require.Len(t, warnings, len(tt.warnings))
+			for i, w := range tt.warnings {
+				require.Contains(t, warnings[i], w)
+			}
+
+			if len(tt.errs) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrInvalidConfig)
+			require.Len(t, strings.Split(err.Error(), "\n"), len(tt.errs))
+			for _, e := range tt.errs {
+				require.Contains(t, err.Error(), e)
+			}
+		})
+	}
+}
+
+func TestConfig_Redacted(t *testing.T) {
+	c := Default()
+	c.Secret = strongSecret
+	c.PreviousSecret = "mysuperpupermegaultraSecret"
+	c.CfgDB.Password = "love"
+
+	r := c.Redacted()
+
+	require.Equal(t, redacted, r.Secret)
+	require.Equal(t, redacted, r.PreviousSecret)
+	require.Equal(t, redacted, r.CfgDB.Password)
+	require.Equal(t, c.CfgDB.Login, r.CfgDB.Login)
+	// исходный конфиг не тронут
+	require.Equal(t, strongSecret, c.Secret)
+
+	// пустой секрет так и показываем пустым, чтобы было видно, что он не задан
+	require.Empty(t, Default().Redacted().Secret)
+}
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"proj/internal/dbtx"
+	"proj/internal/idempotency"
+	"proj/internal/keyring"
+	"proj/internal/session"
+	"proj/internal/tracing"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// Docker монтирует секреты сюда
+	DefaultSecretsDir = "/run/secrets"
+
+	// Секрет короче этого легко подобрать
+	minSecretLen = 32
+
+	redacted = "[REDACTED]"
+)
+
+var (
+	ErrReadConfig    = errors.New("error to read config file")
+	ErrParseConfig   = errors.New("error to parse config")
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+type LoadOptions struct {
+	// YAML-файл конфига, пусто - только умолчания и окружение
+	Path string
+	// Каталог файлов секретов, пусто - DefaultSecretsDir
+	SecretsDir string
+	// Чтение окружения, в тестах подменяется. nil - os.LookupEnv
+	LookupEnv func(string) (string, bool)
+}
+
+// Значения, с которыми сервер поднимается без файла конфига.
+// Секретов и адреса базы здесь нет: их нужно задать явно.
+func Default() Config {
+	return Config{
+		CfgDB: ConfigDB{
+			Login:       "postgres",
+			Port:        5432,
+			Database:    "store",
+			AutoMigrate: true,
+		},
+		MaxOpenConns:     10,
+		ServerPort:       ":8080",
+		IdempotencyTTL:   idempotency.DefaultTTL,
+		IdempotencyLease: idempotency.DefaultLease,
+		Auth: ConfigAuth{
+			LegacyAutoRegister: true,
+			InviteTTL:          7 * 24 * time.Hour,
+			AccessTokenTTL:     session.DefaultAccessTTL,
+		},
+		JWT: ConfigJWT{
+			Algorithm:   keyring.AlgEdDSA,
+			RotateEvery: keyring.DefaultRotateEvery,
+			GracePeriod: keyring.DefaultGracePeriod,
+		},
+		Tx: ConfigTx{
+			Isolation:  "read committed",
+			MaxRetries: dbtx.DefaultMaxRetries,
+			BaseDelay:  dbtx.DefaultBaseDelay,
+			MaxDelay:   dbtx.DefaultMaxDelay,
+		},
+		Timeouts: ConfigTimeouts{
+			Default: 5 * time.Second,
+		},
+		Server: ConfigServer{
+			ReadTimeout:       10 * time.Second,
+			ReadHeaderTimeout: 5 * time.Second,
+			WriteTimeout:      15 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			MaxHeaderBytes:    1 << 16,
+			ShutdownGrace:     20 * time.Second,
+		},
+		Tracing: ConfigTracing{
+			Exporter:    tracing.ExporterNone,
+			ServiceName: tracing.DefaultServiceName,
+			SampleRatio: 1,
+		},
+	}
+}
+
+/*
+Собирает конфиг по слоям: умолчания, YAML-файл, переменные окружения
+из тегов env, затем секреты. Секрет читается из файла, путь к которому
+лежит в <ENV>_FILE, либо из <SecretsDir>/<env в нижнем регистре>, как
+монтирует docker secrets. Файл секрета перекрывает переменную окружения.
+Проверку значений делает Validate.
+*/
+func Load(opts LoadOptions) (*Config, error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	if opts.SecretsDir == "" {
+		opts.SecretsDir = DefaultSecretsDir
+	}
+
+	c := Default()
+
+	if opts.Path != "" {
+		data, err := os.ReadFile(opts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrReadConfig, err)
+		}
+		if err := yaml.Unmarshal(data, &c); err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrParseConfig, opts.Path, err)
+		}
+	}
+
+	err := walkEnv(reflect.ValueOf(&c).Elem(), func(f reflect.Value, env string, secret bool) error {
+		if v, ok := opts.LookupEnv(env); ok {
+			if err := setField(f, v); err != nil {
+				return fmt.Errorf("%w: %s: %w", ErrParseConfig, env, err)
+			}
+		}
+		if !secret {
+			return nil
+		}
+
+		v, ok, err := readSecret(opts, env)
+		if err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrReadConfig, env, err)
+		}
+		if ok {
+			f.SetString(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
+func readSecret(opts LoadOptions, env string) (string, bool, error) {
+	path, explicit := opts.LookupEnv(env + "_FILE")
+	if !explicit {
+		path = filepath.Join(opts.SecretsDir, strings.ToLower(env))
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !explicit {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// Обходит поля с тегом env, вложенные структуры - рекурсивно.
+func walkEnv(v reflect.Value, fn func(f reflect.Value, env string, secret bool) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, f := t.Field(i), v.Field(i)
+
+		if env := sf.Tag.Get("env"); env != "" {
+			if err := fn(f, env, sf.Tag.Get("secret") == "true"); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Kind() == reflect.Struct {
+			if err := walkEnv(f, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setField(f reflect.Value, s string) error {
+	if f.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(n))
+	case reflect.Uint:
+		n, err := strconv.ParseUint(s, 10, 0)
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Slice:
+		// список через запятую, пустая строка - пустой список
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", f.Type())
+	}
+	return nil
+}
+
+/*
+Проверяет собранный конфиг. Ошибки - то, с чем сервер не поднимется
+или поднимется небезопасно, предупреждения - то, что стоит поправить.
+*/
+func (c *Config) Validate() (warnings []string, err error) {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	if c.CfgDB.Host == "" {
+		invalid("db.host is required (DB_HOST)")
+	}
+	if c.CfgDB.Login == "" {
+		invalid("db.login is required (DB_USER)")
+	}
+	if c.CfgDB.Database == "" {
+		invalid("db.database is required (DB_NAME)")
+	}
+	if c.CfgDB.Port == 0 {
+		invalid("db.port is required (DB_PORT)")
+	}
+	if c.CfgDB.Password == "" {
+		warnings = append(warnings, "db.password is empty (DB_PASSWORD)")
+	}
+	if c.MaxOpenConns <= 0 {
+		invalid("max_open_conns must be positive, got %d", c.MaxOpenConns)
+	}
+	if c.ServerPort == "" {
+		invalid("srv_port is required (SRV_PORT)")
+	}
+
+	switch {
+	case c.Secret == "":
+		invalid("secret is required (JWT_SECRET, JWT_SECRET_FILE or %s/jwt_secret)", DefaultSecretsDir)
+	case len(c.Secret) < minSecretLen:
+		warnings = append(warnings, fmt.Sprintf("secret is weak: %d characters, want at least %d", len(c.Secret), minSecretLen))
+	}
+
+	if !keyring.ValidAlg(c.JWT.Algorithm) {
+		invalid("unknown jwt.algorithm %q", c.JWT.Algorithm)
+	}
+	if _, err := dbtx.ParseIsolation(c.Tx.Isolation); err != nil {
+		invalid("tx.isolation: %v", err)
+	}
+	if c.Tx.MaxRetries < 0 {
+		invalid("tx.max_retries must not be negative, got %d", c.Tx.MaxRetries)
+	}
+	if c.Transfers.MaxAmount < 0 || c.Transfers.DailyLimit < 0 {
+		invalid("transfers limits must not be negative")
+	}
+
+	if !tracing.ValidExporter(c.Tracing.Exporter) {
+		invalid("unknown tracing.exporter %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.Exporter == tracing.ExporterFile && c.Tracing.File == "" {
+		invalid("tracing.file is required for file exporter (TRACING_FILE)")
+	}
+	if r := c.Tracing.SampleRatio; r < 0 || r > 1 {
+		invalid("tracing.sample_ratio must be between 0 and 1, got %g", r)
+	}
+
+	durations := []struct {
+		name string
+		d    time.Duration
+	}{
+		{"idempotency_ttl", c.IdempotencyTTL},
+		{"idempotency_lease", c.IdempotencyLease},
+		{"auth.invite_ttl", c.Auth.InviteTTL},
+		{"auth.access_token_ttl", c.Auth.AccessTokenTTL},
+		{"jwt.rotate_every", c.JWT.RotateEvery},
+		{"jwt.grace_period", c.JWT.GracePeriod},
+		{"tx.base_delay", c.Tx.BaseDelay},
+		{"tx.max_delay", c.Tx.MaxDelay},
+		{"timeouts.default", c.Timeouts.Default},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_grace", c.Server.ShutdownGrace},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			invalid("%s must not be negative, got %s", d.name, d.d)
+		}
+	}
+
+	routes := make([]string, 0, len(c.Timeouts.Routes))
+	for route := range c.Timeouts.Routes {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		if d := c.Timeouts.Routes[route]; d < 0 {
+			invalid("timeouts.routes[%s] must not be negative, got %s", route, d)
+		}
+	}
+
+	// иначе сервер оборвет соединение раньше, чем хендлер ответит 504
+	if wt := c.Server.WriteTimeout; wt > 0 {
+		if c.Timeouts.Default >= wt {
+			warnings = append(warnings, fmt.Sprintf("timeouts.default %s is not shorter than server.write_timeout %s", c.Timeouts.Default, wt))
+		}
+		for _, route := range routes {
+			if d := c.Timeouts.Routes[route]; d >= wt {
+				warnings = append(warnings, fmt.Sprintf("timeouts.routes[%s] %s is not shorter than server.write_timeout %s", route, d, wt))
+			}
+		}
+	}
+
+	return warnings, errors.Join(errs...)
+}
+
+// Копия конфига для вывода: заданные секреты заменены заглушкой.
+func (c Config) Redacted() Config {
+	_ = walkEnv(reflect.ValueOf(&c).Elem(), func(f reflect.Value, _ string, secret bool) error {
+		if secret && f.String() != "" {
+			f.SetString(redacted)
+		}
+		return nil
+	})
+	return c
+}
@@ -0,0 +1,178 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Действия, которые попадают в журнал.
+const (
+	ActionTransfer     = "coins.transfer"
+	ActionPurchase     = "item.purchase"
+	ActionLogin        = "auth.login"
+	ActionLoginFailed  = "auth.login_failed"
+	ActionRegister     = "user.register"
+	ActionUserCreate   = "user.create"
+	ActionSetRoles     = "user.set_roles"
+	ActionUserActivate = "user.activate"
+	ActionUserOff      = "user.deactivate"
+	ActionPasswordSet  = "user.reset_password"
+	ActionAdjust       = "balance.adjust"
+	ActionInvite       = "invite.create"
+	ActionRevoke       = "session.revoke"
+	ActionRevokeAll    = "session.revoke_all"
+	ActionCatalogAdd   = "catalog.create"
+	ActionCatalogEdit  = "catalog.update"
+	ActionCatalogOff   = "catalog.deactivate"
+	ActionCatalogPrice = "catalog.reprice"
+)
+
+// Хэш перед первой записью журнала.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+type Event struct {
+	ID            int64           `json:"id"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	Actor         string          `json:"actor"`
+	Action        string          `json:"action"`
+	Target        string          `json:"target,omitempty"`
+	BalanceBefore *int            `json:"balanceBefore,omitempty"`
+	BalanceAfter  *int            `json:"balanceAfter,omitempty"`
+	RequestID     string          `json:"requestId,omitempty"`
+	IP            string          `json:"ip,omitempty"`
+	Details       json.RawMessage `json:"details,omitempty"`
+	PrevHash      string          `json:"prevHash"`
+	Hash          string          `json:"hash"`
+}
+
+// Новое событие с данными запроса из контекста.
+func NewEvent(ctx context.Context, action, target string) Event {
+	m := MetaFrom(ctx)
+	return Event{
+		Actor:     m.Actor,
+		Action:    action,
+		Target:    target,
+		RequestID: m.RequestID,
+		IP:        m.IP,
+	}
+}
+
+func (e Event) WithActor(actor string) Event {
+	e.Actor = actor
+	return e
+}
+
+func (e Event) WithBalances(before, after int) Event {
+	e.BalanceBefore, e.BalanceAfter = &before, &after
+	return e
+}
+
+// Подробности события. Значения должны сериализоваться в JSON,
+// иначе подробности не сохраняются.
+func (e Event) WithDetails(d map[string]interface{}) Event {
+	if b, err := json.Marshal(d); err == nil {
+		e.Details = b
+	}
+	return e
+}
+
+/*
+Хэш события: sha256 от хэша предыдущего события и полей записи,
+разделенных переводом строки. Отсутствующий баланс и пустые
+подробности кодируются явно, чтобы NULL и 0 давали разные хэши.
+*/
+func (e Event) ComputeHash() string {
+	details := string(e.Details)
+	if details == "" {
+		details = "{}"
+	}
+
+	fields := []string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.Action,
+		e.Target,
+		optInt(e.BalanceBefore),
+		optInt(e.BalanceAfter),
+		e.RequestID,
+		e.IP,
+		details,
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func optInt(v *int) string {
+	if v == nil {
+		return "null"
+	}
+	return strconv.Itoa(*v)
+}
+
+// Фильтр для выборки журнала. Нулевые значения - без ограничения.
+type Filter struct {
+	Actor  string
+	Action string
+	Target string
+	From   time.Time // включительно
+	To     time.Time // не включительно
+	Limit  int
+	Cursor string
+}
+
+type Page struct {
+	Items      []Event `json:"items"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// Результат проверки цепочки.
+type VerifyReport struct {
+	Checked int64 `json:"checked"`
+	// Записанные события, которые еще не попали в цепочку
+	Pending int64 `json:"pending"`
+	// Первое событие, на котором цепочка не сошлась
+	BrokenAt *int64 `json:"brokenAt,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func (r VerifyReport) OK() bool {
+	return r.BrokenAt == nil && r.Reason == ""
+}
+
+type AuditRepo interface {
+	Query(ctx context.Context, f Filter) (Page, error)
+	Verify(ctx context.Context) (VerifyReport, error)
+}
+
+type metaKey struct{}
+
+// Кто и откуда выполняет запрос. Middleware кладет в контекст запроса,
+// утилита оператора - в свой контекст; репозитории берут при записи события.
+type Meta struct {
+	Actor     string
+	RequestID string
+	IP        string
+}
+
+func WithMeta(ctx context.Context, m Meta) context.Context {
+	return context.WithValue(ctx, metaKey{}, m)
+}
+
+func MetaFrom(ctx context.Context) Meta {
+	m, _ := ctx.Value(metaKey{}).(Meta)
+	return m
+}
+
+// Подставляет автора, не трогая остальные данные запроса.
+func WithActor(ctx context.Context, actor string) context.Context {
+	m := MetaFrom(ctx)
+	m.Actor = actor
+	return WithMeta(ctx, m)
+}
@@ -0,0 +1,60 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvent_ComputeHash(t *testing.T) {
+	base := Event{
+		CreatedAt: time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC),
+		Actor:     "user1",
+		Action:    ActionTransfer,
+		Target:    "user2",
+		PrevHash:  GenesisHash,
+	}.WithBalances(100, 50)
+
+	h := base.ComputeHash()
+	assert.Len(t, h, 64)
+	assert.Equal(t, h, base.ComputeHash())
+
+	// пустые подробности и {} - одно и то же
+	withEmpty := base
+	withEmpty.Details = []byte("{}")
+	assert.Equal(t, h, withEmpty.ComputeHash())
+
+	// часовой пояс времени не влияет на хэш
+	moscow := base
+	moscow.CreatedAt = base.CreatedAt.In(time.FixedZone("MSK", 3*60*60))
+	assert.Equal(t, h, moscow.ComputeHash())
+
+	tests := map[string]func(e Event) Event{
+		"actor":      func(e Event) Event { e.Actor = "user3"; return e },
+		"prev hash":  func(e Event) Event { e.PrevHash = "ff"; return e },
+		"balance":    func(e Event) Event { return e.WithBalances(100, 49) },
+		"no balance": func(e Event) Event { e.BalanceBefore, e.BalanceAfter = nil, nil; return e },
+		"zero balance": func(e Event) Event {
+			return e.WithBalances(0, 0)
+		},
+		"details": func(e Event) Event { return e.WithDetails(map[string]interface{}{"amount": 50}) },
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.NotEqual(t, h, change(base).ComputeHash())
+		})
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	ctx := WithMeta(context.Background(), Meta{RequestID: "req1", IP: "10.0.0.1"})
+	ctx = WithActor(ctx, "user1")
+
+	e := NewEvent(ctx, ActionPurchase, "cup")
+	assert.Equal(t, Event{Actor: "user1", Action: ActionPurchase, Target: "cup", RequestID: "req1", IP: "10.0.0.1"}, e)
+
+	// без данных запроса событие все равно создается
+	assert.Equal(t, Event{Action: ActionLogin}, NewEvent(context.Background(), ActionLogin, ""))
+}
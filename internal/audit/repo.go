@@ -0,0 +1,454 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"proj/internal/dbtx"
+	"proj/internal/tracing"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+
+	// Как часто сервер досчитывает цепочку и сколько событий за транзакцию
+	DefaultSealEvery = time.Second
+	SealBatch        = 500
+)
+
+var (
+	ErrInternalDB    = errors.New("database internal error")
+	ErrInvalidFilter = errors.New("invalid audit filter")
+)
+
+/*
+Запись события внутри транзакции вызывающего кода: событие
+сохраняется, только если сохранилось само действие. В цепочку
+событие ставит Seal уже после коммита, поэтому запись не берет
+общих блокировок и не выстраивает действия в очередь.
+*/
+func Record(ctx context.Context, tx *sql.Tx, e Event, l *zap.SugaredLogger) (Event, error) {
+	// postgres хранит микросекунды, хэш должен совпасть после чтения
+	e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	if len(e.Details) == 0 {
+		e.Details = []byte("{}")
+	}
+
+	q := `
+	INSERT INTO audit_events (created_at, actor, action, target, balance_before, balance_after,
+		request_id, ip, details)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	RETURNING event_id
+	`
+	err := tx.QueryRowContext(ctx, q,
+		e.CreatedAt, e.Actor, e.Action, e.Target, e.BalanceBefore, e.BalanceAfter,
+		e.RequestID, e.IP, string(e.Details),
+	).Scan(&e.ID)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return Event{}, dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return e, nil
+}
+
+// Событие без своей транзакции, например неудачный вход:
+// самого действия в базе нет, пишем только журнал. Это одна
+// вставка без блокировок, поток неудачных входов не тормозит
+// остальные записи.
+func Write(ctx context.Context, db *sql.DB, e Event, l *zap.SugaredLogger) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	if _, err := Record(ctx, tx, e, l); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	return nil
+}
+
+type AuditDBRepository struct {
+	DB     *sql.DB
+	Logger *zap.SugaredLogger
+}
+
+func NewAuditDBRepository(db *sql.DB, l *zap.SugaredLogger) *AuditDBRepository {
+	return &AuditDBRepository{
+		DB:     db,
+		Logger: l,
+	}
+}
+
+const eventColumns = `event_id, created_at, actor, action, target, balance_before, balance_after,
+	request_id, ip, details, prev_hash, hash`
+
+func scanEvent(rows *sql.Rows) (Event, error) {
+	var (
+		e        Event
+		before   sql.NullInt64
+		after    sql.NullInt64
+		details  string
+		prevHash sql.NullString
+		hash     sql.NullString
+	)
+	err := rows.Scan(&e.ID, &e.CreatedAt, &e.Actor, &e.Action, &e.Target, &before, &after,
+		&e.RequestID, &e.IP, &details, &prevHash, &hash)
+	if err != nil {
+		return Event{}, err
+	}
+
+	e.CreatedAt = e.CreatedAt.UTC()
+	e.Details = []byte(details)
+	// событие еще не в цепочке - хэши пустые
+	e.PrevHash, e.Hash = prevHash.String, hash.String
+	if before.Valid {
+		v := int(before.Int64)
+		e.BalanceBefore = &v
+	}
+	if after.Valid {
+		v := int(after.Int64)
+		e.BalanceAfter = &v
+	}
+	return e, nil
+}
+
+/*
+Досчитывает цепочку: события без хэшей по порядку event_id получают
+ссылку на предыдущее и свой хэш. Голову блокирует только Seal, и то
+с SKIP LOCKED: если цепочку уже досчитывает другая реплика, выходим.
+Возвращает, сколько событий попало в цепочку.
+*/
+func (ar *AuditDBRepository) Seal(ctx context.Context) (int, error) {
+	// досчет идет в фоне, вне запросов: у него своя трасса
+	ctx, span := tracing.Start(ctx, "audit.Seal")
+	defer span.End()
+
+	total := 0
+	for {
+		n, err := ar.seal(ctx)
+		total += n
+		if err != nil || n < SealBatch {
+			tracing.Fail(span, err)
+			return total, err
+		}
+	}
+}
+
+func (ar *AuditDBRepository) seal(ctx context.Context) (int, error) {
+	tx, err := ar.DB.BeginTx(ctx, nil)
+	if err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	var (
+		seq  int64
+		prev string
+	)
+	q := `
+	SELECT last_seq, hash
+	FROM audit_head
+	FOR UPDATE SKIP LOCKED
+	`
+	err = tx.QueryRowContext(ctx, q).Scan(&seq, &prev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+
+	events, err := ar.unchained(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	q = `
+	UPDATE audit_events
+	SET prev_hash = $1, hash = $2, chain_seq = $3
+	WHERE event_id = $4
+	`
+	for _, e := range events {
+		seq++
+		e.PrevHash = prev
+		e.Hash = e.ComputeHash()
+		if _, err := tx.ExecContext(ctx, q, e.PrevHash, e.Hash, seq, e.ID); err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return 0, ErrInternalDB
+		}
+		prev = e.Hash
+	}
+
+	q = `
+	UPDATE audit_head
+	SET last_event_id = $1, last_seq = $2, hash = $3
+	`
+	if _, err := tx.ExecContext(ctx, q, events[len(events)-1].ID, seq, prev); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+
+	if err := tx.Commit(); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+	return len(events), nil
+}
+
+// Закоммиченные события без хэшей, не больше SealBatch.
+func (ar *AuditDBRepository) unchained(ctx context.Context, tx *sql.Tx) ([]Event, error) {
+	q := "SELECT " + eventColumns + " FROM audit_events WHERE hash IS NULL ORDER BY event_id LIMIT $1"
+	rows, err := tx.QueryContext(ctx, q, SealBatch)
+	if err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	events := make([]Event, 0)
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	return events, nil
+}
+
+/*
+Выборка журнала от новых к старым с фильтрами. Пагинация по event_id:
+курсор - id последнего отданного события. Берем на одну запись
+больше лимита, чтобы понять, есть ли следующая страница.
+*/
+func (ar *AuditDBRepository) Query(ctx context.Context, f Filter) (Page, error) {
+	ctx, span := tracing.Start(ctx, "audit.Query")
+	defer span.End()
+
+	page, err := ar.query(ctx, f)
+	tracing.Fail(span, err)
+	return page, err
+}
+
+func (ar *AuditDBRepository) query(ctx context.Context, f Filter) (Page, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.Target != "" {
+		add("target = $%d", f.Target)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.Cursor != "" {
+		before, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		add("event_id < $%d", before)
+	}
+
+	q := "SELECT " + eventColumns + " FROM audit_events"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit+1)
+	q += fmt.Sprintf(" ORDER BY event_id DESC LIMIT $%d", len(args))
+
+	rows, err := ar.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return Page{}, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	page := Page{Items: make([]Event, 0, limit)}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return Page{}, ErrInternalDB
+		}
+		page.Items = append(page.Items, e)
+	}
+	if err := rows.Err(); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return Page{}, ErrInternalDB
+	}
+
+	if len(page.Items) > limit {
+		page.Items = page.Items[:limit]
+		page.NextCursor = encodeCursor(page.Items[limit-1].ID)
+	}
+	return page, nil
+}
+
+/*
+Проверка цепочки: сначала читаем голову, затем идем по цепочке до нее,
+пересчитываем хэши и сверяем ссылки на предыдущее событие, в конце -
+с головой. Правка записи ломает ее хэш, удаление - ссылку у следующей
+записи или голову. События, досчитанные после чтения головы, не
+смотрим; еще не попавшие в цепочку только считаем.
+*/
+func (ar *AuditDBRepository) Verify(ctx context.Context) (VerifyReport, error) {
+	ctx, span := tracing.Start(ctx, "audit.Verify")
+	defer span.End()
+
+	rep, err := ar.verify(ctx)
+	tracing.Fail(span, err)
+	return rep, err
+}
+
+func (ar *AuditDBRepository) verify(ctx context.Context) (VerifyReport, error) {
+	var (
+		rep      VerifyReport
+		headID   int64
+		headSeq  int64
+		headHash string
+	)
+	q := `SELECT last_event_id, last_seq, hash FROM audit_head`
+	if err := ar.DB.QueryRowContext(ctx, q).Scan(&headID, &headSeq, &headHash); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return VerifyReport{}, ErrInternalDB
+	}
+
+	q = "SELECT " + eventColumns + " FROM audit_events WHERE chain_seq <= $1 ORDER BY chain_seq"
+	rows, err := ar.DB.QueryContext(ctx, q, headSeq)
+	if err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return VerifyReport{}, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	prev := GenesisHash
+	var lastID int64
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return VerifyReport{}, ErrInternalDB
+		}
+		rep.Checked++
+
+		switch {
+		case e.PrevHash != prev:
+			rep.BrokenAt, rep.Reason = &e.ID, "previous event is missing or modified"
+		case e.ComputeHash() != e.Hash:
+			rep.BrokenAt, rep.Reason = &e.ID, "event is modified"
+		}
+		if rep.BrokenAt != nil {
+			ar.Logger.Warnf("audit chain is broken at event %d: %s", e.ID, rep.Reason)
+			return rep, nil
+		}
+
+		prev, lastID = e.Hash, e.ID
+	}
+	if err := rows.Err(); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return VerifyReport{}, ErrInternalDB
+	}
+
+	if headID != lastID || headHash != prev {
+		rep.Reason = fmt.Sprintf("chain ends at event %d, head points to event %d", lastID, headID)
+		ar.Logger.Warnf("audit chain is broken: %s", rep.Reason)
+		return rep, nil
+	}
+
+	q = `SELECT COUNT(*) FROM audit_events WHERE hash IS NULL`
+	if err := ar.DB.QueryRowContext(ctx, q).Scan(&rep.Pending); err != nil {
+		ar.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return VerifyReport{}, ErrInternalDB
+	}
+
+	return rep, nil
+}
+
+func encodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeCursor(s string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: cursor", ErrInvalidFilter)
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%w: cursor", ErrInvalidFilter)
+	}
+	return id, nil
+}
@@ -0,0 +1,365 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var eventCols = []string{
+	"event_id", "created_at", "actor", "action", "target", "balance_before", "balance_after",
+	"request_id", "ip", "details", "prev_hash", "hash",
+}
+
+func newTestAuditRepository(t *testing.T) (*AuditDBRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewAuditDBRepository(db, zap.NewNop().Sugar()), mock
+}
+
+// Цепочка из n событий, как ее досчитал бы Seal.
+func chain(n int) []Event {
+	events := make([]Event, 0, n)
+	prev := GenesisHash
+	for i := 1; i <= n; i++ {
+		e := Event{
+			ID:        int64(i),
+			CreatedAt: time.Date(2025, 3, 1, 12, i, 0, 0, time.UTC),
+			Actor:     "user1",
+			Action:    ActionTransfer,
+			Target:    "user2",
+			Details:   []byte("{}"),
+			PrevHash:  prev,
+		}.WithBalances(100, 100-i)
+		e.Hash = e.ComputeHash()
+		prev = e.Hash
+		events = append(events, e)
+	}
+	return events
+}
+
+func eventRows(events ...Event) *sqlmock.Rows {
+	rows := sqlmock.NewRows(eventCols)
+	for _, e := range events {
+		rows.AddRow(e.ID, e.CreatedAt, e.Actor, e.Action, e.Target, *e.BalanceBefore, *e.BalanceAfter,
+			e.RequestID, e.IP, string(e.Details), e.PrevHash, e.Hash)
+	}
+	return rows
+}
+
+// Те же события до Seal: хэшей еще нет.
+func unchainedRows(events ...Event) *sqlmock.Rows {
+	rows := sqlmock.NewRows(eventCols)
+	for _, e := range events {
+		rows.AddRow(e.ID, e.CreatedAt, e.Actor, e.Action, e.Target, *e.BalanceBefore, *e.BalanceAfter,
+			e.RequestID, e.IP, string(e.Details), nil, nil)
+	}
+	return rows
+}
+
+func TestRecord(t *testing.T) {
+	repo, mock := newTestAuditRepository(t)
+
+	// в цепочку событие ставит Seal, голова здесь не блокируется
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO audit_events \(created_at, actor, action, target, balance_before, balance_after, request_id, ip, details\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8, \$9\) RETURNING event_id`).
+		WithArgs(sqlmock.AnyArg(), "user1", ActionAdjust, "user2", 10, 15,
+			"req1", "10.0.0.1", `{"delta":5}`).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow(2))
+	mock.ExpectCommit()
+
+	ctx := WithMeta(context.Background(), Meta{Actor: "user1", RequestID: "req1", IP: "10.0.0.1"})
+	ev := NewEvent(ctx, ActionAdjust, "user2").
+		WithBalances(10, 15).
+		WithDetails(map[string]interface{}{"delta": 5})
+
+	tx, err := repo.DB.Begin()
+	require.NoError(t, err)
+	e, err := Record(ctx, tx, ev, repo.Logger)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	assert.Equal(t, int64(2), e.ID)
+	assert.Empty(t, e.Hash)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWrite_DatabaseError(t *testing.T) {
+	repo, mock := newTestAuditRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
+
+	err := Write(context.Background(), repo.DB, NewEvent(context.Background(), ActionLoginFailed, "ghost"), repo.Logger)
+	assert.Equal(t, ErrInternalDB, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAuditDBRepository_Seal(t *testing.T) {
+	const (
+		headQuery      = `SELECT last_seq, hash FROM audit_head FOR UPDATE SKIP LOCKED`
+		unchainedQuery = `SELECT .+ FROM audit_events WHERE hash IS NULL ORDER BY event_id LIMIT \$1`
+		chainEvent     = `UPDATE audit_events SET prev_hash = \$1, hash = \$2, chain_seq = \$3 WHERE event_id = \$4`
+		moveHead       = `UPDATE audit_head SET last_event_id = \$1, last_seq = \$2, hash = \$3`
+	)
+	headCols := []string{"last_seq", "hash"}
+
+	tests := []struct {
+		name          string
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedN     int
+		expectedError error
+	}{
+		{
+			name: "ChainsInOrder",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				events := chain(4)
+				mock.ExpectBegin()
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(2, events[1].Hash))
+				mock.ExpectQuery(unchainedQuery).
+					WithArgs(SealBatch).
+					WillReturnRows(unchainedRows(events[2:]...))
+				// хэши те же, что дал бы Record с блокировкой головы
+				mock.ExpectExec(chainEvent).
+					WithArgs(events[1].Hash, events[2].Hash, int64(3), int64(3)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(chainEvent).
+					WithArgs(events[2].Hash, events[3].Hash, int64(4), int64(4)).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(moveHead).
+					WithArgs(int64(4), int64(4), events[3].Hash).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedN: 2,
+		},
+		{
+			name: "HeadLockedByAnotherReplica",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(headQuery).WillReturnRows(sqlmock.NewRows(headCols))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "NothingToSeal",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(0, GenesisHash))
+				mock.ExpectQuery(unchainedQuery).WillReturnRows(sqlmock.NewRows(eventCols))
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name: "DatabaseError",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				events := chain(1)
+				mock.ExpectBegin()
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(0, GenesisHash))
+				mock.ExpectQuery(unchainedQuery).WillReturnRows(unchainedRows(events...))
+				mock.ExpectExec(chainEvent).WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestAuditRepository(t)
+			tt.mockDBSetup(mock)
+
+			n, err := repo.Seal(context.Background())
+			assert.Equal(t, tt.expectedError, err)
+			assert.Equal(t, tt.expectedN, n)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuditDBRepository_Query(t *testing.T) {
+	events := chain(3)
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		filter        Filter
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedIDs   []int64
+		expectedNext  string
+		expectedError error
+	}{
+		{
+			name:   "FiltersWithNextPage",
+			filter: Filter{Actor: "user1", Action: ActionTransfer, From: from, Limit: 2},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT .+ FROM audit_events WHERE actor = \$1 AND action = \$2 AND created_at >= \$3 ORDER BY event_id DESC LIMIT \$4`).
+					WithArgs("user1", ActionTransfer, from, 3).
+					WillReturnRows(eventRows(events[2], events[1], events[0]))
+			},
+			expectedIDs:  []int64{3, 2},
+			expectedNext: encodeCursor(2),
+		},
+		{
+			name:   "CursorLastPage",
+			filter: Filter{Cursor: encodeCursor(2)},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT .+ FROM audit_events WHERE event_id < \$1 ORDER BY event_id DESC LIMIT \$2`).
+					WithArgs(int64(2), DefaultLimit+1).
+					WillReturnRows(eventRows(events[0]))
+			},
+			expectedIDs: []int64{1},
+		},
+		{
+			name:          "InvalidCursor",
+			filter:        Filter{Cursor: "!!"},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrInvalidFilter,
+		},
+		{
+			name:   "DatabaseError",
+			filter: Filter{},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT .+ FROM audit_events ORDER BY event_id DESC LIMIT \$1`).
+					WithArgs(DefaultLimit + 1).
+					WillReturnError(errors.New("database error"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestAuditRepository(t)
+			tt.mockDBSetup(mock)
+
+			page, err := repo.Query(context.Background(), tt.filter)
+			if tt.expectedError != nil {
+				assert.ErrorIs(t, err, tt.expectedError)
+			} else {
+				require.NoError(t, err)
+				ids := make([]int64, 0, len(page.Items))
+				for _, e := range page.Items {
+					ids = append(ids, e.ID)
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+				assert.Equal(t, tt.expectedNext, page.NextCursor)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuditDBRepository_Verify(t *testing.T) {
+	const (
+		headQuery    = `SELECT last_event_id, last_seq, hash FROM audit_head`
+		walkQuery    = `SELECT .+ FROM audit_events WHERE chain_seq <= \$1 ORDER BY chain_seq`
+		pendingQuery = `SELECT COUNT\(\*\) FROM audit_events WHERE hash IS NULL`
+	)
+	headCols := []string{"last_event_id", "last_seq", "hash"}
+	pending := func(mock sqlmock.Sqlmock, n int) {
+		mock.ExpectQuery(pendingQuery).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(n))
+	}
+
+	tests := []struct {
+		name           string
+		mockDBSetup    func(sqlmock.Sqlmock)
+		expectedReport VerifyReport
+	}{
+		{
+			name: "Intact",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				events := chain(3)
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(3, 3, events[2].Hash))
+				mock.ExpectQuery(walkQuery).WithArgs(int64(3)).WillReturnRows(eventRows(events...))
+				pending(mock, 2)
+			},
+			expectedReport: VerifyReport{Checked: 3, Pending: 2},
+		},
+		{
+			name: "Empty",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(0, 0, GenesisHash))
+				mock.ExpectQuery(walkQuery).WillReturnRows(sqlmock.NewRows(eventCols))
+				pending(mock, 0)
+			},
+			expectedReport: VerifyReport{},
+		},
+		{
+			name: "ModifiedEvent",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				events := chain(3)
+				events[1].Target = "user3"
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(3, 3, events[2].Hash))
+				mock.ExpectQuery(walkQuery).WillReturnRows(eventRows(events...))
+			},
+			expectedReport: VerifyReport{Checked: 2, BrokenAt: ptr(2), Reason: "event is modified"},
+		},
+		{
+			name: "DeletedEvent",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				events := chain(3)
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(3, 3, events[2].Hash))
+				mock.ExpectQuery(walkQuery).WillReturnRows(eventRows(events[0], events[2]))
+			},
+			expectedReport: VerifyReport{Checked: 2, BrokenAt: ptr(3), Reason: "previous event is missing or modified"},
+		},
+		{
+			name: "DeletedTail",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				events := chain(3)
+				mock.ExpectQuery(headQuery).
+					WillReturnRows(sqlmock.NewRows(headCols).AddRow(3, 3, events[2].Hash))
+				mock.ExpectQuery(walkQuery).WillReturnRows(eventRows(events[:2]...))
+			},
+			expectedReport: VerifyReport{Checked: 2, Reason: "chain ends at event 2, head points to event 3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestAuditRepository(t)
+			tt.mockDBSetup(mock)
+
+			rep, err := repo.Verify(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedReport, rep)
+			assert.Equal(t, tt.expectedReport.BrokenAt == nil && tt.expectedReport.Reason == "", rep.OK())
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuditDBRepository_VerifyDatabaseError(t *testing.T) {
+	repo, mock := newTestAuditRepository(t)
+
+	mock.ExpectQuery(`SELECT last_event_id, last_seq, hash FROM audit_head`).
+		WillReturnError(sql.ErrConnDone)
+
+	_, err := repo.Verify(context.Background())
+	assert.Equal(t, ErrInternalDB, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func ptr(v int64) *int64 {
+	return &v
+}
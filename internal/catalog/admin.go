@@ -0,0 +1,376 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"proj/internal/audit"
+	"proj/internal/tracing"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// Код ошибки postgres при нарушении уникальности.
+	pqUniqueViolation = "23505"
+
+	adminItemColumns = `"type", slug, display_name, description, price, price_version, active, sort_order, updated_at`
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAdminItem(row rowScanner) (AdminItem, error) {
+	var i AdminItem
+	err := row.Scan(
+		&i.Code, &i.Slug, &i.DisplayName, &i.Description, &i.Price,
+		&i.PriceVersion, &i.Active, &i.SortOrder, &i.UpdatedAt,
+	)
+	return i, err
+}
+
+// Все товары, включая выключенные.
+func (cr *CatalogDBRepository) ListAll(ctx context.Context) ([]AdminItem, error) {
+	ctx, span := tracing.Start(ctx, "catalog.ListAll")
+	defer span.End()
+
+	items, err := cr.listAll(ctx)
+	tracing.Fail(span, err)
+	return items, err
+}
+
+func (cr *CatalogDBRepository) listAll(ctx context.Context) ([]AdminItem, error) {
+	q := `SELECT ` + adminItemColumns + ` FROM store ORDER BY sort_order, slug`
+	rows, err := cr.DB.QueryContext(ctx, q)
+	if err != nil {
+		cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	items := make([]AdminItem, 0, AllocSize)
+	for rows.Next() {
+		i, err := scanAdminItem(rows)
+		if err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		items = append(items, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	return items, nil
+}
+
+// Новый товар и первая запись в истории цен - в одной транзакции.
+func (cr *CatalogDBRepository) Create(ctx context.Context, n NewItem, actorID string) (AdminItem, error) {
+	ctx, span := tracing.Start(ctx, "catalog.Create")
+	defer span.End()
+
+	item, err := cr.create(ctx, n, actorID)
+	tracing.Fail(span, err)
+	return item, err
+}
+
+func (cr *CatalogDBRepository) create(ctx context.Context, n NewItem, actorID string) (AdminItem, error) {
+	if err := n.Validate(); err != nil {
+		return AdminItem{}, err
+	}
+
+	var item AdminItem
+	err := cr.inTx(ctx, func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO store (slug, display_name, description, price, sort_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + adminItemColumns
+		var err error
+		item, err = scanAdminItem(tx.QueryRowContext(ctx, q, n.Slug, n.DisplayName, n.Description, n.Price, n.SortOrder))
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+				return ErrItemExists
+			}
+
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		if err = addPriceChange(ctx, tx, item, actorID); err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionCatalogAdd, item.Slug).
+			WithActor(actorID).
+			WithDetails(map[string]interface{}{"price": item.Price, "displayName": item.DisplayName})
+		return cr.record(ctx, tx, ev)
+	})
+	if err != nil {
+		return AdminItem{}, err
+	}
+
+	cr.Logger.Infof("store item - %s - created with price %d", item.Slug, item.Price)
+	return item, nil
+}
+
+// Изменение описательных полей товара, не заданные поля не трогаем.
+func (cr *CatalogDBRepository) Update(ctx context.Context, slug string, upd ItemUpdate) (AdminItem, error) {
+	ctx, span := tracing.Start(ctx, "catalog.Update")
+	defer span.End()
+
+	item, err := cr.update(ctx, slug, upd)
+	tracing.Fail(span, err)
+	return item, err
+}
+
+func (cr *CatalogDBRepository) update(ctx context.Context, slug string, upd ItemUpdate) (AdminItem, error) {
+	if err := upd.Validate(); err != nil {
+		return AdminItem{}, err
+	}
+
+	var item AdminItem
+	err := cr.inTx(ctx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE store
+		SET display_name = COALESCE($2, display_name),
+			description = COALESCE($3, description),
+			sort_order = COALESCE($4, sort_order),
+			active = COALESCE($5, active),
+			updated_at = now()
+		WHERE slug = $1
+		RETURNING ` + adminItemColumns
+		var err error
+		item, err = scanAdminItem(tx.QueryRowContext(ctx, q, slug, upd.DisplayName, upd.Description, upd.SortOrder, upd.Active))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrItemNotFound
+			}
+
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionCatalogEdit, slug).
+			WithDetails(map[string]interface{}{"update": upd})
+		return cr.record(ctx, tx, ev)
+	})
+	if err != nil {
+		return AdminItem{}, err
+	}
+
+	cr.Logger.Infof("store item - %s - updated", slug)
+	return item, nil
+}
+
+// Выключаем товар: из каталога пропадает, в инвентарях остается.
+func (cr *CatalogDBRepository) Deactivate(ctx context.Context, slug string) error {
+	ctx, span := tracing.Start(ctx, "catalog.Deactivate")
+	defer span.End()
+
+	err := cr.deactivate(ctx, slug)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (cr *CatalogDBRepository) deactivate(ctx context.Context, slug string) error {
+	err := cr.inTx(ctx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE store
+		SET active = FALSE, updated_at = now()
+		WHERE slug = $1
+		`
+		res, err := tx.ExecContext(ctx, q, slug)
+		if err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+		if n == 0 {
+			return ErrItemNotFound
+		}
+
+		return cr.record(ctx, tx, audit.NewEvent(ctx, audit.ActionCatalogOff, slug))
+	})
+	if err != nil {
+		return err
+	}
+
+	cr.Logger.Infof("store item - %s - deactivated", slug)
+	return nil
+}
+
+/*
+Смена цены:
+  - поднимаем версию цены
+  - если передали expectedVersion, меняем только если версия совпала
+    (иначе ErrVersionConflict - кто-то успел поменять цену раньше)
+  - пишем новую цену в историю
+*/
+func (cr *CatalogDBRepository) Reprice(ctx context.Context, slug string, price, expectedVersion int, actorID string) (AdminItem, error) {
+	ctx, span := tracing.Start(ctx, "catalog.Reprice")
+	defer span.End()
+
+	item, err := cr.reprice(ctx, slug, price, expectedVersion, actorID)
+	tracing.Fail(span, err)
+	return item, err
+}
+
+func (cr *CatalogDBRepository) reprice(ctx context.Context, slug string, price, expectedVersion int, actorID string) (AdminItem, error) {
+	if price <= 0 {
+		return AdminItem{}, ErrInvalidItem
+	}
+
+	var item AdminItem
+	err := cr.inTx(ctx, func(tx *sql.Tx) error {
+		q := `
+		UPDATE store
+		SET price = $2, price_version = price_version + 1, updated_at = now()
+		WHERE slug = $1 AND ($3 = 0 OR price_version = $3)
+		RETURNING ` + adminItemColumns
+		var err error
+		item, err = scanAdminItem(tx.QueryRowContext(ctx, q, slug, price, expectedVersion))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// Различим "нет товара" и "версия устарела"
+				if expectedVersion != 0 && itemExists(ctx, tx, slug) {
+					return ErrVersionConflict
+				}
+				return ErrItemNotFound
+			}
+
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		if err = addPriceChange(ctx, tx, item, actorID); err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionCatalogPrice, slug).
+			WithActor(actorID).
+			WithDetails(map[string]interface{}{"price": item.Price, "priceVersion": item.PriceVersion})
+		return cr.record(ctx, tx, ev)
+	})
+	if err != nil {
+		return AdminItem{}, err
+	}
+
+	cr.Logger.Infof("store item - %s - repriced to %d (version %d)", slug, price, item.PriceVersion)
+	return item, nil
+}
+
+// Изменение каталога и событие аудита о нем сохраняются вместе.
+func (cr *CatalogDBRepository) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := cr.DB.BeginTx(ctx, nil)
+	if err != nil {
+		cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	return nil
+}
+
+func (cr *CatalogDBRepository) record(ctx context.Context, tx *sql.Tx, ev audit.Event) error {
+	if _, err := audit.Record(ctx, tx, ev, cr.Logger); err != nil {
+		return ErrInternalDB
+	}
+	return nil
+}
+
+// История цен товара, новые версии первыми.
+func (cr *CatalogDBRepository) PriceHistory(ctx context.Context, slug string) ([]PriceChange, error) {
+	ctx, span := tracing.Start(ctx, "catalog.PriceHistory")
+	defer span.End()
+
+	history, err := cr.priceHistory(ctx, slug)
+	tracing.Fail(span, err)
+	return history, err
+}
+
+func (cr *CatalogDBRepository) priceHistory(ctx context.Context, slug string) ([]PriceChange, error) {
+	q := `
+	SELECT ph.price_version, ph.price, COALESCE(ph.changed_by::text, ''), ph.changed_at
+	FROM price_history ph
+	JOIN store s ON s."type" = ph."type"
+	WHERE s.slug = $1
+	ORDER BY ph.price_version DESC
+	`
+	rows, err := cr.DB.QueryContext(ctx, q, slug)
+	if err != nil {
+		cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	res := make([]PriceChange, 0, AllocSize)
+	for rows.Next() {
+		var pc PriceChange
+		if err = rows.Scan(&pc.PriceVersion, &pc.Price, &pc.ChangedBy, &pc.ChangedAt); err != nil {
+			cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		res = append(res, pc)
+	}
+
+	if err = rows.Err(); err != nil {
+		cr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	// У существующего товара всегда есть хотя бы одна версия цены
+	if len(res) == 0 {
+		return nil, ErrItemNotFound
+	}
+
+	return res, nil
+}
+
+func addPriceChange(ctx context.Context, tx *sql.Tx, item AdminItem, actorID string) error {
+	q := `
+	INSERT INTO price_history ("type", price_version, price, changed_by)
+	VALUES ($1, $2, $3, NULLIF($4, '')::uuid)
+	`
+	_, err := tx.ExecContext(ctx, q, item.Code, item.PriceVersion, item.Price, actorID)
+	return err
+}
+
+func itemExists(ctx context.Context, tx *sql.Tx, slug string) bool {
+	var exists bool
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM store WHERE slug = $1)`, slug).Scan(&exists)
+	return err == nil && exists
+}
@@ -0,0 +1,195 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"proj/internal/audit"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+var adminItemCols = []string{
+	"type", "slug", "display_name", "description", "price",
+	"price_version", "active", "sort_order", "updated_at",
+}
+
+// Запись события аудита в конце транзакции.
+func expectAudit(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow(1))
+}
+
+func TestCatalogDBRepository_Create(t *testing.T) {
+	tests := []struct {
+		name          string
+		item          NewItem
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "Success",
+			item: NewItem{Slug: "sticker", DisplayName: "Sticker", Price: 5},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO store \(slug, display_name, description, price, sort_order\)`).
+					WithArgs("sticker", "Sticker", "", 5, 0).
+					WillReturnRows(sqlmock.NewRows(adminItemCols).
+						AddRow(10, "sticker", "Sticker", "", 5, 1, true, 0, time.Now()))
+				mock.ExpectExec(`INSERT INTO price_history \("type", price_version, price, changed_by\)`).
+					WithArgs(10, 1, 5, "admin1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, audit.ActionCatalogAdd)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:          "InvalidPrice",
+			item:          NewItem{Slug: "sticker", DisplayName: "Sticker", Price: 0},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrInvalidItem,
+		},
+		{
+			name: "DuplicateSlug",
+			item: NewItem{Slug: "cup", DisplayName: "Cup", Price: 20},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO store`).
+					WillReturnError(&pq.Error{Code: pqUniqueViolation})
+				mock.ExpectRollback()
+			},
+			expectedError: ErrItemExists,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
+
+			_, err := repo.Create(context.Background(), tt.item, "admin1")
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCatalogDBRepository_Reprice(t *testing.T) {
+	const repriceQuery = `UPDATE store SET price = \$2, price_version = price_version \+ 1, updated_at = now\(\) WHERE slug = \$1 AND \(\$3 = 0 OR price_version = \$3\)`
+
+	tests := []struct {
+		name            string
+		expectedVersion int
+		mockDBSetup     func(sqlmock.Sqlmock)
+		expectedError   error
+	}{
+		{
+			name:            "Success",
+			expectedVersion: 1,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(repriceQuery).
+					WithArgs("cup", 25, 1).
+					WillReturnRows(sqlmock.NewRows(adminItemCols).
+						AddRow(1, "cup", "Cup", "", 25, 2, true, 1, time.Now()))
+				mock.ExpectExec(`INSERT INTO price_history`).
+					WithArgs(1, 2, 25, "admin1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectAudit(mock, audit.ActionCatalogPrice)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:            "VersionConflict",
+			expectedVersion: 1,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(repriceQuery).
+					WithArgs("cup", 25, 1).
+					WillReturnRows(sqlmock.NewRows(adminItemCols))
+				mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM store WHERE slug = \$1\)`).
+					WithArgs("cup").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrVersionConflict,
+		},
+		{
+			name:            "NotFound",
+			expectedVersion: 0,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(repriceQuery).
+					WithArgs("cup", 25, 0).
+					WillReturnRows(sqlmock.NewRows(adminItemCols))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrItemNotFound,
+		},
+		{
+			name:            "DatabaseError",
+			expectedVersion: 0,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(repriceQuery).
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
+
+			_, err := repo.Reprice(context.Background(), "cup", 25, tt.expectedVersion, "admin1")
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCatalogDBRepository_Deactivate(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE store SET active = FALSE, updated_at = now\(\) WHERE slug = \$1`).
+		WithArgs("cup").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActionCatalogOff)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE store SET active = FALSE`).
+		WithArgs("mug").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.NoError(t, repo.Deactivate(context.Background(), "cup"))
+	assert.Equal(t, ErrItemNotFound, repo.Deactivate(context.Background(), "mug"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCatalogDBRepository_Update(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+	name := "Big cup"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`UPDATE store SET display_name = COALESCE\(\$2, display_name\)`).
+		WithArgs("cup", &name, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows(adminItemCols).
+			AddRow(1, "cup", "Big cup", "", 20, 1, true, 1, time.Now()))
+	expectAudit(mock, audit.ActionCatalogEdit)
+	mock.ExpectCommit()
+
+	item, err := repo.Update(context.Background(), "cup", ItemUpdate{DisplayName: &name})
+	assert.NoError(t, err)
+	assert.Equal(t, "Big cup", item.DisplayName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+)
+
+const (
+	SlugMaxLen        = 64
+	DisplayNameMaxLen = 128
+)
+
+var (
+	ErrItemNotFound    = errors.New("item not found")
+	ErrInvalidSlug     = errors.New("invalid item slug")
+	ErrInvalidItem     = errors.New("invalid item data")
+	ErrItemExists      = errors.New("item with this slug already exists")
+	ErrVersionConflict = errors.New("price version conflict")
+
+	slugRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+)
+
+// Товар магазина. Code - числовой код, под которым предмет
+// хранится в инвентаре пользователей (items.type).
+type Item struct {
+	Code        int    `json:"-"`
+	Slug        string `json:"slug"`
+	DisplayName string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	Active      bool   `json:"-"`
+	SortOrder   int    `json:"-"`
+
+	// Версия цены растет при каждом изменении price, покупка
+	// записывает в журнал ту версию, которую прочитала.
+	PriceVersion int `json:"-"`
+}
+
+// Представление товара для админки - со всеми служебными полями.
+type AdminItem struct {
+	Code         int       `json:"code"`
+	Slug         string    `json:"slug"`
+	DisplayName  string    `json:"name"`
+	Description  string    `json:"description"`
+	Price        int       `json:"price"`
+	PriceVersion int       `json:"priceVersion"`
+	Active       bool      `json:"active"`
+	SortOrder    int       `json:"sortOrder"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// Новый товар. Цена получает версию 1.
+type NewItem struct {
+	Slug        string `json:"slug"`
+	DisplayName string `json:"name"`
+	Description string `json:"description"`
+	Price       int    `json:"price"`
+	SortOrder   int    `json:"sortOrder"`
+}
+
+func (n NewItem) Validate() error {
+	if !ValidSlug(n.Slug) {
+		return ErrInvalidSlug
+	}
+	if n.DisplayName == "" || len(n.DisplayName) > DisplayNameMaxLen || n.Price <= 0 {
+		return ErrInvalidItem
+	}
+
+	return nil
+}
+
+// Частичное изменение товара, nil - поле не меняем.
+// Цена меняется только через Reprice, чтобы не терять версии.
+type ItemUpdate struct {
+	DisplayName *string `json:"name"`
+	Description *string `json:"description"`
+	SortOrder   *int    `json:"sortOrder"`
+	Active      *bool   `json:"active"`
+}
+
+func (u ItemUpdate) Validate() error {
+	if u.DisplayName != nil && (*u.DisplayName == "" || len(*u.DisplayName) > DisplayNameMaxLen) {
+		return ErrInvalidItem
+	}
+
+	return nil
+}
+
+// Запись истории цен.
+type PriceChange struct {
+	PriceVersion int       `json:"priceVersion"`
+	Price        int       `json:"price"`
+	ChangedBy    string    `json:"changedBy,omitempty"`
+	ChangedAt    time.Time `json:"changedAt"`
+}
+
+type CatalogResponse struct {
+	Items []Item `json:"items"`
+}
+
+type CatalogRepo interface {
+	List(ctx context.Context) ([]Item, error)
+	Get(ctx context.Context, slug string) (Item, error)
+}
+
+type CatalogAdminRepo interface {
+	ListAll(ctx context.Context) ([]AdminItem, error)
+	Create(ctx context.Context, item NewItem, actorID string) (AdminItem, error)
+	Update(ctx context.Context, slug string, upd ItemUpdate) (AdminItem, error)
+	Deactivate(ctx context.Context, slug string) error
+	// expectedVersion = 0 - без проверки текущей версии
+	Reprice(ctx context.Context, slug string, price, expectedVersion int, actorID string) (AdminItem, error)
+	PriceHistory(ctx context.Context, slug string) ([]PriceChange, error)
+}
+
+func ValidSlug(slug string) bool {
+	return len(slug) <= SlugMaxLen && slugRe.MatchString(slug)
+}
@@ -0,0 +1,177 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: catalog.go
+
+// Package catalog is a generated GoMock package.
+package catalog
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockCatalogRepo is a mock of CatalogRepo interface.
+type MockCatalogRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockCatalogRepoMockRecorder
+}
+
+// MockCatalogRepoMockRecorder is the mock recorder for MockCatalogRepo.
+type MockCatalogRepoMockRecorder struct {
+	mock *MockCatalogRepo
+}
+
+// NewMockCatalogRepo creates a new mock instance.
+func NewMockCatalogRepo(ctrl *gomock.Controller) *MockCatalogRepo {
+	mock := &MockCatalogRepo{ctrl: ctrl}
+	mock.recorder = &MockCatalogRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCatalogRepo) EXPECT() *MockCatalogRepoMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method.
+func (m *MockCatalogRepo) Get(ctx context.Context, slug string) (Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, slug)
+	ret0, _ := ret[0].(Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockCatalogRepoMockRecorder) Get(ctx, slug interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockCatalogRepo)(nil).Get), ctx, slug)
+}
+
+// List mocks base method.
+func (m *MockCatalogRepo) List(ctx context.Context) ([]Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockCatalogRepoMockRecorder) List(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockCatalogRepo)(nil).List), ctx)
+}
+
+// MockCatalogAdminRepo is a mock of CatalogAdminRepo interface.
+type MockCatalogAdminRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockCatalogAdminRepoMockRecorder
+}
+
+// MockCatalogAdminRepoMockRecorder is the mock recorder for MockCatalogAdminRepo.
+type MockCatalogAdminRepoMockRecorder struct {
+	mock *MockCatalogAdminRepo
+}
+
+// NewMockCatalogAdminRepo creates a new mock instance.
+func NewMockCatalogAdminRepo(ctrl *gomock.Controller) *MockCatalogAdminRepo {
+	mock := &MockCatalogAdminRepo{ctrl: ctrl}
+	mock.recorder = &MockCatalogAdminRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCatalogAdminRepo) EXPECT() *MockCatalogAdminRepoMockRecorder {
+	return m.recorder
+}
+
+// Create mocks base method.
+func (m *MockCatalogAdminRepo) Create(ctx context.Context, item NewItem, actorID string) (AdminItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Create", ctx, item, actorID)
+	ret0, _ := ret[0].(AdminItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Create indicates an expected call of Create.
+func (mr *MockCatalogAdminRepoMockRecorder) Create(ctx, item, actorID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockCatalogAdminRepo)(nil).Create), ctx, item, actorID)
+}
+
+// Deactivate mocks base method.
+func (m *MockCatalogAdminRepo) Deactivate(ctx context.Context, slug string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Deactivate", ctx, slug)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Deactivate indicates an expected call of Deactivate.
+func (mr *MockCatalogAdminRepoMockRecorder) Deactivate(ctx, slug interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deactivate", reflect.TypeOf((*MockCatalogAdminRepo)(nil).Deactivate), ctx, slug)
+}
+
+// ListAll mocks base method.
+func (m *MockCatalogAdminRepo) ListAll(ctx context.Context) ([]AdminItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAll", ctx)
+	ret0, _ := ret[0].([]AdminItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAll indicates an expected call of ListAll.
+func (mr *MockCatalogAdminRepoMockRecorder) ListAll(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAll", reflect.TypeOf((*MockCatalogAdminRepo)(nil).ListAll), ctx)
+}
+
+// PriceHistory mocks base method.
+func (m *MockCatalogAdminRepo) PriceHistory(ctx context.Context, slug string) ([]PriceChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PriceHistory", ctx, slug)
+	ret0, _ := ret[0].([]PriceChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PriceHistory indicates an expected call of PriceHistory.
+func (mr *MockCatalogAdminRepoMockRecorder) PriceHistory(ctx, slug interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PriceHistory", reflect.TypeOf((*MockCatalogAdminRepo)(nil).PriceHistory), ctx, slug)
+}
+
+// Reprice mocks base method.
+func (m *MockCatalogAdminRepo) Reprice(ctx context.Context, slug string, price, expectedVersion int, actorID string) (AdminItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reprice", ctx, slug, price, expectedVersion, actorID)
+	ret0, _ := ret[0].(AdminItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reprice indicates an expected call of Reprice.
+func (mr *MockCatalogAdminRepoMockRecorder) Reprice(ctx, slug, price, expectedVersion, actorID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reprice", reflect.TypeOf((*MockCatalogAdminRepo)(nil).Reprice), ctx, slug, price, expectedVersion, actorID)
+}
+
+// Update mocks base method.
+func (m *MockCatalogAdminRepo) Update(ctx context.Context, slug string, upd ItemUpdate) (AdminItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, slug, upd)
+	ret0, _ := ret[0].(AdminItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockCatalogAdminRepoMockRecorder) Update(ctx, slug, upd interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockCatalogAdminRepo)(nil).Update), ctx, slug, upd)
+}
@@ -0,0 +1,104 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var itemCols = []string{"type", "slug", "display_name", "description", "price", "active", "sort_order", "price_version"}
+
+func newTestDBRepository(t *testing.T) (*CatalogDBRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewCatalogDBRepository(db, zap.NewNop().Sugar()), mock
+}
+
+func TestCatalogDBRepository_Get(t *testing.T) {
+	tests := []struct {
+		name          string
+		slug          string
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedItem  Item
+		expectedError error
+	}{
+		{
+			name: "Success",
+			slug: "cup",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT "type", slug, display_name, description, price, active, sort_order, price_version FROM store WHERE slug = \$1 AND active`).
+					WithArgs("cup").
+					WillReturnRows(sqlmock.NewRows(itemCols).AddRow(1, "cup", "Cup", "", 20, true, 1, 2))
+			},
+			expectedItem: Item{Code: 1, Slug: "cup", DisplayName: "Cup", Price: 20, Active: true, SortOrder: 1, PriceVersion: 2},
+		},
+		{
+			name: "NotFound",
+			slug: "mug",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM store WHERE slug = \$1 AND active`).
+					WithArgs("mug").
+					WillReturnError(sql.ErrNoRows)
+			},
+			expectedError: ErrItemNotFound,
+		},
+		{
+			name:          "InvalidSlug",
+			slug:          "DROP TABLE",
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrItemNotFound,
+		},
+		{
+			name: "DatabaseError",
+			slug: "cup",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM store WHERE slug = \$1 AND active`).
+					WithArgs("cup").
+					WillReturnError(errors.New("database error"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
+
+			item, err := repo.Get(context.Background(), tt.slug)
+			assert.Equal(t, tt.expectedError, err)
+			assert.Equal(t, tt.expectedItem, item)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCatalogDBRepository_List(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectQuery(`FROM store WHERE active ORDER BY sort_order, slug`).
+		WillReturnRows(sqlmock.NewRows(itemCols).
+			AddRow(0, "t-shirt", "T-shirt", "", 80, true, 0, 1).
+			AddRow(1, "cup", "Cup", "", 20, true, 1, 1))
+
+	items, err := repo.List(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Equal(t, "t-shirt", items[0].Slug)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestValidSlug(t *testing.T) {
+	assert.True(t, ValidSlug("pink-hoody"))
+	assert.False(t, ValidSlug("Pink Hoody"))
+	assert.False(t, ValidSlug("-hoody"))
+	assert.False(t, ValidSlug(""))
+}
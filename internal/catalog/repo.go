@@ -0,0 +1,149 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"proj/internal/dbtx"
+	"proj/internal/tracing"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Правило хорошего тона заранее аллоцировать слайсы.
+	AllocSize = 10
+)
+
+var (
+	ErrInternalDB = errors.New("database internal error")
+)
+
+// Общий интерфейс для *sql.DB и *sql.Tx, чтобы читать каталог
+// как отдельно, так и внутри транзакции покупки.
+type Querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type CatalogDBRepository struct {
+	DB     *sql.DB
+	Logger *zap.SugaredLogger
+}
+
+func NewCatalogDBRepository(db *sql.DB, l *zap.SugaredLogger) *CatalogDBRepository {
+	return &CatalogDBRepository{
+		DB:     db,
+		Logger: l,
+	}
+}
+
+// Активные товары в порядке показа.
+func (cr *CatalogDBRepository) List(ctx context.Context) ([]Item, error) {
+	ctx, span := tracing.Start(ctx, "catalog.List")
+	defer span.End()
+
+	items, err := cr.list(ctx)
+	tracing.Fail(span, err)
+	return items, err
+}
+
+func (cr *CatalogDBRepository) list(ctx context.Context) ([]Item, error) {
+	return ListActive(ctx, cr.DB, cr.Logger)
+}
+
+// Активный товар по slug.
+func (cr *CatalogDBRepository) Get(ctx context.Context, slug string) (Item, error) {
+	ctx, span := tracing.Start(ctx, "catalog.Get")
+	defer span.End()
+
+	item, err := cr.get(ctx, slug)
+	tracing.Fail(span, err)
+	return item, err
+}
+
+func (cr *CatalogDBRepository) get(ctx context.Context, slug string) (Item, error) {
+	return FindBySlug(ctx, cr.DB, slug, cr.Logger)
+}
+
+const queryItemBySlug = `
+	SELECT "type", slug, display_name, description, price, active, sort_order, price_version
+	FROM store
+	WHERE slug = $1 AND active
+	`
+
+// Поиск активного товара по slug, можно вызывать внутри транзакции.
+func FindBySlug(ctx context.Context, q Querier, slug string, l *zap.SugaredLogger) (Item, error) {
+	return findBySlug(ctx, q, queryItemBySlug, slug, l)
+}
+
+/*
+Поиск товара для покупки внутри транзакции. Строку блокируем FOR SHARE:
+смена цены (UPDATE) дождется конца покупок, которые уже прочитали цену,
+а покупки после смены увидят новую версию. Так покупка всегда
+списывает ровно ту цену, которую прочитала.
+*/
+func LockBySlug(ctx context.Context, tx *sql.Tx, slug string, l *zap.SugaredLogger) (Item, error) {
+	return findBySlug(ctx, tx, queryItemBySlug+"FOR SHARE", slug, l)
+}
+
+func findBySlug(ctx context.Context, q Querier, query, slug string, l *zap.SugaredLogger) (Item, error) {
+	if !ValidSlug(slug) {
+		l.Errorf("%v. More details: slug - %s -", ErrItemNotFound, slug)
+		return Item{}, ErrItemNotFound
+	}
+
+	var i Item
+	err := q.QueryRowContext(ctx, query, slug).Scan(
+		&i.Code, &i.Slug, &i.DisplayName, &i.Description, &i.Price, &i.Active, &i.SortOrder, &i.PriceVersion,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			l.Errorf("%v. More details: slug - %s -", ErrItemNotFound, slug)
+			return Item{}, ErrItemNotFound
+		}
+
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return Item{}, dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return i, nil
+}
+
+func ListActive(ctx context.Context, q Querier, l *zap.SugaredLogger) ([]Item, error) {
+	query := `
+	SELECT "type", slug, display_name, description, price, active, sort_order, price_version
+	FROM store
+	WHERE active
+	ORDER BY sort_order, slug
+	`
+	rows, err := q.QueryContext(ctx, query)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	items := make([]Item, 0, AllocSize)
+	for rows.Next() {
+		var i Item
+		err = rows.Scan(&i.Code, &i.Slug, &i.DisplayName, &i.Description, &i.Price, &i.Active, &i.SortOrder, &i.PriceVersion)
+		if err != nil {
+			l.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		items = append(items, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	return items, nil
+}
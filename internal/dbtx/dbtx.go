@@ -0,0 +1,262 @@
+package dbtx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Коды postgres, после которых транзакцию можно безопасно повторить:
+// конфликт сериализации и обнаруженный дедлок.
+const (
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+)
+
+const (
+	DefaultMaxRetries = 3
+	DefaultBaseDelay  = 10 * time.Millisecond
+	DefaultMaxDelay   = 200 * time.Millisecond
+)
+
+var (
+	ErrInternalDB       = errors.New("database internal error")
+	ErrUnknownIsolation = errors.New("unknown isolation level")
+)
+
+// Счетчики повторов по именам транзакций, отдаются в /metrics (internal/metrics):
+//   - tx_retries 			- сколько раз транзакцию начинали заново
+//   - tx_retries_exhausted - сколько раз бюджет повторов кончился
+var (
+	retries   = expvar.NewMap("tx_retries")
+	exhausted = expvar.NewMap("tx_retries_exhausted")
+)
+
+// Ошибка базы, после которой транзакцию имеет смысл повторить.
+// Снаружи выглядит как Err (ErrInternalDB вызывающего пакета),
+// исходная ошибка postgres лежит в Cause.
+type RetryableError struct {
+	Err   error
+	Cause error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
+// Можно ли повторить транзакцию после такой ошибки.
+func Retryable(err error) bool {
+	var re *RetryableError
+	if errors.As(err, &re) {
+		return true
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+	}
+
+	return false
+}
+
+/*
+Ошибка базы для вызывающего кода. Репозитории прячут ошибки postgres
+за своим ErrInternalDB, но Runner должен отличать повторяемые ошибки,
+поэтому для них internal оборачивается в RetryableError.
+*/
+func Internal(err, internal error) error {
+	var re *RetryableError
+	if errors.As(err, &re) {
+		return &RetryableError{Err: internal, Cause: re.Cause}
+	}
+	if Retryable(err) {
+		return &RetryableError{Err: internal, Cause: err}
+	}
+
+	return internal
+}
+
+// Уровень изоляции из конфига: "read committed", "repeatable read",
+// "serializable". Пустая строка - уровень по умолчанию у базы.
+func ParseIsolation(s string) (sql.IsolationLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "default":
+		return sql.LevelDefault, nil
+	case "read committed":
+		return sql.LevelReadCommitted, nil
+	case "repeatable read":
+		return sql.LevelRepeatableRead, nil
+	case "serializable":
+		return sql.LevelSerializable, nil
+	}
+
+	return sql.LevelDefault, fmt.Errorf("%w: %q", ErrUnknownIsolation, s)
+}
+
+// Настройки выполнения транзакций.
+type Policy struct {
+	Isolation sql.IsolationLevel
+	// Бюджет повторов: сколько раз транзакцию можно начать заново
+	// после первой попытки, 0 - не повторять
+	MaxRetries int
+	// Задержка перед первым повтором, дальше удваивается
+	// и ограничивается MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Isolation:  sql.LevelDefault,
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultBaseDelay,
+		MaxDelay:   DefaultMaxDelay,
+	}
+}
+
+// "Full jitter": случайная задержка от нуля до экспоненциального
+// потолка, чтобы повторы столкнувшихся транзакций разошлись.
+func (p Policy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	ceil := p.BaseDelay << min(attempt, 30)
+	if p.MaxDelay > 0 && (ceil > p.MaxDelay || ceil <= 0) {
+		ceil = p.MaxDelay
+	}
+
+	return rand.N(ceil + 1)
+}
+
+type Runner struct {
+	DB     *sql.DB
+	Logger *zap.SugaredLogger
+	Policy Policy
+
+	// ErrInternalDB пакета-владельца, отдается при сбое BEGIN/COMMIT
+	ErrInternal error
+}
+
+func NewRunner(db *sql.DB, l *zap.SugaredLogger, errInternal error) *Runner {
+	return &Runner{
+		DB:          db,
+		Logger:      l,
+		Policy:      DefaultPolicy(),
+		ErrInternal: errInternal,
+	}
+}
+
+/*
+Выполняет fn в транзакции. Если fn вернула nil - коммитим, иначе
+откатываем. Дедлок или конфликт сериализации (в fn или на COMMIT)
+повторяем целиком, пока не кончится бюджет Policy.MaxRetries.
+
+fn может выполниться несколько раз, поэтому побочных эффектов вне
+транзакции в ней быть не должно. name - имя транзакции в метриках.
+Отмененный ctx (клиент ушел или вышел таймаут) не повторяем.
+*/
+func (r *Runner) Run(ctx context.Context, name string, fn func(tx *sql.Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		err := r.runOnce(ctx, fn)
+		if err == nil || !Retryable(err) {
+			return err
+		}
+
+		if attempt >= r.Policy.MaxRetries || ctx.Err() != nil {
+			exhausted.Add(name, 1)
+			r.Logger.Errorf("%v. More details: tx - %s - failed after %d retries: %v",
+				r.internal(), name, attempt, cause(err))
+			return r.unwrap(err)
+		}
+
+		retries.Add(name, 1)
+		delay := r.Policy.backoff(attempt)
+		r.Logger.Warnf("retrying tx - %s - after %s, attempt %d: %v", name, delay, attempt+1, cause(err))
+		// повторы видны в трассе на спане метода репозитория
+		trace.SpanFromContext(ctx).AddEvent("tx retry", trace.WithAttributes(
+			attribute.String("tx", name),
+			attribute.Int("attempt", attempt+1),
+			attribute.String("cause", cause(err).Error()),
+		))
+		if err := sleep(ctx, delay); err != nil {
+			return r.unwrap(err)
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Runner) runOnce(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.DB.BeginTx(ctx, &sql.TxOptions{Isolation: r.Policy.Isolation})
+	if err != nil {
+		r.Logger.Errorf("%v. More details: %v", r.internal(), err)
+		return Internal(err, r.internal())
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			r.Logger.Errorf("%v. More details: %v", r.internal(), err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		r.Logger.Errorf("%v. More details: %v", r.internal(), err)
+		return Internal(err, r.internal())
+	}
+
+	return nil
+}
+
+func (r *Runner) internal() error {
+	if r.ErrInternal != nil {
+		return r.ErrInternal
+	}
+	return ErrInternalDB
+}
+
+// После исчерпания бюджета отдаем ошибку без RetryableError,
+// сырую ошибку postgres прячем за ErrInternal.
+func (r *Runner) unwrap(err error) error {
+	var re *RetryableError
+	if errors.As(err, &re) {
+		return re.Err
+	}
+	return r.internal()
+}
+
+func cause(err error) error {
+	var re *RetryableError
+	if errors.As(err, &re) {
+		return re.Cause
+	}
+	return err
+}
@@ -0,0 +1,215 @@
+package dbtx
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var errTestInternal = errors.New("test internal error")
+
+func newTestRunner(t *testing.T, maxRetries int) (*Runner, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	r := NewRunner(db, zap.NewNop().Sugar(), errTestInternal)
+	r.Policy = Policy{MaxRetries: maxRetries, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	return r, mock
+}
+
+// Транзакция с одним UPDATE: ошибка UPDATE прячется за errTestInternal,
+// как это делают репозитории.
+func update(tx *sql.Tx) error {
+	if _, err := tx.Exec(`UPDATE users SET amount_in_wallet = 0`); err != nil {
+		return Internal(err, errTestInternal)
+	}
+	return nil
+}
+
+func counter(name string) int64 {
+	v, ok := retries.Get(name).(interface{ Value() int64 })
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
+
+func TestRunner_Run(t *testing.T) {
+	deadlock := &pq.Error{Code: pqDeadlockDetected}
+	serialization := &pq.Error{Code: pqSerializationFailure}
+
+	tests := []struct {
+		name          string
+		maxRetries    int
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+		retries       int64
+	}{
+		{
+			name:       "Success",
+			maxRetries: 3,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:       "DeadlockRetried",
+			maxRetries: 3,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnError(deadlock)
+				mock.ExpectRollback()
+
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			retries: 1,
+		},
+		{
+			name:       "SerializationFailureOnCommit",
+			maxRetries: 3,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit().WillReturnError(serialization)
+
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			retries: 1,
+		},
+		{
+			name:       "BudgetExhausted",
+			maxRetries: 2,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				for i := 0; i < 3; i++ {
+					mock.ExpectBegin()
+					mock.ExpectExec(`UPDATE users`).WillReturnError(deadlock)
+					mock.ExpectRollback()
+				}
+			},
+			expectedError: errTestInternal,
+			retries:       2,
+		},
+		{
+			name:       "NoRetries",
+			maxRetries: 0,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnError(serialization)
+				mock.ExpectRollback()
+			},
+			expectedError: errTestInternal,
+		},
+		{
+			name:       "NotRetryable",
+			maxRetries: 3,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE users`).WillReturnError(&pq.Error{Code: "23514"})
+				mock.ExpectRollback()
+			},
+			expectedError: errTestInternal,
+		},
+		{
+			name:       "BeginError",
+			maxRetries: 3,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin().WillReturnError(errors.New("connection refused"))
+			},
+			expectedError: errTestInternal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, mock := newTestRunner(t, tt.maxRetries)
+			tt.mockDBSetup(mock)
+
+			before := counter(tt.name)
+			err := r.Run(context.Background(), tt.name, update)
+
+			assert.Equal(t, tt.expectedError, err)
+			assert.Equal(t, tt.retries, counter(tt.name)-before)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRunner_RunCancelled(t *testing.T) {
+	r, mock := newTestRunner(t, 3)
+
+	// запрос отменили, пока шла первая попытка: повторять некому
+	ctx, cancel := context.WithCancel(context.Background())
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users`).WillReturnError(&pq.Error{Code: pqDeadlockDetected})
+	mock.ExpectRollback()
+
+	err := r.Run(ctx, "cancelled", func(tx *sql.Tx) error {
+		cancel()
+		return update(tx)
+	})
+
+	assert.Equal(t, errTestInternal, err)
+	assert.Equal(t, int64(0), counter("cancelled"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestInternal(t *testing.T) {
+	deadlock := &pq.Error{Code: pqDeadlockDetected}
+
+	assert.Equal(t, errTestInternal, Internal(errors.New("syntax error"), errTestInternal))
+	assert.Equal(t, errTestInternal, Internal(&pq.Error{Code: "23505"}, errTestInternal))
+
+	err := Internal(deadlock, errTestInternal)
+	assert.True(t, Retryable(err))
+	assert.ErrorIs(t, err, errTestInternal)
+
+	// при переупаковке в ошибку другого пакета причина сохраняется
+	rewrapped := Internal(err, ErrInternalDB)
+	assert.Equal(t, &RetryableError{Err: ErrInternalDB, Cause: deadlock}, rewrapped)
+}
+
+func TestParseIsolation(t *testing.T) {
+	tests := map[string]struct {
+		level sql.IsolationLevel
+		err   error
+	}{
+		"":                {level: sql.LevelDefault},
+		"read committed":  {level: sql.LevelReadCommitted},
+		"Repeatable Read": {level: sql.LevelRepeatableRead},
+		"serializable":    {level: sql.LevelSerializable},
+		"chaos":           {err: ErrUnknownIsolation},
+	}
+
+	for in, tt := range tests {
+		level, err := ParseIsolation(in)
+		assert.ErrorIs(t, err, tt.err, in)
+		assert.Equal(t, tt.level, level, in)
+	}
+}
+
+func TestPolicy_backoff(t *testing.T) {
+	p := Policy{BaseDelay: 10 * time.Millisecond, MaxDelay: 40 * time.Millisecond}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		d := p.backoff(attempt)
+		assert.GreaterOrEqual(t, d, time.Duration(0))
+		assert.LessOrEqual(t, d, min(p.BaseDelay<<attempt, p.MaxDelay))
+	}
+
+	assert.Equal(t, time.Duration(0), Policy{}.backoff(3))
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"proj/internal/audit"
+	"proj/internal/catalog"
+	"proj/internal/session"
+	"proj/internal/user"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+type AdminHandlers struct {
+	ErrorResponder
+
+	Catalog  catalog.CatalogAdminRepo
+	Users    user.UserRepo
+	Sessions session.SessionManagerRepo
+	Audit    audit.AuditRepo
+	Logger   *zap.SugaredLogger
+}
+
+type SetRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+type SetActiveRequest struct {
+	Active *bool `json:"active"`
+}
+
+type RepriceRequest struct {
+	Price           int `json:"price"`
+	ExpectedVersion int `json:"expectedVersion,omitempty"`
+}
+
+func (h *AdminHandlers) ListItems(w http.ResponseWriter, r *http.Request) {
+	items, err := h.Catalog.ListAll(r.Context())
+	if err != nil {
+		h.SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, items, h.Logger)
+}
+
+func (h *AdminHandlers) CreateItem(w http.ResponseWriter, r *http.Request) {
+	var req catalog.NewItem
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	actor, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	item, err := h.Catalog.Create(r.Context(), req, actor.UserID)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, item, h.Logger)
+	h.Logger.Infof("store item - %s - created by userID - %s -", item.Slug, actor.UserID)
+}
+
+func (h *AdminHandlers) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	var req catalog.ItemUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	item, err := h.Catalog.Update(r.Context(), slug, req)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, item, h.Logger)
+}
+
+func (h *AdminHandlers) DeactivateItem(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	if err := h.Catalog.Deactivate(r.Context(), slug); err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandlers) RepriceItem(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	var req RepriceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	actor, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	item, err := h.Catalog.Reprice(r.Context(), slug, req.Price, req.ExpectedVersion, actor.UserID)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, item, h.Logger)
+	h.Logger.Infof("store item - %s - repriced to %d by userID - %s -", slug, req.Price, actor.UserID)
+}
+
+func (h *AdminHandlers) PriceHistory(w http.ResponseWriter, r *http.Request) {
+	slug := mux.Vars(r)["slug"]
+
+	history, err := h.Catalog.PriceHistory(r.Context(), slug)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, history, h.Logger)
+}
+
+// Полная замена ролей пользователя. Новые роли попадут в токен
+// при следующем обновлении по refresh-токену: роли читаются из users.
+func (h *AdminHandlers) SetRoles(w http.ResponseWriter, r *http.Request) {
+	login := mux.Vars(r)["login"]
+
+	var req SetRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	err := h.Users.SetRoles(r.Context(), login, req.Roles)
+	if err != nil {
+		// пользователь из пути не найден - это 404, а не ошибка запроса
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.SendErrorTo(w, err, http.StatusNotFound, h.Logger)
+			return
+		}
+
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	h.Logger.Infof("roles of user - %s - set to %v", login, req.Roles)
+}
+
+// Включение и выключение пользователя. Выключенному нельзя переводить монеты.
+func (h *AdminHandlers) SetActive(w http.ResponseWriter, r *http.Request) {
+	login := mux.Vars(r)["login"]
+
+	var req SetActiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+	// без поля легко выключить пользователя по ошибке
+	if req.Active == nil {
+		h.SendErrorTo(w, ErrActiveMissing, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	err := h.Users.SetActive(r.Context(), login, *req.Active)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.SendErrorTo(w, err, http.StatusNotFound, h.Logger)
+			return
+		}
+
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	h.Logger.Infof("user - %s - active set to %t", login, *req.Active)
+}
+
+// Новый код приглашения для регистрации через /api/register.
+func (h *AdminHandlers) CreateInvite(w http.ResponseWriter, r *http.Request) {
+	actor, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	inv, err := h.Users.CreateInvite(r.Context(), actor.UserID)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusCreated, inv, h.Logger)
+}
+
+// Ответ с телом в JSON. Заголовок уже отправлен, поэтому ошибку
+// кодирования только логируем.
+func sendJSON(w http.ResponseWriter, statusCode int, v interface{}, logger *zap.SugaredLogger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error(err)
+	}
+}
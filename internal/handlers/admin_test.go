@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/catalog"
+	"proj/internal/rbac"
+	"proj/internal/session"
+	"proj/internal/user"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newAdminHandlers(t *testing.T) (*catalog.MockCatalogAdminRepo, *session.MockSessionManagerRepo, *AdminHandlers) {
+	ctrl := gomock.NewController(t)
+
+	mockCatalog := catalog.NewMockCatalogAdminRepo(ctrl)
+	mockSessionManager := session.NewMockSessionManagerRepo(ctrl)
+
+	return mockCatalog, mockSessionManager, &AdminHandlers{
+		Catalog:  mockCatalog,
+		Sessions: mockSessionManager,
+		Logger:   zap.NewNop().Sugar(),
+	}
+}
+
+func TestAdminHandlers_RepriceItem(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"successful reprice": func(t *testing.T) {
+			mockCatalog, _, handler := newAdminHandlers(t)
+
+			mockCatalog.EXPECT().Reprice(gomock.Any(), "cup", 25, 1, MockUserID).
+				Return(catalog.AdminItem{Slug: "cup", Price: 25, PriceVersion: 2}, nil).Times(1)
+
+			req := httptest.NewRequest("PUT", "/api/admin/catalog/cup/price",
+				bytes.NewBufferString(`{"price":25,"expectedVersion":1}`))
+			req = mux.SetURLVars(req, map[string]string{"slug": "cup"})
+			req = withPrincipal(req)
+			w := httptest.NewRecorder()
+
+			handler.RepriceItem(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+		},
+
+		"version conflict": func(t *testing.T) {
+			mockCatalog, _, handler := newAdminHandlers(t)
+
+			mockCatalog.EXPECT().Reprice(gomock.Any(), "cup", 25, 1, MockUserID).
+				Return(catalog.AdminItem{}, catalog.ErrVersionConflict).Times(1)
+
+			req := httptest.NewRequest("PUT", "/api/admin/catalog/cup/price",
+				bytes.NewBufferString(`{"price":25,"expectedVersion":1}`))
+			req = mux.SetURLVars(req, map[string]string{"slug": "cup"})
+			req = withPrincipal(req)
+			w := httptest.NewRecorder()
+
+			handler.RepriceItem(w, req)
+
+			require.Equal(t, http.StatusConflict, w.Code)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestAdminHandlers_DeactivateItem(t *testing.T) {
+	mockCatalog, _, handler := newAdminHandlers(t)
+
+	mockCatalog.EXPECT().Deactivate(gomock.Any(), "cup").Return(nil).Times(1)
+	mockCatalog.EXPECT().Deactivate(gomock.Any(), "mug").Return(catalog.ErrItemNotFound).Times(1)
+
+	req := mux.SetURLVars(httptest.NewRequest("DELETE", "/api/admin/catalog/cup", nil),
+		map[string]string{"slug": "cup"})
+	w := httptest.NewRecorder()
+	handler.DeactivateItem(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	req = mux.SetURLVars(httptest.NewRequest("DELETE", "/api/admin/catalog/mug", nil),
+		map[string]string{"slug": "mug"})
+	w = httptest.NewRecorder()
+	handler.DeactivateItem(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestAdminHandlers_SetRoles(t *testing.T) {
+	tests := map[string]struct {
+		body       string
+		repoErr    error
+		callsRepo  bool
+		statusCode int
+	}{
+		"successful set": {body: `{"roles":["employee","finance"]}`, callsRepo: true, statusCode: http.StatusNoContent},
+		"unknown role":   {body: `{"roles":["root"]}`, repoErr: rbac.ErrUnknownRole, callsRepo: true, statusCode: http.StatusBadRequest},
+		"user not found": {body: `{"roles":["employee"]}`, repoErr: user.ErrUserNotFound, callsRepo: true, statusCode: http.StatusNotFound},
+		"invalid json":   {body: `{"roles":`, statusCode: http.StatusBadRequest},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockUsers := user.NewMockUserRepo(ctrl)
+			handler := &AdminHandlers{Users: mockUsers, Logger: zap.NewNop().Sugar()}
+
+			if tt.callsRepo {
+				mockUsers.EXPECT().SetRoles(gomock.Any(), "username", gomock.Any()).Return(tt.repoErr).Times(1)
+			}
+
+			req := httptest.NewRequest("PUT", "/api/admin/users/username/roles", bytes.NewBufferString(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"login": "username"})
+			w := httptest.NewRecorder()
+
+			handler.SetRoles(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestAdminHandlers_SetActive(t *testing.T) {
+	tests := map[string]struct {
+		body       string
+		active     bool
+		repoErr    error
+		callsRepo  bool
+		statusCode int
+	}{
+		"deactivate":     {body: `{"active":false}`, callsRepo: true, statusCode: http.StatusNoContent},
+		"activate":       {body: `{"active":true}`, active: true, callsRepo: true, statusCode: http.StatusNoContent},
+		"user not found": {body: `{"active":false}`, repoErr: user.ErrUserNotFound, callsRepo: true, statusCode: http.StatusNotFound},
+		"missing flag":   {body: `{}`, statusCode: http.StatusBadRequest},
+		"invalid json":   {body: `{"active":`, statusCode: http.StatusBadRequest},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockUsers := user.NewMockUserRepo(ctrl)
+			handler := &AdminHandlers{Users: mockUsers, Logger: zap.NewNop().Sugar()}
+
+			if tt.callsRepo {
+				mockUsers.EXPECT().SetActive(gomock.Any(), "username", tt.active).Return(tt.repoErr).Times(1)
+			}
+
+			req := httptest.NewRequest("PUT", "/api/admin/users/username/active", bytes.NewBufferString(tt.body))
+			req = mux.SetURLVars(req, map[string]string{"login": "username"})
+			w := httptest.NewRecorder()
+
+			handler.SetActive(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/audit"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newAuditHandlers(t *testing.T) (*audit.MockAuditRepo, *AdminHandlers) {
+	ctrl := gomock.NewController(t)
+	mockAudit := audit.NewMockAuditRepo(ctrl)
+
+	return mockAudit, &AdminHandlers{
+		Audit:  mockAudit,
+		Logger: zap.NewNop().Sugar(),
+	}
+}
+
+func TestAdminHandlers_AuditLog(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"filtered page": func(t *testing.T) {
+			mockAudit, handler := newAuditHandlers(t)
+
+			from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+			mockAudit.EXPECT().Query(gomock.Any(), audit.Filter{
+				Actor:  "user1",
+				Action: audit.ActionTransfer,
+				From:   from,
+				Limit:  10,
+			}).Return(audit.Page{
+				Items:      []audit.Event{{ID: 7, Actor: "user1", Action: audit.ActionTransfer}},
+				NextCursor: "Nw",
+			}, nil).Times(1)
+
+			req := httptest.NewRequest("GET",
+				"/api/admin/audit?actor=user1&action=coins.transfer&from=2025-01-01T00:00:00Z&limit=10", nil)
+			w := httptest.NewRecorder()
+
+			handler.AuditLog(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			var page audit.Page
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+			require.Len(t, page.Items, 1)
+			require.Equal(t, "Nw", page.NextCursor)
+		},
+
+		"bad date": func(t *testing.T) {
+			_, handler := newAuditHandlers(t)
+
+			req := httptest.NewRequest("GET", "/api/admin/audit?from=yesterday", nil)
+			w := httptest.NewRecorder()
+
+			handler.AuditLog(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+			require.Contains(t, w.Body.String(), "invalid_audit_filter")
+		},
+
+		"bad cursor": func(t *testing.T) {
+			mockAudit, handler := newAuditHandlers(t)
+
+			mockAudit.EXPECT().Query(gomock.Any(), gomock.Any()).
+				Return(audit.Page{}, audit.ErrInvalidFilter).Times(1)
+
+			req := httptest.NewRequest("GET", "/api/admin/audit?cursor=!!", nil)
+			w := httptest.NewRecorder()
+
+			handler.AuditLog(w, req)
+
+			require.Equal(t, http.StatusBadRequest, w.Code)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestAdminHandlers_VerifyAudit(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"intact chain": func(t *testing.T) {
+			mockAudit, handler := newAuditHandlers(t)
+
+			mockAudit.EXPECT().Verify(gomock.Any()).Return(audit.VerifyReport{Checked: 3, Pending: 1}, nil).Times(1)
+
+			req := httptest.NewRequest("GET", "/api/admin/audit/verify", nil)
+			w := httptest.NewRecorder()
+
+			handler.VerifyAudit(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.JSONEq(t, `{"ok":true,"checked":3,"pending":1}`, w.Body.String())
+		},
+
+		"broken chain": func(t *testing.T) {
+			mockAudit, handler := newAuditHandlers(t)
+
+			at := int64(2)
+			mockAudit.EXPECT().Verify(gomock.Any()).
+				Return(audit.VerifyReport{Checked: 2, BrokenAt: &at, Reason: "event is modified"}, nil).Times(1)
+
+			req := httptest.NewRequest("GET", "/api/admin/audit/verify", nil)
+			w := httptest.NewRecorder()
+
+			handler.VerifyAudit(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.JSONEq(t, `{"ok":false,"checked":2,"pending":0,"brokenAt":2,"reason":"event is modified"}`, w.Body.String())
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"proj/internal/catalog"
+
+	"go.uber.org/zap"
+)
+
+type CatalogHandlers struct {
+	ErrorResponder
+
+	Catalog catalog.CatalogRepo
+	Logger  *zap.SugaredLogger
+}
+
+// Список активных товаров магазина.
+func (h *CatalogHandlers) List(w http.ResponseWriter, r *http.Request) {
+	items, err := h.Catalog.List(r.Context())
+	if err != nil {
+		h.SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(catalog.CatalogResponse{Items: items}); err != nil {
+		h.SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		return
+	}
+}
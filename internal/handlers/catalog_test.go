@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/catalog"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCatalogHandlers_List(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"successful catalog retrieval": func(t *testing.T) {
+			mockCatalog := catalog.NewMockCatalogRepo(gomock.NewController(t))
+			handler := &CatalogHandlers{Catalog: mockCatalog, Logger: zap.NewNop().Sugar()}
+
+			mockCatalog.EXPECT().List(gomock.Any()).Return([]catalog.Item{
+				{Code: 1, Slug: "cup", DisplayName: "Cup", Price: 20},
+			}, nil).Times(1)
+
+			w := httptest.NewRecorder()
+			handler.List(w, httptest.NewRequest("GET", "/catalog", nil))
+
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var resp catalog.CatalogResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			require.Equal(t, []catalog.Item{{Slug: "cup", DisplayName: "Cup", Price: 20}}, resp.Items)
+		},
+
+		"internal server error": func(t *testing.T) {
+			mockCatalog := catalog.NewMockCatalogRepo(gomock.NewController(t))
+			handler := &CatalogHandlers{Catalog: mockCatalog, Logger: zap.NewNop().Sugar()}
+
+			mockCatalog.EXPECT().List(gomock.Any()).Return(nil, errors.New("internal error")).Times(1)
+
+			w := httptest.NewRecorder()
+			handler.List(w, httptest.NewRequest("GET", "/catalog", nil))
+
+			require.Equal(t, http.StatusInternalServerError, w.Code)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -1,19 +1,205 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"proj/internal/audit"
+	"proj/internal/catalog"
+	"proj/internal/idempotency"
+	"proj/internal/middleware"
+	"proj/internal/rbac"
+	"proj/internal/session"
+	"proj/internal/user"
 
 	"go.uber.org/zap"
 )
 
+const (
+	ContentTypeProblem = "application/problem+json"
+
+	// Нестандартный статус nginx: клиент закрыл соединение,
+	// не дождавшись ответа
+	StatusClientClosedRequest = 499
+
+	// Префикс поля type у problem+json, дальше идет код ошибки
+	problemTypePrefix = "urn:merch-store:error:"
+)
+
 var (
-	ErrHeaderNotSet    = errors.New("header not set")
-	ErrInvalidToken    = errors.New("invalid token in header")
-	ErrInvalidUsername = errors.New("invalid username")
+	ErrUnauthenticated    = errors.New("request is not authenticated")
+	ErrInvalidUsername    = errors.New("invalid username")
+	ErrInvalidCredentials = errors.New("username or password has invalid size")
+	ErrActiveMissing      = errors.New("active flag is required")
 )
 
+// Формат ответов с ошибками, встраивается в хендлеры. Middleware
+// получают метод SendErrorTo, чтобы отвечать в том же формате.
+type ErrorResponder struct {
+	// Отвечать ошибками в старом формате {"errors": "..."}, пока
+	// клиенты не перешли на problem+json. Выставляется из конфига.
+	LegacyErrors bool
+}
+
+// Описание ошибки для клиента: стабильный код, по которому можно
+// ветвиться, статус по умолчанию и короткий заголовок.
+type APIError struct {
+	Code    string
+	Status  int
+	Title   string
+	details func(err error) map[string]interface{}
+}
+
+/*
+Каталог ошибок API. Код - часть контракта, менять его нельзя,
+текст ошибки может меняться. Ошибки ищутся через errors.Is
+сверху вниз, поэтому обертки должны идти раньше базовых ошибок.
+
+Статус здесь - статус по умолчанию: хендлер может выбрать другой,
+если ошибка в его контексте значит другое (например, пользователь
+не найден при входе - это 401).
+*/
+var errorCatalogue = []struct {
+	err error
+	APIError
+}{
+	// user
+	{user.ErrInsufficientFunds, APIError{Code: "insufficient_funds", Status: http.StatusBadRequest, Title: "Insufficient funds",
+		details: insufficientFundsDetails}},
+	{user.ErrUserNotFound, APIError{Code: "user_not_found", Status: http.StatusBadRequest, Title: "User not found"}},
+	{user.ErrItemNotFound, APIError{Code: "item_not_found", Status: http.StatusBadRequest, Title: "Item not found"}},
+	{user.ErrBadPassword, APIError{Code: "bad_password", Status: http.StatusUnauthorized, Title: "Invalid password"}},
+	{user.ErrUserExists, APIError{Code: "user_exists", Status: http.StatusConflict, Title: "User already exists"}},
+	{user.ErrRegistrationClosed, APIError{Code: "registration_closed", Status: http.StatusForbidden, Title: "Registration requires an invite"}},
+	{user.ErrInvalidInvite, APIError{Code: "invalid_invite", Status: http.StatusForbidden, Title: "Invalid invite"}},
+	{user.ErrInvalidCursor, APIError{Code: "invalid_cursor", Status: http.StatusBadRequest, Title: "Invalid history cursor"}},
+	{user.ErrInvalidHistoryFilter, APIError{Code: "invalid_history_filter", Status: http.StatusBadRequest, Title: "Invalid history filter"}},
+	{user.ErrInvalidAmount, APIError{Code: "invalid_amount", Status: http.StatusBadRequest, Title: "Invalid transfer amount"}},
+	{user.ErrSelfTransfer, APIError{Code: "self_transfer", Status: http.StatusBadRequest, Title: "Self-transfer is not allowed"}},
+	{user.ErrTransferLimit, APIError{Code: "transfer_limit_exceeded", Status: http.StatusBadRequest, Title: "Per-transfer limit exceeded",
+		details: limitDetails}},
+	{user.ErrDailyLimit, APIError{Code: "daily_limit_exceeded", Status: http.StatusBadRequest, Title: "Daily transfer limit exceeded",
+		details: limitDetails}},
+	{user.ErrRecipientInactive, APIError{Code: "recipient_inactive", Status: http.StatusBadRequest, Title: "Recipient is not active"}},
+	{user.ErrBalanceOverflow, APIError{Code: "balance_overflow", Status: http.StatusBadRequest, Title: "Recipient balance overflow"}},
+
+	// session
+	{session.ErrTokenMissing, APIError{Code: "token_missing", Status: http.StatusUnauthorized, Title: "Access token missing"}},
+	{session.ErrTokenMalformed, APIError{Code: "token_malformed", Status: http.StatusUnauthorized, Title: "Access token malformed"}},
+	{session.ErrTokenExpired, APIError{Code: "token_expired", Status: http.StatusUnauthorized, Title: "Access token expired"}},
+	{session.ErrNoAuth, APIError{Code: "session_not_found", Status: http.StatusUnauthorized, Title: "Session not found"}},
+	{session.ErrRevoked, APIError{Code: "session_revoked", Status: http.StatusUnauthorized, Title: "Session revoked"}},
+	{session.ErrSessionExpired, APIError{Code: "session_expired", Status: http.StatusUnauthorized, Title: "Session expired"}},
+	{session.ErrRefreshReused, APIError{Code: "refresh_token_reused", Status: http.StatusUnauthorized, Title: "Refresh token reuse detected"}},
+
+	// catalog
+	{catalog.ErrItemNotFound, APIError{Code: "item_not_found", Status: http.StatusNotFound, Title: "Item not found"}},
+	{catalog.ErrInvalidSlug, APIError{Code: "invalid_slug", Status: http.StatusBadRequest, Title: "Invalid item slug"}},
+	{catalog.ErrInvalidItem, APIError{Code: "invalid_item", Status: http.StatusBadRequest, Title: "Invalid item data"}},
+	{catalog.ErrItemExists, APIError{Code: "item_exists", Status: http.StatusConflict, Title: "Item already exists"}},
+	{catalog.ErrVersionConflict, APIError{Code: "version_conflict", Status: http.StatusConflict, Title: "Price version conflict"}},
+
+	// audit
+	{audit.ErrInvalidFilter, APIError{Code: "invalid_audit_filter", Status: http.StatusBadRequest, Title: "Invalid audit filter"}},
+
+	// idempotency
+	{idempotency.ErrKeyReused, APIError{Code: "idempotency_key_reused", Status: http.StatusUnprocessableEntity, Title: "Idempotency key reused"}},
+	{idempotency.ErrRequestInProgress, APIError{Code: "request_in_progress", Status: http.StatusConflict, Title: "Request in progress"}},
+
+	// запрос отменен: клиент ушел или вышел таймаут маршрута
+	{context.Canceled, APIError{Code: "client_closed_request", Status: StatusClientClosedRequest, Title: "Client closed request"}},
+	{context.DeadlineExceeded, APIError{Code: "timeout", Status: http.StatusGatewayTimeout, Title: "Request timed out"}},
+
+	// авторизация и валидация запроса
+	{ErrUnauthenticated, APIError{Code: "unauthenticated", Status: http.StatusUnauthorized, Title: "Not authenticated"}},
+	{middleware.ErrForbidden, APIError{Code: "forbidden", Status: http.StatusForbidden, Title: "Permission denied"}},
+	{rbac.ErrUnknownRole, APIError{Code: "unknown_role", Status: http.StatusBadRequest, Title: "Unknown role"}},
+	{ErrInvalidCredentials, APIError{Code: "invalid_credentials", Status: http.StatusBadRequest, Title: "Invalid credentials format"}},
+	{ErrInvalidIdempotencyKey, APIError{Code: "invalid_idempotency_key", Status: http.StatusBadRequest, Title: "Invalid idempotency key"}},
+}
+
+// Коды для ошибок вне каталога, по статусу ответа.
+var genericCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthenticated",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusInternalServerError: "internal",
+}
+
+// Описание ошибки из каталога. Для неизвестной ошибки код берется
+// по статусу, ok == false.
+func LookupError(err error, statusCode int) (APIError, bool) {
+	for _, e := range errorCatalogue {
+		if errors.Is(err, e.err) {
+			return e.APIError, true
+		}
+	}
+
+	code, ok := genericCodes[statusCode]
+	if !ok {
+		code = "error"
+	}
+	return APIError{Code: code, Status: statusCode, Title: http.StatusText(statusCode)}, false
+}
+
+func insufficientFundsDetails(err error) map[string]interface{} {
+	var e *user.InsufficientFundsError
+	if !errors.As(err, &e) {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"required":  e.Required,
+		"available": e.Available,
+	}
+}
+
+func limitDetails(err error) map[string]interface{} {
+	var e *user.LimitError
+	if !errors.As(err, &e) {
+		return nil
+	}
+
+	return map[string]interface{}{
+		"limit":     e.Limit,
+		"remaining": e.Remaining,
+	}
+}
+
+// Ответ с ошибкой по RFC 7807.
+type Problem struct {
+	Type    string                 `json:"type"`
+	Title   string                 `json:"title"`
+	Status  int                    `json:"status"`
+	Detail  string                 `json:"detail,omitempty"`
+	Code    string                 `json:"code"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+func NewProblem(err error, statusCode int) Problem {
+	apiErr, known := LookupError(err, statusCode)
+
+	p := Problem{
+		Type:   problemTypePrefix + apiErr.Code,
+		Title:  apiErr.Title,
+		Status: statusCode,
+		Code:   apiErr.Code,
+	}
+	// Текст неизвестной 5xx ошибки может раскрыть внутренности
+	if known || statusCode < http.StatusInternalServerError {
+		p.Detail = err.Error()
+	}
+	if apiErr.details != nil {
+		p.Details = apiErr.details(err)
+	}
+
+	return p
+}
+
+// Старый формат ошибки, отдается в режиме LegacyErrors.
 type ServerError struct {
 	Errors string `json:"errors"`
 }
@@ -28,10 +214,38 @@ func NewErrorServer(err error) ServerError {
 	}
 }
 
-func SendErrorTo(w http.ResponseWriter, err error, statusCode int, logger *zap.SugaredLogger) {
-	w.Header().Set("Content-Type", "application/json")
+func (e ErrorResponder) SendErrorTo(w http.ResponseWriter, err error, statusCode int, logger *zap.SugaredLogger) {
+	var body interface{}
+	if e.LegacyErrors {
+		w.Header().Set("Content-Type", "application/json")
+		body = NewErrorServer(err)
+	} else {
+		w.Header().Set("Content-Type", ContentTypeProblem)
+		body = NewProblem(err, statusCode)
+	}
+
 	w.WriteHeader(statusCode)
-	if errEncode := json.NewEncoder(w).Encode(NewErrorServer(err)); errEncode != nil {
+	if errEncode := json.NewEncoder(w).Encode(body); errEncode != nil {
 		logger.Error(errEncode)
 	}
 }
+
+/*
+Ошибка со статусом из каталога, для неизвестных ошибок - 500.
+
+Если контекст запроса уже отменен, ошибка репозитория - только
+следствие отмены, поэтому отвечаем по причине: 499 или 504.
+*/
+func (e ErrorResponder) sendError(w http.ResponseWriter, r *http.Request, err error, logger *zap.SugaredLogger) {
+	if ctxErr := r.Context().Err(); ctxErr != nil {
+		logger.Infof("%v. More details: %s, %v", ctxErr, r.URL.Path, err)
+		err = ctxErr
+	}
+
+	statusCode := http.StatusInternalServerError
+	if apiErr, ok := LookupError(err, statusCode); ok {
+		statusCode = apiErr.Status
+	}
+
+	e.SendErrorTo(w, err, statusCode, logger)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/session"
+	"proj/internal/user"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestSendErrorTo(t *testing.T) {
+	tests := map[string]struct {
+		err         error
+		statusCode  int
+		contentType string
+		body        string
+	}{
+		"insufficient funds with details": {
+			err:         &user.InsufficientFundsError{Required: 150, Available: 100},
+			statusCode:  http.StatusBadRequest,
+			contentType: ContentTypeProblem,
+			body: `{"type":"urn:merch-store:error:insufficient_funds","title":"Insufficient funds","status":400,` +
+				`"detail":"insufficient funds","code":"insufficient_funds","details":{"available":100,"required":150}}`,
+		},
+		"daily limit with details": {
+			err:         &user.LimitError{Err: user.ErrDailyLimit, Limit: 100, Remaining: 30},
+			statusCode:  http.StatusBadRequest,
+			contentType: ContentTypeProblem,
+			body: `{"type":"urn:merch-store:error:daily_limit_exceeded","title":"Daily transfer limit exceeded","status":400,` +
+				`"detail":"daily outgoing transfer limit exceeded","code":"daily_limit_exceeded","details":{"limit":100,"remaining":30}}`,
+		},
+		"wrapped catalogued error": {
+			err:         fmt.Errorf("%w: limit", user.ErrInvalidHistoryFilter),
+			statusCode:  http.StatusBadRequest,
+			contentType: ContentTypeProblem,
+			body: `{"type":"urn:merch-store:error:invalid_history_filter","title":"Invalid history filter","status":400,` +
+				`"detail":"invalid history filter: limit","code":"invalid_history_filter"}`,
+		},
+		"session error": {
+			err:         session.ErrTokenExpired,
+			statusCode:  http.StatusUnauthorized,
+			contentType: ContentTypeProblem,
+			body: `{"type":"urn:merch-store:error:token_expired","title":"Access token expired","status":401,` +
+				`"detail":"access token expired","code":"token_expired"}`,
+		},
+		"unknown internal error is not exposed": {
+			err:         errors.New("pq: connection refused"),
+			statusCode:  http.StatusInternalServerError,
+			contentType: ContentTypeProblem,
+			body:        `{"type":"urn:merch-store:error:internal","title":"Internal Server Error","status":500,"code":"internal"}`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ErrorResponder{}.SendErrorTo(w, tt.err, tt.statusCode, zap.NewNop().Sugar())
+
+			require.Equal(t, tt.statusCode, w.Code)
+			require.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			require.JSONEq(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+func TestSendErrorTo_Legacy(t *testing.T) {
+	w := httptest.NewRecorder()
+	ErrorResponder{LegacyErrors: true}.SendErrorTo(w, &user.InsufficientFundsError{Required: 150, Available: 100}, http.StatusBadRequest, zap.NewNop().Sugar())
+
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var resp ServerError
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(t, user.ErrInsufficientFunds.Error(), resp.Errors)
+}
+
+func TestSendError_StatusFromCatalogue(t *testing.T) {
+	tests := map[string]struct {
+		err        error
+		statusCode int
+	}{
+		"catalogued":   {err: user.ErrUserExists, statusCode: http.StatusConflict},
+		"wrapped":      {err: fmt.Errorf("buy: %w", &user.InsufficientFundsError{}), statusCode: http.StatusBadRequest},
+		"not in table": {err: user.ErrInternalDB, statusCode: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			ErrorResponder{}.sendError(w, httptest.NewRequest("GET", "/api/info", nil), tt.err, zap.NewNop().Sugar())
+
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestSendError_CancelledRequest(t *testing.T) {
+	tests := map[string]struct {
+		ctx        func() (context.Context, context.CancelFunc)
+		statusCode int
+		code       string
+	}{
+		"client gone": {
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				return ctx, cancel
+			},
+			statusCode: StatusClientClosedRequest,
+			code:       "client_closed_request",
+		},
+		"timeout": {
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			},
+			statusCode: http.StatusGatewayTimeout,
+			code:       "timeout",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			req := httptest.NewRequest("POST", "/api/sendCoin", nil).WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			// репозиторий видит отмену как обычную ошибку базы
+			ErrorResponder{}.sendError(w, req, user.ErrInternalDB, zap.NewNop().Sugar())
+
+			require.Equal(t, tt.statusCode, w.Code)
+
+			var p Problem
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+			require.Equal(t, tt.code, p.Code)
+		})
+	}
+}
+
+// Формат задается полем хендлера, другие хендлеры он не затрагивает.
+func TestUserHandlers_LegacyErrors(t *testing.T) {
+	_, _, handler := NewCtrlAndUserRepos(t)
+	handler.LegacyErrors = true
+
+	w := httptest.NewRecorder()
+	handler.SendCoin(w, httptest.NewRequest("POST", "/api/sendCoin", strings.NewReader("{")))
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	w = httptest.NewRecorder()
+	(&CatalogHandlers{Logger: zap.NewNop().Sugar()}).SendErrorTo(w, user.ErrItemNotFound, http.StatusBadRequest, zap.NewNop().Sugar())
+	require.Equal(t, ContentTypeProblem, w.Header().Get("Content-Type"))
+}
@@ -1,65 +1,62 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
+	"proj/internal/metrics"
 	"proj/internal/middleware"
+	"proj/internal/rbac"
 	"proj/internal/session"
-	"strings"
 
-	"github.com/golang-jwt/jwt"
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
-func GetUserDataByJWT(
-	w http.ResponseWriter,
-	r *http.Request,
-	field string,
-	secret string,
-	logger *zap.SugaredLogger,
-) string {
-	t := r.Header.Get("Authorization")
-	if t == "" {
-		logger.Errorf("%v. More: %v", ErrHeaderNotSet)
-		SendErrorTo(w, ErrHeaderNotSet, http.StatusBadRequest, logger)
-		return ""
-	}
-
-	t = strings.TrimPrefix(t, "Bearer ")
-
-	token, err := jwt.Parse(t, func(token *jwt.Token) (interface{}, error) {
-		// Проверка метода подписи
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			logger.Error(session.ErrUnexpectedMethod)
-			return nil, session.ErrUnexpectedMethod
-		}
-		// Возвращаем секрет для проверки подписи
-		return []byte(secret), nil
-	})
-	if err != nil || !token.Valid {
-		fmt.Println(err)
-		logger.Error(ErrInvalidToken, err)
-		SendErrorTo(w, ErrInvalidToken, http.StatusUnauthorized, logger)
-		return ""
-	}
-
-	// Извлекаем claims и получаем username
-	claims, okToken := token.Claims.(jwt.MapClaims)
-	m, okClaims := claims["user"].(map[string]interface{})
-	if !okToken || !okClaims || claims["user"].(map[string]interface{})[field] == nil {
-		logger.Error(ErrInvalidToken, err)
-		SendErrorTo(w, ErrInvalidToken, http.StatusUnauthorized, logger)
-		return ""
+// Пользователь, которого middleware положил в контекст. Если его нет -
+// отвечаем 401, до репозитория такой запрос не доходит.
+func (e ErrorResponder) principalFrom(w http.ResponseWriter, r *http.Request, logger *zap.SugaredLogger) (session.Principal, bool) {
+	p, ok := session.FromContext(r.Context())
+	if !ok {
+		logger.Errorf("%v. More details: %s", ErrUnauthenticated, r.URL.Path)
+		middleware.Unauthorized(w, ErrUnauthenticated, e.SendErrorTo, logger)
+		return session.Principal{}, false
 	}
 
-	return m[field].(string)
+	return p, true
 }
 
-func NewRouters(uh *UserHandlers, sm *session.SessionManager, logger *zap.SugaredLogger) http.Handler {
+func NewRouters(
+	uh *UserHandlers,
+	ch *CatalogHandlers,
+	ah *AdminHandlers,
+	kh *KeysHandlers,
+	sm *session.SessionManager,
+	timeouts middleware.Timeouts,
+	logger *zap.SugaredLogger,
+) http.Handler {
 	r := mux.NewRouter()
+	// метрики первыми: время запроса включает всю цепочку middleware
+	r.Use(middleware.Metrics)
+	// серверный спан, дальше по цепочке все идет в его контексте
+	r.Use(middleware.Tracing)
+	// id запроса и адрес клиента для журнала аудита
+	r.Use(middleware.RequestMeta)
+	// дедлайн запроса ставим до авторизации, чтобы он касался и ее
+	r.Use(middleware.Timeout(timeouts))
+
+	r.HandleFunc("/.well-known/jwks.json", kh.JWKS).Methods("GET")
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+
+	// админские маршруты регистрируем первыми, чтобы /api/admin
+	// не попал в общий /api с обычной авторизацией
+	initAdminHandlers(r, sm, ah)
+	initHandlers(r, sm, uh, ch)
 
-	initHandlers(r, sm, uh)
+	// middleware роутера не вызываются, если маршрут не найден,
+	// такие ответы считаем отдельно
+	r.NotFoundHandler = middleware.Metrics(http.NotFoundHandler())
+	r.MethodNotAllowedHandler = middleware.Metrics(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
 
 	return r
 }
@@ -68,13 +65,57 @@ func initHandlers(
 	r *mux.Router,
 	sm *session.SessionManager,
 	userHandler *UserHandlers,
+	catalogHandler *CatalogHandlers,
 ) {
 	authRouter := r.PathPrefix("/api").Subrouter()
-	authRouter.Use(middleware.Auth(sm))
+	authRouter.Use(middleware.Auth(sm, userHandler.SendErrorTo))
 	authRouter.HandleFunc("/info", userHandler.Info).Methods("GET")
-	authRouter.HandleFunc("/sendCoin", userHandler.SendCoin).Methods("POST")
-	authRouter.HandleFunc("/buy/{item}", userHandler.BuyItem).Methods("GET")
+	authRouter.HandleFunc("/history", userHandler.History).Methods("GET")
+	authRouter.HandleFunc("/catalog", catalogHandler.List).Methods("GET")
+	authRouter.HandleFunc("/sendCoin", userHandler.Idempotent(userHandler.SendCoin)).Methods("POST")
+	authRouter.HandleFunc("/buy/{item}", userHandler.Idempotent(userHandler.BuyItem)).Methods("GET")
+	authRouter.HandleFunc("/logout", userHandler.Logout).Methods("POST")
+	authRouter.HandleFunc("/logout-all", userHandler.LogoutAll).Methods("POST")
+	authRouter.HandleFunc("/sessions", userHandler.ListSessions).Methods("GET")
+	authRouter.HandleFunc("/sessions/{id}", userHandler.DeleteSession).Methods("DELETE")
 
 	noAuthRouter := r.PathPrefix("/api").Subrouter()
 	noAuthRouter.HandleFunc("/auth", userHandler.Auth).Methods("POST")
+	noAuthRouter.HandleFunc("/login", userHandler.Login).Methods("POST")
+	noAuthRouter.HandleFunc("/register", userHandler.Register).Methods("POST")
+	noAuthRouter.HandleFunc("/token/refresh", userHandler.RefreshToken).Methods("POST")
+}
+
+// Привилегированные маршруты, каждая группа требует свое право.
+func initAdminHandlers(
+	r *mux.Router,
+	sm *session.SessionManager,
+	adminHandler *AdminHandlers,
+) {
+	catalogRouter := r.PathPrefix("/api/admin/catalog").Subrouter()
+	catalogRouter.Use(middleware.RequirePermission(sm, rbac.PermCatalogManage, adminHandler.SendErrorTo))
+	catalogRouter.HandleFunc("", adminHandler.ListItems).Methods("GET")
+	catalogRouter.HandleFunc("", adminHandler.CreateItem).Methods("POST")
+	catalogRouter.HandleFunc("/{slug}", adminHandler.UpdateItem).Methods("PATCH")
+	catalogRouter.HandleFunc("/{slug}", adminHandler.DeactivateItem).Methods("DELETE")
+	catalogRouter.HandleFunc("/{slug}/price", adminHandler.RepriceItem).Methods("PUT")
+	catalogRouter.HandleFunc("/{slug}/prices", adminHandler.PriceHistory).Methods("GET")
+
+	usersRouter := r.PathPrefix("/api/admin/users").Subrouter()
+	usersRouter.Use(middleware.RequirePermission(sm, rbac.PermUsersManage, adminHandler.SendErrorTo))
+	usersRouter.HandleFunc("/{login}/roles", adminHandler.SetRoles).Methods("PUT")
+	usersRouter.HandleFunc("/{login}/active", adminHandler.SetActive).Methods("PUT")
+
+	sessionsRouter := r.PathPrefix("/api/admin/users/{login}/sessions").Subrouter()
+	sessionsRouter.Use(middleware.RequirePermission(sm, rbac.PermSessionsRevoke, adminHandler.SendErrorTo))
+	sessionsRouter.HandleFunc("", adminHandler.RevokeSessions).Methods("DELETE")
+
+	invitesRouter := r.PathPrefix("/api/admin/invites").Subrouter()
+	invitesRouter.Use(middleware.RequirePermission(sm, rbac.PermUsersManage, adminHandler.SendErrorTo))
+	invitesRouter.HandleFunc("", adminHandler.CreateInvite).Methods("POST")
+
+	auditRouter := r.PathPrefix("/api/admin/audit").Subrouter()
+	auditRouter.Use(middleware.RequirePermission(sm, rbac.PermAuditRead, adminHandler.SendErrorTo))
+	auditRouter.HandleFunc("", adminHandler.AuditLog).Methods("GET")
+	auditRouter.HandleFunc("/verify", adminHandler.VerifyAudit).Methods("GET")
 }
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"proj/internal/idempotency"
+)
+
+var (
+	ErrInvalidIdempotencyKey = errors.New("invalid idempotency key")
+)
+
+// Обертка над ResponseWriter, которая запоминает код и тело ответа,
+// чтобы сохранить их под ключом идемпотентности.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(statusCode int) {
+	rw.status = statusCode
+	rw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
+
+/*
+Idempotent оборачивает хендлер, который двигает деньги:
+  - Нет заголовка Idempotency-Key - выполняем как обычно
+  - Ключ новый 					  - выполняем и сохраняем результат
+  - Ключ уже выполнен 			  - отдаем сохраненный ответ, в репозиторий не ходим
+  - Тот же ключ с другим телом 	  - 422
+  - Тот же ключ еще выполняется 	  - 409
+
+Если запрос упал с 5xx или был отменен (499), ключ освобождаем,
+чтобы клиент мог повторить.
+*/
+func (h *UserHandlers) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotency.HeaderKey)
+		if h.Idempotency == nil || key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > idempotency.KeyMaxLen {
+			h.SendErrorTo(w, ErrInvalidIdempotencyKey, http.StatusBadRequest, h.Logger)
+			return
+		}
+
+		p, ok := h.principalFrom(w, r, h.Logger)
+		if !ok {
+			return
+		}
+		userID := p.UserID
+
+		// Тело читаем целиком для отпечатка и возвращаем обратно для хендлера
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fp := idempotency.Fingerprint(r.Method, r.URL.Path, body)
+		rec, err := h.Idempotency.Reserve(r.Context(), userID, key, fp)
+		if err != nil {
+			h.sendError(w, r, err, h.Logger)
+			return
+		}
+
+		// Запрос уже выполнялся - повторяем сохраненный ответ
+		if rec.Completed() {
+			w.Header().Set(idempotency.HeaderReplayed, "true")
+			if len(rec.Body) > 0 {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			w.WriteHeader(rec.StatusCode)
+			if _, err := w.Write(rec.Body); err != nil {
+				h.Logger.Error(err)
+			}
+
+			h.Logger.Infof("replayed response for idempotency key - %s - userID - %s -", key, userID)
+			return
+		}
+
+		// Успешный результат репозиторий сохранит в транзакции с деньгами
+		r = r.WithContext(idempotency.WithReservation(r.Context(), rec))
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+		next(rw, r)
+
+		// Запрос мог быть отменен по таймауту, а ключ все равно нужно
+		// освободить или сохранить результат
+		ctx := context.WithoutCancel(r.Context())
+		// 499 - запрос отменен, не выполнен: клиент может повторить его
+		if rw.status >= http.StatusInternalServerError || rw.status == StatusClientClosedRequest {
+			if err := h.Idempotency.Release(ctx, rec); err != nil {
+				h.Logger.Errorf("failed to release idempotency key - %s -: %v", key, err)
+			}
+			return
+		}
+
+		if err := h.Idempotency.Complete(ctx, rec, rw.status, rw.body.Bytes()); err != nil {
+			h.Logger.Errorf("failed to store result for idempotency key - %s -: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/idempotency"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserHandlers_Idempotent(t *testing.T) {
+	// новый ключ: запись "в процессе"
+	reserved := &idempotency.Record{UserID: MockUserID, Key: "key1"}
+
+	tests := map[string]func(t *testing.T){
+		"first request is executed and stored": func(t *testing.T) {
+			_, _, handler := NewCtrlAndUserRepos(t)
+			mockIdem := idempotency.NewMockIdempotencyRepo(gomock.NewController(t))
+			handler.Idempotency = mockIdem
+
+			mockIdem.EXPECT().Reserve(gomock.Any(), MockUserID, "key1", gomock.Any()).Return(reserved, nil).Times(1)
+			mockIdem.EXPECT().Complete(gomock.Any(), reserved, http.StatusOK, []byte(nil)).Return(nil).Times(1)
+
+			calls := 0
+			h := handler.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("POST", "/api/sendCoin", bytes.NewBufferString(`{"toUser":"a","amount":1}`))
+			req = withPrincipal(req)
+			req.Header.Set(idempotency.HeaderKey, "key1")
+			w := httptest.NewRecorder()
+
+			h(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, 1, calls)
+		},
+
+		"repeated request is replayed": func(t *testing.T) {
+			_, _, handler := NewCtrlAndUserRepos(t)
+			mockIdem := idempotency.NewMockIdempotencyRepo(gomock.NewController(t))
+			handler.Idempotency = mockIdem
+
+			mockIdem.EXPECT().Reserve(gomock.Any(), MockUserID, "key1", gomock.Any()).
+				Return(&idempotency.Record{StatusCode: http.StatusOK}, nil).Times(1)
+
+			h := handler.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("handler must not be called on replay")
+			})
+
+			req := httptest.NewRequest("POST", "/api/sendCoin", bytes.NewBufferString(`{"toUser":"a","amount":1}`))
+			req = withPrincipal(req)
+			req.Header.Set(idempotency.HeaderKey, "key1")
+			w := httptest.NewRecorder()
+
+			h(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, "true", w.Header().Get(idempotency.HeaderReplayed))
+		},
+
+		"key reused with different payload": func(t *testing.T) {
+			_, _, handler := NewCtrlAndUserRepos(t)
+			mockIdem := idempotency.NewMockIdempotencyRepo(gomock.NewController(t))
+			handler.Idempotency = mockIdem
+
+			mockIdem.EXPECT().Reserve(gomock.Any(), MockUserID, "key1", gomock.Any()).
+				Return(nil, idempotency.ErrKeyReused).Times(1)
+
+			h := handler.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+				t.Fatal("handler must not be called on key reuse")
+			})
+
+			req := httptest.NewRequest("POST", "/api/sendCoin", bytes.NewBufferString(`{"toUser":"a","amount":2}`))
+			req = withPrincipal(req)
+			req.Header.Set(idempotency.HeaderKey, "key1")
+			w := httptest.NewRecorder()
+
+			h(w, req)
+
+			require.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		},
+
+		"failed request releases key": func(t *testing.T) {
+			_, _, handler := NewCtrlAndUserRepos(t)
+			mockIdem := idempotency.NewMockIdempotencyRepo(gomock.NewController(t))
+			handler.Idempotency = mockIdem
+
+			mockIdem.EXPECT().Reserve(gomock.Any(), MockUserID, "key1", gomock.Any()).Return(reserved, nil).Times(1)
+			mockIdem.EXPECT().Release(gomock.Any(), reserved).Return(nil).Times(1)
+
+			h := handler.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			})
+
+			req := httptest.NewRequest("GET", "/api/buy/pen", nil)
+			req = withPrincipal(req)
+			req.Header.Set(idempotency.HeaderKey, "key1")
+			w := httptest.NewRecorder()
+
+			h(w, req)
+
+			require.Equal(t, http.StatusInternalServerError, w.Code)
+		},
+
+		"cancelled request releases key": func(t *testing.T) {
+			_, _, handler := NewCtrlAndUserRepos(t)
+			mockIdem := idempotency.NewMockIdempotencyRepo(gomock.NewController(t))
+			handler.Idempotency = mockIdem
+
+			mockIdem.EXPECT().Reserve(gomock.Any(), MockUserID, "key1", gomock.Any()).Return(reserved, nil).Times(1)
+			mockIdem.EXPECT().Release(gomock.Any(), reserved).Return(nil).Times(1)
+
+			h := handler.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(StatusClientClosedRequest)
+			})
+
+			req := httptest.NewRequest("GET", "/api/buy/pen", nil)
+			req = withPrincipal(req)
+			req.Header.Set(idempotency.HeaderKey, "key1")
+			w := httptest.NewRecorder()
+
+			h(w, req)
+
+			require.Equal(t, StatusClientClosedRequest, w.Code)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
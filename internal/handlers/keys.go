@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"net/http"
+	"proj/internal/keyring"
+
+	"go.uber.org/zap"
+)
+
+// Кэшировать JWKS дольше периода синхронизации ключей нет смысла.
+const jwksCacheControl = "public, max-age=60"
+
+type KeysHandlers struct {
+	Keys   *keyring.Keyring
+	Logger *zap.SugaredLogger
+}
+
+// Публичные ключи для проверки наших токенов другими сервисами.
+func (h *KeysHandlers) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", jwksCacheControl)
+	sendJSON(w, http.StatusOK, h.Keys.JWKS(), h.Logger)
+}
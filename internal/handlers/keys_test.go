@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/keyring"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestKeysHandlers_JWKS(t *testing.T) {
+	k, err := keyring.Generate(keyring.AlgEdDSA)
+	require.NoError(t, err)
+
+	handler := &KeysHandlers{Keys: keyring.New(k), Logger: zap.NewNop().Sugar()}
+
+	w := httptest.NewRecorder()
+	handler.JWKS(w, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var set keyring.JWKSet
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	require.Equal(t, k.ID, set.Keys[0].KeyID)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"proj/internal/session"
+	"proj/internal/user"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Обмен refresh-токена на новую пару токенов.
+func (h *UserHandlers) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	sess, tokens, err := h.Sessions.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, newAuthResponse(tokens), h.Logger)
+	h.Logger.Infof("tokens of session - %s - refreshed for userID - %s -", sess.ID, sess.UserID)
+}
+
+type RevokeResponse struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// Выход: отзываем сессию, которой подписан запрос.
+func (h *UserHandlers) Logout(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	if err := h.Sessions.Revoke(r.Context(), p.UserID, p.SessionID); err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	h.Logger.Infof("userID - %s - logged out of session - %s -", p.UserID, p.SessionID)
+}
+
+// Список устройств, с которых выполнен вход.
+func (h *UserHandlers) ListSessions(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.Sessions.List(r.Context(), p.UserID)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == p.SessionID
+	}
+
+	sendJSON(w, http.StatusOK, sessions, h.Logger)
+}
+
+// Выход на одном выбранном устройстве.
+func (h *UserHandlers) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := mux.Vars(r)["id"]
+
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	// в базе session_id - UUID, мусор туда не отправляем
+	if _, err := uuid.Parse(sessionID); err != nil {
+		h.SendErrorTo(w, session.ErrNoAuth, http.StatusNotFound, h.Logger)
+		return
+	}
+
+	if err := h.Sessions.Revoke(r.Context(), p.UserID, sessionID); err != nil {
+		if errors.Is(err, session.ErrNoAuth) {
+			h.SendErrorTo(w, err, http.StatusNotFound, h.Logger)
+			return
+		}
+
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	h.Logger.Infof("userID - %s - revoked session - %s -", p.UserID, sessionID)
+}
+
+// Выход со всех устройств, включая текущее.
+func (h *UserHandlers) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+
+	n, err := h.Sessions.RevokeAll(r.Context(), p.UserID)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, RevokeResponse{Revoked: n}, h.Logger)
+}
+
+// Принудительный выход пользователя, например при утечке токена.
+func (h *AdminHandlers) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	login := mux.Vars(r)["login"]
+
+	u, err := h.Users.GetByLogin(r.Context(), login)
+	if err != nil {
+		if errors.Is(err, user.ErrUserNotFound) {
+			h.SendErrorTo(w, err, http.StatusNotFound, h.Logger)
+			return
+		}
+
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	n, err := h.Sessions.RevokeAll(r.Context(), u.UserID)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	sendJSON(w, http.StatusOK, RevokeResponse{Revoked: n}, h.Logger)
+	h.Logger.Infof("%d sessions of user - %s - revoked by admin", n, login)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/session"
+	"proj/internal/user"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestUserHandlers_Logout(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"current session revoked": func(t *testing.T) {
+			_, mockSessionManager, handler := NewCtrlAndUserRepos(t)
+
+			mockSessionManager.EXPECT().Revoke(gomock.Any(), MockUserID, MockSessionID).Return(nil).Times(1)
+
+			w := httptest.NewRecorder()
+			handler.Logout(w, withPrincipal(httptest.NewRequest("POST", "/api/logout", nil)))
+
+			require.Equal(t, http.StatusNoContent, w.Code)
+		},
+
+		"not authenticated": func(t *testing.T) {
+			_, _, handler := NewCtrlAndUserRepos(t)
+
+			w := httptest.NewRecorder()
+			handler.Logout(w, httptest.NewRequest("POST", "/api/logout", nil))
+
+			require.Equal(t, http.StatusUnauthorized, w.Code)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestUserHandlers_ListSessions(t *testing.T) {
+	_, mockSessionManager, handler := NewCtrlAndUserRepos(t)
+
+	mockSessionManager.EXPECT().List(gomock.Any(), MockUserID).
+		Return([]session.Session{{ID: "session2", UserAgent: "phone"}, {ID: MockSessionID, UserAgent: "laptop"}}, nil).Times(1)
+
+	w := httptest.NewRecorder()
+	handler.ListSessions(w, withPrincipal(httptest.NewRequest("GET", "/api/sessions", nil)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var sessions []session.Session
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&sessions))
+	require.Len(t, sessions, 2)
+	require.False(t, sessions[0].Current)
+	require.True(t, sessions[1].Current)
+}
+
+func TestUserHandlers_DeleteSession(t *testing.T) {
+	const otherSession = "7f1b3c2e-5d4a-4b6e-9c8d-1a2b3c4d5e6f"
+
+	tests := map[string]struct {
+		id         string
+		callsRepo  bool
+		revokeErr  error
+		statusCode int
+	}{
+		"session revoked": {id: otherSession, callsRepo: true, statusCode: http.StatusNoContent},
+		"foreign session": {id: otherSession, callsRepo: true, revokeErr: session.ErrNoAuth, statusCode: http.StatusNotFound},
+		"malformed id":    {id: "not-a-uuid", statusCode: http.StatusNotFound},
+		"database error":  {id: otherSession, callsRepo: true, revokeErr: session.ErrInternalDB, statusCode: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, mockSessionManager, handler := NewCtrlAndUserRepos(t)
+
+			if tt.callsRepo {
+				mockSessionManager.EXPECT().Revoke(gomock.Any(), MockUserID, tt.id).Return(tt.revokeErr).Times(1)
+			}
+
+			req := mux.SetURLVars(withPrincipal(httptest.NewRequest("DELETE", "/api/sessions/"+tt.id, nil)),
+				map[string]string{"id": tt.id})
+			w := httptest.NewRecorder()
+
+			handler.DeleteSession(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestUserHandlers_LogoutAll(t *testing.T) {
+	_, mockSessionManager, handler := NewCtrlAndUserRepos(t)
+
+	mockSessionManager.EXPECT().RevokeAll(gomock.Any(), MockUserID).Return(int64(2), nil).Times(1)
+
+	w := httptest.NewRecorder()
+	handler.LogoutAll(w, withPrincipal(httptest.NewRequest("POST", "/api/logout-all", nil)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp RevokeResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Equal(t, int64(2), resp.Revoked)
+}
+
+func TestAdminHandlers_RevokeSessions(t *testing.T) {
+	tests := map[string]struct {
+		login      string
+		lookupErr  error
+		revokeErr  error
+		statusCode int
+	}{
+		"sessions revoked": {login: "username", statusCode: http.StatusOK},
+		"unknown user":     {login: "ghost", lookupErr: user.ErrUserNotFound, statusCode: http.StatusNotFound},
+		"database error":   {login: "username", revokeErr: errors.New("database error"), statusCode: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			mockUsers := user.NewMockUserRepo(ctrl)
+			mockSessionManager := session.NewMockSessionManagerRepo(ctrl)
+			handler := &AdminHandlers{Users: mockUsers, Sessions: mockSessionManager, Logger: zap.NewNop().Sugar()}
+
+			mockUsers.EXPECT().GetByLogin(gomock.Any(), tt.login).Return(user.User{UserID: MockUserID}, tt.lookupErr).Times(1)
+			if tt.lookupErr == nil {
+				mockSessionManager.EXPECT().RevokeAll(gomock.Any(), MockUserID).Return(int64(1), tt.revokeErr).Times(1)
+			}
+
+			req := httptest.NewRequest("DELETE", "/api/admin/users/"+tt.login+"/sessions", nil)
+			req = mux.SetURLVars(req, map[string]string{"login": tt.login})
+			w := httptest.NewRecorder()
+
+			handler.RevokeSessions(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+}
+
+func TestUserHandlers_RefreshToken(t *testing.T) {
+	tests := map[string]struct {
+		refreshErr error
+		statusCode int
+	}{
+		"tokens rotated":  {statusCode: http.StatusOK},
+		"reused token":    {refreshErr: session.ErrRefreshReused, statusCode: http.StatusUnauthorized},
+		"revoked session": {refreshErr: session.ErrRevoked, statusCode: http.StatusUnauthorized},
+		"database error":  {refreshErr: session.ErrInternalDB, statusCode: http.StatusInternalServerError},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, mockSessionManager, handler := NewCtrlAndUserRepos(t)
+
+			tokens := session.Tokens{Access: "access2", Refresh: "refresh2", ExpiresAt: time.Now().Add(15 * time.Minute)}
+			mockSessionManager.EXPECT().Refresh(gomock.Any(), "refresh1").
+				Return(&session.Session{ID: "session1", UserID: MockUserID}, tokens, tt.refreshErr).Times(1)
+
+			req := httptest.NewRequest("POST", "/api/token/refresh", bytes.NewBufferString(`{"refreshToken":"refresh1"}`))
+			w := httptest.NewRecorder()
+
+			handler.RefreshToken(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.refreshErr == nil {
+				var resp AuthResponse
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				require.Equal(t, "access2", resp.Token)
+				require.Equal(t, "refresh2", resp.RefreshToken)
+				require.InDelta(t, 900, resp.ExpiresIn, 2)
+			}
+		})
+	}
+}
@@ -3,47 +3,48 @@ package handlers
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"proj/internal/idempotency"
 	"proj/internal/session"
+	"proj/internal/types"
 	"proj/internal/user"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 )
 
 const (
-	JWTFieldUserID = "id"
-
 	UsernameMaxLen = 32
 	PasswordMaxLen = 72
 )
 
 type UserHandlers struct {
-	UserRepo user.UserRepo
-	Sessions session.SessionManagerRepo
-	Logger   *zap.SugaredLogger
+	ErrorResponder
+
+	UserRepo    user.UserRepo
+	Sessions    session.SessionManagerRepo
+	Idempotency idempotency.IdempotencyRepo
+	Logger      *zap.SugaredLogger
+
+	// Разрешить /api/auth создавать пользователя по неизвестному логину
+	LegacyAutoRegister bool
 }
 
 func (h *UserHandlers) Info(w http.ResponseWriter, r *http.Request) {
-	// Для начала получим данные о пользователе из jwt,
-	// а именно его айди
-	userID := GetUserDataByJWT(
-		w, r, JWTFieldUserID,
-		h.Sessions.GetSecret(), h.Logger,
-	)
-	if userID == "" {
-		// не отправим ошибку, тк в функции уже это предусмотрено
+	// Пользователя уже проверил middleware, берем его из контекста
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
 		return
 	}
+	userID := p.UserID
 
-	info, err := h.UserRepo.Info(userID)
+	info, err := h.UserRepo.Info(r.Context(), userID)
 	if err != nil {
-		if errors.Is(err, user.ErrUserNotFound) {
-			SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
-			return
-		}
-
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		h.sendError(w, r, err, h.Logger)
 		return
 	}
 
@@ -51,48 +52,48 @@ func (h *UserHandlers) Info(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 
 	if err := json.NewEncoder(w).Encode(info); err != nil {
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		h.SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
 		return
 	}
 
 	h.Logger.Infof("successfully received information for userID - %s -", userID)
 }
 
+// Получатель задается логином или user_id, если указаны оба -
+// используется user_id.
 type SendCoinRequest struct {
-	ToUser string `json:"toUser"`
-	Amount int    `json:"amount"`
+	ToUser   string `json:"toUser"`
+	ToUserID string `json:"toUserId,omitempty"`
+	Amount   int    `json:"amount"`
 }
 
 func (h *UserHandlers) SendCoin(w http.ResponseWriter, r *http.Request) {
 	var req SendCoinRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
 		return
 	}
 
-	userID := GetUserDataByJWT(
-		w, r, JWTFieldUserID,
-		h.Sessions.GetSecret(), h.Logger,
-	)
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+	userID := p.UserID
 
-	err := h.UserRepo.SendCoin(userID, req.ToUser, req.Amount)
+	to := user.Recipient{Login: req.ToUser, UserID: req.ToUserID}
+	err := h.UserRepo.SendCoin(r.Context(), userID, to, req.Amount)
 	if err != nil {
-		// Если ошибки связаны с отправкой несуществующему пользователю или
-		// недостаточно средств -> 400
-		if errors.Is(err, user.ErrUserNotFound) || errors.Is(err, user.ErrInsufficientFunds) {
-			SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
-			return
-		}
-
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		// Статус из каталога ошибок: несуществующий получатель
+		// или недостаточно средств -> 400
+		h.sendError(w, r, err, h.Logger)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 	h.Logger.Infof(
-		"coins sent successfully from userID - %s - to username - %s -",
+		"coins sent successfully from userID - %s - to recipient - %+v -",
 		userID,
-		req.ToUser,
+		to,
 	)
 }
 
@@ -100,21 +101,15 @@ func (h *UserHandlers) BuyItem(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	itemTitle := vars["item"]
 
-	userID := GetUserDataByJWT(
-		w, r, JWTFieldUserID,
-		h.Sessions.GetSecret(), h.Logger,
-	)
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+	userID := p.UserID
 
-	err := h.UserRepo.BuyItem(userID, itemTitle)
+	err := h.UserRepo.BuyItem(r.Context(), userID, itemTitle)
 	if err != nil {
-		if errors.Is(err, user.ErrItemNotFound) ||
-			errors.Is(err, user.ErrInsufficientFunds) ||
-			errors.Is(err, user.ErrUserNotFound) {
-			SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
-			return
-		}
-
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		h.sendError(w, r, err, h.Logger)
 		return
 	}
 
@@ -122,55 +117,237 @@ func (h *UserHandlers) BuyItem(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Infof("item - %s - purchased successfully for userID - %s -", itemTitle, userID)
 }
 
+/*
+История переводов с пагинацией по курсору. Параметры запроса:
+  - direction 			  - all | sent | received
+  - counterparty 		  - логин второй стороны
+  - minAmount, maxAmount  - диапазон суммы
+  - from, to 			  - диапазон дат в RFC3339, to не включительно
+  - limit, cursor 		  - размер страницы и курсор из nextCursor
+*/
+func (h *UserHandlers) History(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseHistoryFilter(r.URL.Query())
+	if err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	p, ok := h.principalFrom(w, r, h.Logger)
+	if !ok {
+		return
+	}
+	userID := p.UserID
+
+	page, err := h.UserRepo.History(r.Context(), userID, filter)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		h.SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		return
+	}
+
+	h.Logger.Infof("successfully received history for userID - %s -", userID)
+}
+
+func parseHistoryFilter(q url.Values) (types.HistoryFilter, error) {
+	f := types.HistoryFilter{
+		Direction:    q.Get("direction"),
+		Counterparty: q.Get("counterparty"),
+		Cursor:       q.Get("cursor"),
+	}
+
+	var err error
+	if f.MinAmount, err = parseIntParam(q, "minAmount"); err != nil {
+		return types.HistoryFilter{}, err
+	}
+	if f.MaxAmount, err = parseIntParam(q, "maxAmount"); err != nil {
+		return types.HistoryFilter{}, err
+	}
+	if f.Limit, err = parseIntParam(q, "limit"); err != nil {
+		return types.HistoryFilter{}, err
+	}
+	if f.From, err = parseTimeParam(q, "from"); err != nil {
+		return types.HistoryFilter{}, err
+	}
+	if f.To, err = parseTimeParam(q, "to"); err != nil {
+		return types.HistoryFilter{}, err
+	}
+
+	if f.MaxAmount > 0 && f.MinAmount > f.MaxAmount {
+		return types.HistoryFilter{}, user.ErrInvalidHistoryFilter
+	}
+
+	return f, nil
+}
+
+func parseIntParam(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: %s", user.ErrInvalidHistoryFilter, name)
+	}
+
+	return n, nil
+}
+
+func parseTimeParam(q url.Values, name string) (time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", user.ErrInvalidHistoryFilter, name)
+	}
+
+	return t, nil
+}
+
 type AuthRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	// Через сколько секунд истечет token
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+func newAuthResponse(t session.Tokens) AuthResponse {
+	return AuthResponse{
+		Token:        t.Access,
+		RefreshToken: t.Refresh,
+		ExpiresIn:    int64(time.Until(t.ExpiresAt).Seconds()),
+	}
 }
 
 /*
 Я подумал, при какой ситуации мы можем получать 401
 Если пароль неверный, то это же 400. Но, в целом, можем и 401.
 А так же сделаем ограничение на длину имени и пароля.
+
+Старый эндпоинт: при LegacyAutoRegister неизвестный логин
+регистрируется, иначе ведет себя как Login.
 */
 func (h *UserHandlers) Auth(w http.ResponseWriter, r *http.Request) {
 	var req AuthRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
 		return
 	}
 
-	if len(req.Username) > UsernameMaxLen || len(req.Password) > PasswordMaxLen {
-		err := errors.New("username or password has invalid size")
-		SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+	if err := validateCredentials(req.Username, req.Password); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
 		return
 	}
 
-	u, err := h.UserRepo.Authorize(req.Username, req.Password)
+	authorize := h.UserRepo.Login
+	if h.LegacyAutoRegister {
+		authorize = h.UserRepo.Authorize
+	}
+
+	u, err := authorize(r.Context(), req.Username, req.Password)
 	if err != nil {
-		if errors.Is(err, user.ErrBadPassword) {
-			SendErrorTo(w, err, http.StatusUnauthorized, h.Logger)
-			return
-		}
+		h.sendLoginError(w, r, err, h.Logger)
+		return
+	}
 
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+	h.startSession(w, r, u, http.StatusOK)
+}
+
+// Вход только для существующих пользователей.
+func (h *UserHandlers) Login(w http.ResponseWriter, r *http.Request) {
+	var req AuthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	if err := validateCredentials(req.Username, req.Password); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
 		return
 	}
 
-	sess, token, err := h.Sessions.Create(w, u.UserID, u.Login)
+	u, err := h.UserRepo.Login(r.Context(), req.Username, req.Password)
 	if err != nil {
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+		h.sendLoginError(w, r, err, h.Logger)
+		return
+	}
+
+	h.startSession(w, r, u, http.StatusOK)
+}
+
+type RegisterRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Invite   string `json:"invite"`
+}
+
+// Регистрация нового пользователя, сразу отдаем токен.
+func (h *UserHandlers) Register(w http.ResponseWriter, r *http.Request) {
+	var req RegisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	if err := validateCredentials(req.Username, req.Password); err != nil {
+		h.SendErrorTo(w, err, http.StatusBadRequest, h.Logger)
+		return
+	}
+
+	u, err := h.UserRepo.Register(r.Context(), req.Username, req.Password, req.Invite)
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
+		return
+	}
+
+	h.startSession(w, r, u, http.StatusCreated)
+}
+
+func validateCredentials(username, password string) error {
+	if username == "" || password == "" ||
+		len(username) > UsernameMaxLen || len(password) > PasswordMaxLen {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// Неизвестный логин и неверный пароль - оба 401, но с разным текстом.
+func (e ErrorResponder) sendLoginError(w http.ResponseWriter, r *http.Request, err error, logger *zap.SugaredLogger) {
+	if errors.Is(err, user.ErrBadPassword) || errors.Is(err, user.ErrUserNotFound) {
+		e.SendErrorTo(w, err, http.StatusUnauthorized, logger)
+		return
+	}
+
+	e.sendError(w, r, err, logger)
+}
+
+func (h *UserHandlers) startSession(w http.ResponseWriter, r *http.Request, u user.User, status int) {
+	sess, tokens, err := h.Sessions.Create(r.Context(), w, u.UserID, u.Login, u.Roles, session.DeviceFromRequest(r))
+	if err != nil {
+		h.sendError(w, r, err, h.Logger)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 
-	if err := json.NewEncoder(w).Encode(AuthResponse{Token: token}); err != nil {
-		SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
+	if err := json.NewEncoder(w).Encode(newAuthResponse(tokens)); err != nil {
+		h.SendErrorTo(w, err, http.StatusInternalServerError, h.Logger)
 		return
 	}
 
@@ -0,0 +1,79 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+const (
+	// Заголовок, в котором клиент присылает ключ идемпотентности.
+	HeaderKey = "Idempotency-Key"
+	// Заголовок, которым помечаем ответ, отданный из сохраненного результата.
+	HeaderReplayed = "Idempotent-Replayed"
+
+	KeyMaxLen  = 255
+	DefaultTTL = 24 * time.Hour
+	// Сколько ключ может оставаться "в процессе". Если процесс умер
+	// до сохранения результата, после этого срока ключ можно занять снова.
+	DefaultLease = time.Minute
+
+	// Пока запрос выполняется, код ответа у записи нулевой.
+	statusInProgress = 0
+	// Ответ на успешный перевод или покупку: 200 без тела.
+	statusSucceeded = http.StatusOK
+)
+
+// Сохраненный результат запроса с ключом идемпотентности.
+type Record struct {
+	Key         string
+	UserID      string
+	Fingerprint string
+	StatusCode  int
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+func (r *Record) Completed() bool {
+	return r.StatusCode != statusInProgress
+}
+
+type IdempotencyRepo interface {
+	// Reserve резервирует ключ за пользователем. Если ключ новый (или прошлый истек),
+	// вернет запись "в процессе" (Completed() == false) - запрос нужно выполнить.
+	// Если по ключу уже есть результат - вернет его для повторной отдачи.
+	Reserve(ctx context.Context, userID, key, fingerprint string) (*Record, error)
+	// Complete и Release меняют только свою резервацию: если ключ за это время
+	// заняли снова после истечения аренды, чужую запись они не трогают.
+	Complete(ctx context.Context, rec *Record, statusCode int, body []byte) error
+	Release(ctx context.Context, rec *Record) error
+	PurgeExpired(ctx context.Context) (int64, error)
+}
+
+type reservationKey struct{}
+
+// Резервация ключа в контексте запроса. Репозиторий, который двигает
+// деньги, сохраняет по ней результат в своей транзакции (CompleteTx).
+func WithReservation(ctx context.Context, rec *Record) context.Context {
+	return context.WithValue(ctx, reservationKey{}, rec)
+}
+
+func reservationFrom(ctx context.Context) *Record {
+	rec, _ := ctx.Value(reservationKey{}).(*Record)
+	return rec
+}
+
+// Fingerprint - отпечаток запроса, по которому сверяем повторы с тем же ключом.
+func Fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,93 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: idempotency.go
+
+// Package idempotency is a generated GoMock package.
+package idempotency
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockIdempotencyRepo is a mock of IdempotencyRepo interface.
+type MockIdempotencyRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockIdempotencyRepoMockRecorder
+}
+
+// MockIdempotencyRepoMockRecorder is the mock recorder for MockIdempotencyRepo.
+type MockIdempotencyRepoMockRecorder struct {
+	mock *MockIdempotencyRepo
+}
+
+// NewMockIdempotencyRepo creates a new mock instance.
+func NewMockIdempotencyRepo(ctrl *gomock.Controller) *MockIdempotencyRepo {
+	mock := &MockIdempotencyRepo{ctrl: ctrl}
+	mock.recorder = &MockIdempotencyRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIdempotencyRepo) EXPECT() *MockIdempotencyRepoMockRecorder {
+	return m.recorder
+}
+
+// Complete mocks base method.
+func (m *MockIdempotencyRepo) Complete(ctx context.Context, rec *Record, statusCode int, body []byte) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Complete", ctx, rec, statusCode, body)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Complete indicates an expected call of Complete.
+func (mr *MockIdempotencyRepoMockRecorder) Complete(ctx, rec, statusCode, body interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Complete", reflect.TypeOf((*MockIdempotencyRepo)(nil).Complete), ctx, rec, statusCode, body)
+}
+
+// PurgeExpired mocks base method.
+func (m *MockIdempotencyRepo) PurgeExpired(ctx context.Context) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PurgeExpired", ctx)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PurgeExpired indicates an expected call of PurgeExpired.
+func (mr *MockIdempotencyRepoMockRecorder) PurgeExpired(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeExpired", reflect.TypeOf((*MockIdempotencyRepo)(nil).PurgeExpired), ctx)
+}
+
+// Release mocks base method.
+func (m *MockIdempotencyRepo) Release(ctx context.Context, rec *Record) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Release", ctx, rec)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Release indicates an expected call of Release.
+func (mr *MockIdempotencyRepoMockRecorder) Release(ctx, rec interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Release", reflect.TypeOf((*MockIdempotencyRepo)(nil).Release), ctx, rec)
+}
+
+// Reserve mocks base method.
+func (m *MockIdempotencyRepo) Reserve(ctx context.Context, userID, key, fingerprint string) (*Record, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reserve", ctx, userID, key, fingerprint)
+	ret0, _ := ret[0].(*Record)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reserve indicates an expected call of Reserve.
+func (mr *MockIdempotencyRepoMockRecorder) Reserve(ctx, userID, key, fingerprint interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reserve", reflect.TypeOf((*MockIdempotencyRepo)(nil).Reserve), ctx, userID, key, fingerprint)
+}
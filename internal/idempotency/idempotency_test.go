@@ -0,0 +1,211 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestDBRepository(t *testing.T) (*IdempotencyDBRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewIdempotencyDBRepository(db, zap.NewNop().Sugar(), time.Hour, time.Minute), mock
+}
+
+func TestIdempotencyDBRepository_Reserve(t *testing.T) {
+	const (
+		insertQuery = `INSERT INTO idempotency_keys \(user_id, idem_key, fingerprint, status_code, created_at, expires_at\)`
+		selectQuery = `SELECT fingerprint, status_code, response, created_at, expires_at FROM idempotency_keys WHERE user_id = \$1 AND idem_key = \$2`
+	)
+	cols := []string{"fingerprint", "status_code", "response", "created_at", "expires_at"}
+
+	tests := []struct {
+		name              string
+		fingerprint       string
+		mockDBSetup       func(sqlmock.Sqlmock)
+		expectedCompleted bool
+		expectedError     error
+	}{
+		{
+			name:        "NewKey",
+			fingerprint: "fp1",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insertQuery).
+					WithArgs("user1", "key1", "fp1", statusInProgress, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expectedCompleted: false,
+			expectedError:     nil,
+		},
+		{
+			name:        "Replay",
+			fingerprint: "fp1",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insertQuery).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(selectQuery).
+					WithArgs("user1", "key1").
+					WillReturnRows(sqlmock.NewRows(cols).
+						AddRow("fp1", 200, []byte{}, time.Now(), time.Now().Add(time.Hour)))
+			},
+			expectedCompleted: true,
+			expectedError:     nil,
+		},
+		{
+			name:        "DifferentPayload",
+			fingerprint: "fp2",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insertQuery).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(selectQuery).
+					WithArgs("user1", "key1").
+					WillReturnRows(sqlmock.NewRows(cols).
+						AddRow("fp1", 200, []byte{}, time.Now(), time.Now().Add(time.Hour)))
+			},
+			expectedError: ErrKeyReused,
+		},
+		{
+			name:        "InProgress",
+			fingerprint: "fp1",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insertQuery).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(selectQuery).
+					WithArgs("user1", "key1").
+					WillReturnRows(sqlmock.NewRows(cols).
+						AddRow("fp1", statusInProgress, nil, time.Now(), time.Now().Add(time.Hour)))
+			},
+			expectedError: ErrRequestInProgress,
+		},
+		{
+			name:        "DatabaseError",
+			fingerprint: "fp1",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(insertQuery).
+					WillReturnError(errors.New("database error"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
+
+			rec, err := repo.Reserve(context.Background(), "user1", "key1", tt.fingerprint)
+
+			assert.Equal(t, tt.expectedError, err)
+			if err == nil {
+				assert.Equal(t, tt.expectedCompleted, rec.Completed())
+			} else {
+				assert.Nil(t, rec)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestIdempotencyDBRepository_Complete(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+	rec := &Record{UserID: "user1", Key: "key1", CreatedAt: time.Now()}
+
+	mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \$4, response = \$5 WHERE user_id = \$1 AND idem_key = \$2 AND created_at = \$3 AND status_code = \$6`).
+		WithArgs("user1", "key1", rec.CreatedAt, 200, []byte(`{}`), statusInProgress).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.Complete(context.Background(), rec, 200, []byte(`{}`)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIdempotencyDBRepository_Release(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+	rec := &Record{UserID: "user1", Key: "key1", CreatedAt: time.Now()}
+
+	mock.ExpectExec(`DELETE FROM idempotency_keys WHERE user_id = \$1 AND idem_key = \$2 AND created_at = \$3 AND status_code = \$4`).
+		WithArgs("user1", "key1", rec.CreatedAt, statusInProgress).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.Release(context.Background(), rec))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCompleteTx(t *testing.T) {
+	const updateQuery = `UPDATE idempotency_keys SET status_code = \$4, response = NULL WHERE user_id = \$1 AND idem_key = \$2 AND created_at = \$3 AND status_code = \$5`
+	rec := &Record{UserID: "user1", Key: "key1", CreatedAt: time.Now()}
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name:        "NoReservation",
+			ctx:         context.Background(),
+			mockDBSetup: func(mock sqlmock.Sqlmock) {},
+		},
+		{
+			name: "Completed",
+			ctx:  WithReservation(context.Background(), rec),
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(updateQuery).
+					WithArgs("user1", "key1", rec.CreatedAt, statusSucceeded, statusInProgress).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			// аренда истекла, ключ занял повтор: деньги двигать нельзя
+			name: "LeaseLost",
+			ctx:  WithReservation(context.Background(), rec),
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(updateQuery).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			expectedError: ErrRequestInProgress,
+		},
+		{
+			name: "DatabaseError",
+			ctx:  WithReservation(context.Background(), rec),
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(updateQuery).
+					WillReturnError(errors.New("database error"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("Failed to create mock DB: %v", err)
+			}
+			mock.ExpectBegin()
+			tt.mockDBSetup(mock)
+
+			tx, err := db.Begin()
+			assert.NoError(t, err)
+
+			err = CompleteTx(tt.ctx, tx, zap.NewNop().Sugar())
+			assert.ErrorIs(t, err, tt.expectedError)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := Fingerprint("POST", "/api/sendCoin", []byte(`{"toUser":"a","amount":1}`))
+	b := Fingerprint("POST", "/api/sendCoin", []byte(`{"toUser":"a","amount":2}`))
+
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a, Fingerprint("POST", "/api/sendCoin", []byte(`{"toUser":"a","amount":1}`)))
+}
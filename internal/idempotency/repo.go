@@ -0,0 +1,254 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"proj/internal/dbtx"
+	"proj/internal/tracing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInternalDB        = errors.New("database internal error")
+	ErrKeyReused         = errors.New("idempotency key reused with different payload")
+	ErrRequestInProgress = errors.New("request with this idempotency key is still in progress")
+)
+
+type IdempotencyDBRepository struct {
+	DB     *sql.DB
+	Logger *zap.SugaredLogger
+	TTL    time.Duration
+	Lease  time.Duration
+}
+
+func NewIdempotencyDBRepository(db *sql.DB, l *zap.SugaredLogger, ttl, lease time.Duration) *IdempotencyDBRepository {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+
+	return &IdempotencyDBRepository{
+		DB:     db,
+		Logger: l,
+		TTL:    ttl,
+		Lease:  lease,
+	}
+}
+
+/*
+Резервирование ключа делаем одним запросом, чтобы два параллельных
+повтора не прошли оба:
+  - ключа нет 		 -> вставляем запись "в процессе"
+  - ключ истек 		 -> перезаписываем его как новый
+  - ключ живой 		 -> ничего не меняем и читаем, что там лежит
+
+Запись "в процессе" старше аренды (Lease) тоже перезаписывается: процесс,
+занявший ключ, умер или завис. Деньги он не двигал - иначе результат
+был бы сохранен в той же транзакции (CompleteTx).
+*/
+func (ir *IdempotencyDBRepository) Reserve(ctx context.Context, userID, key, fingerprint string) (*Record, error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Reserve")
+	defer span.End()
+
+	rec, err := ir.reserve(ctx, userID, key, fingerprint)
+	tracing.Fail(span, err)
+	return rec, err
+}
+
+func (ir *IdempotencyDBRepository) reserve(ctx context.Context, userID, key, fingerprint string) (*Record, error) {
+	// created_at отличает эту резервацию от следующих под тем же ключом,
+	// postgres хранит микросекунды
+	now := time.Now().Truncate(time.Microsecond)
+	rec := &Record{
+		Key:         key,
+		UserID:      userID,
+		Fingerprint: fingerprint,
+		StatusCode:  statusInProgress,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ir.TTL),
+	}
+
+	q := `
+	INSERT INTO idempotency_keys (user_id, idem_key, fingerprint, status_code, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id, idem_key) DO UPDATE
+	SET fingerprint = EXCLUDED.fingerprint,
+		status_code = EXCLUDED.status_code,
+		response = NULL,
+		created_at = EXCLUDED.created_at,
+		expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at < EXCLUDED.created_at
+		OR (idempotency_keys.status_code = EXCLUDED.status_code AND idempotency_keys.created_at < $7)
+	`
+	res, err := ir.DB.ExecContext(ctx, q, userID, key, fingerprint, statusInProgress,
+		rec.CreatedAt, rec.ExpiresAt, now.Add(-ir.Lease))
+	if err != nil {
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	// Ключ наш - запрос нужно выполнить
+	if inserted > 0 {
+		return rec, nil
+	}
+
+	// Ключ уже занят, посмотрим чем
+	rec = &Record{Key: key, UserID: userID}
+	q = `
+	SELECT fingerprint, status_code, response, created_at, expires_at
+	FROM idempotency_keys
+	WHERE user_id = $1 AND idem_key = $2
+	`
+	err = ir.DB.QueryRowContext(ctx, q, userID, key).Scan(
+		&rec.Fingerprint, &rec.StatusCode, &rec.Body, &rec.CreatedAt, &rec.ExpiresAt,
+	)
+	if err != nil {
+		// Запись успели удалить между запросами - пусть клиент повторит
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRequestInProgress
+		}
+
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	if rec.Fingerprint != fingerprint {
+		ir.Logger.Errorf("%v. More details: user_id - %s - key - %s -", ErrKeyReused, userID, key)
+		return nil, ErrKeyReused
+	}
+
+	if !rec.Completed() {
+		return nil, ErrRequestInProgress
+	}
+
+	return rec, nil
+}
+
+// Сохраняем результат выполненного запроса.
+func (ir *IdempotencyDBRepository) Complete(ctx context.Context, rec *Record, statusCode int, body []byte) error {
+	ctx, span := tracing.Start(ctx, "idempotency.Complete")
+	defer span.End()
+
+	err := ir.complete(ctx, rec, statusCode, body)
+	tracing.Fail(span, err)
+	return err
+}
+
+// Результат, сохраненный раньше в CompleteTx, не перезаписывается.
+func (ir *IdempotencyDBRepository) complete(ctx context.Context, rec *Record, statusCode int, body []byte) error {
+	q := `
+	UPDATE idempotency_keys
+	SET status_code = $4, response = $5
+	WHERE user_id = $1 AND idem_key = $2 AND created_at = $3 AND status_code = $6
+	`
+	_, err := ir.DB.ExecContext(ctx, q, rec.UserID, rec.Key, rec.CreatedAt, statusCode, body, statusInProgress)
+	if err != nil {
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	return nil
+}
+
+/*
+Результат успешного перевода или покупки сохраняется в транзакции,
+которая двигает деньги: иначе после списания и до Complete процесс
+может упасть, и повтор по истечении аренды спишет деньги второй раз.
+Без резервации в контексте ничего не делает.
+
+Если резервацию за это время перезаняли (аренда истекла), возвращает
+ErrRequestInProgress, и вызывающий откатывает транзакцию.
+*/
+func CompleteTx(ctx context.Context, tx *sql.Tx, l *zap.SugaredLogger) error {
+	rec := reservationFrom(ctx)
+	if rec == nil {
+		return nil
+	}
+
+	q := `
+	UPDATE idempotency_keys
+	SET status_code = $4, response = NULL
+	WHERE user_id = $1 AND idem_key = $2 AND created_at = $3 AND status_code = $5
+	`
+	res, err := tx.ExecContext(ctx, q, rec.UserID, rec.Key, rec.CreatedAt, statusSucceeded, statusInProgress)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+	if n == 0 {
+		l.Infof("%v. More details: lease lost, user_id - %s - key - %s -", ErrRequestInProgress, rec.UserID, rec.Key)
+		return ErrRequestInProgress
+	}
+
+	return nil
+}
+
+// Освобождаем ключ, если запрос упал и его можно безопасно повторить.
+func (ir *IdempotencyDBRepository) Release(ctx context.Context, rec *Record) error {
+	ctx, span := tracing.Start(ctx, "idempotency.Release")
+	defer span.End()
+
+	err := ir.release(ctx, rec)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (ir *IdempotencyDBRepository) release(ctx context.Context, rec *Record) error {
+	q := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND idem_key = $2 AND created_at = $3 AND status_code = $4
+	`
+	_, err := ir.DB.ExecContext(ctx, q, rec.UserID, rec.Key, rec.CreatedAt, statusInProgress)
+	if err != nil {
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	return nil
+}
+
+// Удаляем истекшие ключи, чтобы таблица не разрасталась.
+func (ir *IdempotencyDBRepository) PurgeExpired(ctx context.Context) (int64, error) {
+	ctx, span := tracing.Start(ctx, "idempotency.PurgeExpired")
+	defer span.End()
+
+	n, err := ir.purgeExpired(ctx)
+	tracing.Fail(span, err)
+	return n, err
+}
+
+func (ir *IdempotencyDBRepository) purgeExpired(ctx context.Context) (int64, error) {
+	q := `
+	DELETE FROM idempotency_keys
+	WHERE expires_at < $1
+	`
+	res, err := ir.DB.ExecContext(ctx, q, time.Now())
+	if err != nil {
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		ir.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+
+	return n, nil
+}
@@ -0,0 +1,59 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// Публичный ключ в формате JWK (RFC 7517).
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Alg     string `json:"alg"`
+	Use     string `json:"use"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// OKP (Ed25519, RFC 8037)
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Публичные части всех ключей, которые сейчас принимаются при проверке.
+func (kr *Keyring) JWKS() JWKSet {
+	keys := kr.Keys()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(keys))}
+	for _, k := range keys {
+		jwk := JWK{KeyID: k.ID, Alg: k.Alg, Use: "sig"}
+
+		switch pub := k.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = b64(pub.N.Bytes())
+			jwk.E = b64(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = b64(pub)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,191 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	rsaKeyBits = 2048
+
+	HeaderKeyID = "kid"
+)
+
+var (
+	ErrUnknownAlg       = errors.New("unsupported signing algorithm")
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrNoSigningKey     = errors.New("no active signing key")
+	ErrUnexpectedMethod = errors.New("unexpected signing method")
+)
+
+/*
+Ключ подписи. Жизненный цикл:
+  - с CreatedAt ключ опубликован в JWKS и принимается при проверке;
+  - с ActiveFrom им подписываются новые токены;
+  - с RetiredAt подпись переходит к следующему ключу;
+  - до ExpiresAt (grace period) ключ еще проверяет выданные им токены.
+*/
+type Key struct {
+	ID         string
+	Alg        string
+	Private    crypto.Signer
+	CreatedAt  time.Time
+	ActiveFrom time.Time
+	RetiredAt  *time.Time
+	ExpiresAt  *time.Time
+}
+
+func ValidAlg(alg string) bool {
+	return alg == AlgRS256 || alg == AlgEdDSA
+}
+
+// Новый ключ с подписью сразу с момента создания.
+func Generate(alg string) (*Key, error) {
+	var (
+		priv crypto.Signer
+		err  error
+	)
+
+	switch alg {
+	case AlgRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, ErrUnknownAlg
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	return &Key{
+		ID:         uuid.New().String(),
+		Alg:        alg,
+		Private:    priv,
+		CreatedAt:  now,
+		ActiveFrom: now,
+	}, nil
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+func (k *Key) signsAt(t time.Time) bool {
+	return !t.Before(k.ActiveFrom) && (k.RetiredAt == nil || t.Before(*k.RetiredAt))
+}
+
+func (k *Key) verifiesAt(t time.Time) bool {
+	return k.ExpiresAt == nil || t.Before(*k.ExpiresAt)
+}
+
+// Набор ключей, которыми подписываем и проверяем токены.
+// Безопасен для конкурентного использования.
+type Keyring struct {
+	mu   sync.RWMutex
+	keys map[string]*Key
+}
+
+func New(keys ...*Key) *Keyring {
+	kr := &Keyring{}
+	kr.Replace(keys)
+	return kr
+}
+
+// Полная замена набора, например после синхронизации с базой.
+func (kr *Keyring) Replace(keys []*Key) {
+	m := make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		m[k.ID] = k
+	}
+
+	kr.mu.Lock()
+	kr.keys = m
+	kr.mu.Unlock()
+}
+
+// Самый свежий ключ, которому сейчас разрешено подписывать.
+func (kr *Keyring) Signing() (*Key, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+	var cur *Key
+	for _, k := range kr.keys {
+		if k.signsAt(now) && (cur == nil || k.ActiveFrom.After(cur.ActiveFrom)) {
+			cur = k
+		}
+	}
+	if cur == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	return cur, nil
+}
+
+func (kr *Keyring) Sign(claims jwt.Claims) (string, error) {
+	k, err := kr.Signing()
+	if err != nil {
+		return "", err
+	}
+
+	t := jwt.NewWithClaims(k.method(), claims)
+	t.Header[HeaderKeyID] = k.ID
+
+	return t.SignedString(k.Private)
+}
+
+// Keyfunc для jwt.Parse: ключ ищем по kid, алгоритм токена
+// обязан совпадать с алгоритмом ключа.
+func (kr *Keyring) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header[HeaderKeyID].(string)
+
+	kr.mu.RLock()
+	k, ok := kr.keys[kid]
+	kr.mu.RUnlock()
+
+	if !ok || !k.verifiesAt(time.Now()) {
+		return nil, ErrUnknownKey
+	}
+	if t.Method.Alg() != k.Alg {
+		return nil, ErrUnexpectedMethod
+	}
+
+	return k.Public(), nil
+}
+
+// Все ключи, которые сейчас принимаются при проверке, старые первыми.
+func (kr *Keyring) Keys() []*Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]*Key, 0, len(kr.keys))
+	for _, k := range kr.keys {
+		if k.verifiesAt(now) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys
+}
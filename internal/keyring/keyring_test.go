@@ -0,0 +1,97 @@
+package keyring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustGenerate(t *testing.T, alg string) *Key {
+	k, err := Generate(alg)
+	require.NoError(t, err)
+	return k
+}
+
+func TestKeyring_SignAndVerify(t *testing.T) {
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			k := mustGenerate(t, alg)
+			kr := New(k)
+
+			token, err := kr.Sign(jwt.MapClaims{"sub": "user1"})
+			require.NoError(t, err)
+
+			parsed, err := jwt.Parse(token, kr.Keyfunc)
+			require.NoError(t, err)
+			assert.True(t, parsed.Valid)
+			assert.Equal(t, k.ID, parsed.Header[HeaderKeyID])
+		})
+	}
+}
+
+func TestKeyring_Keyfunc(t *testing.T) {
+	old := mustGenerate(t, AlgEdDSA)
+	kr := New(old)
+
+	oldToken, err := kr.Sign(jwt.MapClaims{"sub": "user1"})
+	require.NoError(t, err)
+
+	// ротация: старый ключ в отставке, но в пределах grace period
+	retired, expires := time.Now(), time.Now().Add(time.Hour)
+	old.RetiredAt, old.ExpiresAt = &retired, &expires
+	cur := mustGenerate(t, AlgEdDSA)
+	kr.Replace([]*Key{old, cur})
+
+	signing, err := kr.Signing()
+	require.NoError(t, err)
+	assert.Equal(t, cur.ID, signing.ID)
+
+	_, err = jwt.Parse(oldToken, kr.Keyfunc)
+	assert.NoError(t, err)
+
+	// grace period истек - старые токены больше не принимаются
+	expired := time.Now().Add(-time.Second)
+	old.ExpiresAt = &expired
+	_, err = jwt.Parse(oldToken, kr.Keyfunc)
+	assert.Error(t, err)
+
+	// токен без kid или с чужим kid
+	hs := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "user1"})
+	hsToken, _ := hs.SignedString([]byte("secret"))
+	_, err = jwt.Parse(hsToken, kr.Keyfunc)
+	assert.Error(t, err)
+
+	// алгоритм токена обязан совпадать с алгоритмом ключа
+	hs.Header[HeaderKeyID] = cur.ID
+	_, err = kr.Keyfunc(&jwt.Token{Header: hs.Header, Method: jwt.SigningMethodHS256})
+	assert.Equal(t, ErrUnexpectedMethod, err)
+}
+
+func TestKeyring_NoSigningKey(t *testing.T) {
+	k := mustGenerate(t, AlgEdDSA)
+	k.ActiveFrom = time.Now().Add(time.Minute)
+
+	_, err := New(k).Sign(jwt.MapClaims{})
+	assert.Equal(t, ErrNoSigningKey, err)
+}
+
+func TestKeyring_JWKS(t *testing.T) {
+	rsaKey := mustGenerate(t, AlgRS256)
+	edKey := mustGenerate(t, AlgEdDSA)
+	edKey.CreatedAt = rsaKey.CreatedAt.Add(time.Second)
+
+	set := New(rsaKey, edKey).JWKS()
+	require.Len(t, set.Keys, 2)
+
+	assert.Equal(t, "RSA", set.Keys[0].KeyType)
+	assert.Equal(t, rsaKey.ID, set.Keys[0].KeyID)
+	assert.Equal(t, "AQAB", set.Keys[0].E)
+	assert.NotEmpty(t, set.Keys[0].N)
+
+	assert.Equal(t, "OKP", set.Keys[1].KeyType)
+	assert.Equal(t, "Ed25519", set.Keys[1].Curve)
+	assert.Len(t, set.Keys[1].X, 43)
+}
@@ -0,0 +1,369 @@
+package keyring
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"io"
+	"proj/internal/tracing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Ключ для pg_advisory_xact_lock, чтобы реплики не ротировали одновременно.
+	rotationLockID = 720_011
+
+	DefaultRotateEvery = 30 * 24 * time.Hour
+	DefaultGracePeriod = 24 * time.Hour
+	DefaultSyncEvery   = time.Minute
+)
+
+var (
+	ErrInternalDB     = errors.New("database internal error")
+	ErrCorruptedKey   = errors.New("stored signing key cannot be decrypted")
+	ErrInvalidOptions = errors.New("invalid keyring options")
+)
+
+type Options struct {
+	// Алгоритм новых ключей
+	Alg string
+	// Как часто выпускаем новый ключ
+	RotateEvery time.Duration
+	// Сколько старый ключ еще проверяет токены после ротации,
+	// должно быть не меньше времени жизни access-токена
+	GracePeriod time.Duration
+	// Как часто реплики перечитывают ключи из базы. Новый ключ
+	// начинает подписывать через две синхронизации, чтобы все
+	// реплики успели его увидеть.
+	SyncEvery time.Duration
+	// Прежний secret после его смены: ключи, зашифрованные им,
+	// расшифровываются и перешифровываются текущим при Sync
+	PreviousSecret string
+}
+
+func (o Options) withDefaults() Options {
+	if o.RotateEvery <= 0 {
+		o.RotateEvery = DefaultRotateEvery
+	}
+	if o.GracePeriod <= 0 {
+		o.GracePeriod = DefaultGracePeriod
+	}
+	if o.SyncEvery <= 0 {
+		o.SyncEvery = DefaultSyncEvery
+	}
+	return o
+}
+
+/*
+Ключи живут в таблице signing_keys, чтобы все реплики и перезапуски
+подписывали одним набором. Приватные ключи хранятся зашифрованными
+AES-GCM, ключ шифрования выводится из secret конфига.
+*/
+type KeyDBRepository struct {
+	DB      *sql.DB
+	Logger  *zap.SugaredLogger
+	Options Options
+
+	aead cipher.AEAD
+	// по Options.PreviousSecret, nil - если не задан
+	previous cipher.AEAD
+}
+
+func NewKeyDBRepository(db *sql.DB, l *zap.SugaredLogger, secret string, opts Options) (*KeyDBRepository, error) {
+	opts = opts.withDefaults()
+	if !ValidAlg(opts.Alg) {
+		return nil, ErrUnknownAlg
+	}
+	if secret == "" {
+		return nil, ErrInvalidOptions
+	}
+
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	var previous cipher.AEAD
+	if opts.PreviousSecret != "" {
+		if previous, err = newAEAD(opts.PreviousSecret); err != nil {
+			return nil, err
+		}
+	}
+
+	return &KeyDBRepository{
+		DB:       db,
+		Logger:   l,
+		Options:  opts,
+		aead:     aead,
+		previous: previous,
+	}, nil
+}
+
+func newAEAD(secret string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+Синхронизация keyring с базой: под advisory-локом читаем живые ключи,
+при необходимости выпускаем новый и отправляем прежний в отставку,
+чистим истекшие. Вызывается на старте и затем раз в SyncEvery.
+*/
+func (kr *KeyDBRepository) Sync(ring *Keyring) error {
+	// синхронизация идет в фоне, вне запросов: у нее своя трасса
+	ctx, span := tracing.Start(context.Background(), "keyring.Sync")
+	defer span.End()
+
+	err := kr.sync(ctx, ring)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (kr *KeyDBRepository) sync(ctx context.Context, ring *Keyring) error {
+	tx, err := kr.DB.BeginTx(ctx, nil)
+	if err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, rotationLockID); err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM signing_keys WHERE expires_at < NOW()`); err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	keys, stale, err := kr.load(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := kr.reseal(ctx, tx, stale); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if kr.needsRotation(keys, now) {
+		k, err := kr.rotate(ctx, tx, keys, now)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+
+	if err := tx.Commit(); err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	ring.Replace(keys)
+	return nil
+}
+
+// Живые ключи; вторым списком - те из них, что открылись только прежним secret.
+func (kr *KeyDBRepository) load(ctx context.Context, tx *sql.Tx) ([]*Key, []*Key, error) {
+	q := `
+	SELECT kid, alg, private_key, created_at, active_from, retired_at, expires_at
+	FROM signing_keys
+	ORDER BY created_at
+	`
+	rows, err := tx.QueryContext(ctx, q)
+	if err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	keys := make([]*Key, 0)
+	var stale []*Key
+	for rows.Next() {
+		var (
+			k      Key
+			sealed []byte
+		)
+		err = rows.Scan(&k.ID, &k.Alg, &sealed, &k.CreatedAt, &k.ActiveFrom, &k.RetiredAt, &k.ExpiresAt)
+		if err != nil {
+			kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, nil, ErrInternalDB
+		}
+
+		k.Private, err = open(kr.aead, sealed)
+		if err != nil && kr.previous != nil {
+			if k.Private, err = open(kr.previous, sealed); err == nil {
+				stale = append(stale, &k)
+			}
+		}
+		if err != nil {
+			kr.Logger.Errorf("%v. More details: kid - %s -: %v", ErrCorruptedKey, k.ID, err)
+			return nil, nil, ErrCorruptedKey
+		}
+
+		keys = append(keys, &k)
+	}
+
+	if err = rows.Err(); err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, nil, ErrInternalDB
+	}
+
+	return keys, stale, nil
+}
+
+// Перешифровывает текущим secret ключи, открытые прежним. Идет в той же
+// транзакции под advisory-локом, после коммита прежний secret не нужен.
+func (kr *KeyDBRepository) reseal(ctx context.Context, tx *sql.Tx, keys []*Key) error {
+	q := `
+	UPDATE signing_keys
+	SET private_key = $1
+	WHERE kid = $2
+	`
+	for _, k := range keys {
+		sealed, err := kr.seal(k.Private)
+		if err != nil {
+			kr.Logger.Errorf("%v. More details: %v", ErrCorruptedKey, err)
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, q, sealed, k.ID); err != nil {
+			kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+		kr.Logger.Infof("signing key - %s - resealed with the current secret", k.ID)
+	}
+	return nil
+}
+
+// Нужен новый ключ, если нет действующего, он старше RotateEvery
+// или в конфиге сменили алгоритм.
+func (kr *KeyDBRepository) needsRotation(keys []*Key, now time.Time) bool {
+	var newest *Key
+	for _, k := range keys {
+		if k.RetiredAt == nil {
+			newest = k
+		}
+	}
+
+	return newest == nil ||
+		newest.Alg != kr.Options.Alg ||
+		now.Sub(newest.CreatedAt) >= kr.Options.RotateEvery
+}
+
+func (kr *KeyDBRepository) rotate(ctx context.Context, tx *sql.Tx, keys []*Key, now time.Time) (*Key, error) {
+	k, err := Generate(kr.Options.Alg)
+	if err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrUnknownAlg, err)
+		return nil, err
+	}
+
+	// Пока новый ключ не дошел до всех реплик, подписывает старый.
+	// Если подписывать сейчас нечем - новый ключ активен сразу.
+	k.CreatedAt = now
+	k.ActiveFrom = now
+	for _, old := range keys {
+		if old.signsAt(now) {
+			k.ActiveFrom = now.Add(2 * kr.Options.SyncEvery)
+			break
+		}
+	}
+
+	sealed, err := kr.seal(k.Private)
+	if err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrCorruptedKey, err)
+		return nil, err
+	}
+
+	q := `
+	INSERT INTO signing_keys (kid, alg, private_key, created_at, active_from)
+	VALUES ($1, $2, $3, $4, $5)
+	`
+	if _, err := tx.ExecContext(ctx, q, k.ID, k.Alg, sealed, k.CreatedAt, k.ActiveFrom); err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	retiredAt := k.ActiveFrom
+	expiresAt := retiredAt.Add(kr.Options.GracePeriod)
+	q = `
+	UPDATE signing_keys
+	SET retired_at = $1, expires_at = $2
+	WHERE retired_at IS NULL AND kid <> $3
+	`
+	if _, err := tx.ExecContext(ctx, q, retiredAt, expiresAt, k.ID); err != nil {
+		kr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	for _, old := range keys {
+		if old.RetiredAt == nil {
+			old.RetiredAt, old.ExpiresAt = &retiredAt, &expiresAt
+		}
+	}
+
+	kr.Logger.Infof("signing key - %s - (%s) created, active from %s", k.ID, k.Alg, k.ActiveFrom)
+	return k, nil
+}
+
+func (kr *KeyDBRepository) seal(priv crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, kr.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return kr.aead.Seal(nonce, nonce, der, nil), nil
+}
+
+func (kr *KeyDBRepository) open(sealed []byte) (crypto.Signer, error) {
+	return open(kr.aead, sealed)
+}
+
+func open(aead cipher.AEAD, sealed []byte) (crypto.Signer, error) {
+	ns := aead.NonceSize()
+	if len(sealed) < ns {
+		return nil, ErrCorruptedKey
+	}
+
+	der, err := aead.Open(nil, sealed[:ns], sealed[ns:], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnknownAlg
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,212 @@
+package keyring
+
+import (
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var keyCols = []string{"kid", "alg", "private_key", "created_at", "active_from", "retired_at", "expires_at"}
+
+const selectKeys = `SELECT kid, alg, private_key, created_at, active_from, retired_at, expires_at FROM signing_keys ORDER BY created_at`
+
+func newTestKeyDBRepository(t *testing.T) (*KeyDBRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	kr, err := NewKeyDBRepository(db, zap.NewNop().Sugar(), "test-secret", Options{Alg: AlgEdDSA})
+	require.NoError(t, err)
+
+	return kr, mock
+}
+
+// Запоминает аргумент запроса, чтобы проверить его после вызова.
+type capturedArg struct{ dst *[]byte }
+
+func capture(dst *[]byte) capturedArg { return capturedArg{dst: dst} }
+
+func (c capturedArg) Match(v driver.Value) bool {
+	b, ok := v.([]byte)
+	*c.dst = b
+	return ok
+}
+
+func expectSyncStart(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+		WithArgs(rotationLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM signing_keys WHERE expires_at < NOW\(\)`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestKeyDBRepository_Sync(t *testing.T) {
+	t.Run("FirstKey", func(t *testing.T) {
+		repo, mock := newTestKeyDBRepository(t)
+
+		expectSyncStart(mock)
+		mock.ExpectQuery(selectKeys).WillReturnRows(sqlmock.NewRows(keyCols))
+		mock.ExpectExec(`INSERT INTO signing_keys \(kid, alg, private_key, created_at, active_from\) VALUES \(\$1, \$2, \$3, \$4, \$5\)`).
+			WithArgs(sqlmock.AnyArg(), AlgEdDSA, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE signing_keys SET retired_at = \$1, expires_at = \$2 WHERE retired_at IS NULL AND kid <> \$3`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+
+		ring := New()
+		require.NoError(t, repo.Sync(ring))
+
+		// подписывать нечем, поэтому первый ключ активен сразу
+		_, err := ring.Signing()
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("FreshKeyIsKept", func(t *testing.T) {
+		repo, mock := newTestKeyDBRepository(t)
+
+		k := mustGenerate(t, AlgEdDSA)
+		sealed, err := repo.seal(k.Private)
+		require.NoError(t, err)
+
+		expectSyncStart(mock)
+		mock.ExpectQuery(selectKeys).WillReturnRows(sqlmock.NewRows(keyCols).
+			AddRow(k.ID, AlgEdDSA, sealed, k.CreatedAt, k.ActiveFrom, nil, nil))
+		mock.ExpectCommit()
+
+		ring := New()
+		require.NoError(t, repo.Sync(ring))
+
+		signing, err := ring.Signing()
+		require.NoError(t, err)
+		assert.Equal(t, k.ID, signing.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("RotateOldKey", func(t *testing.T) {
+		repo, mock := newTestKeyDBRepository(t)
+
+		k := mustGenerate(t, AlgEdDSA)
+		k.CreatedAt = time.Now().Add(-DefaultRotateEvery - time.Hour)
+		k.ActiveFrom = k.CreatedAt
+		sealed, err := repo.seal(k.Private)
+		require.NoError(t, err)
+
+		expectSyncStart(mock)
+		mock.ExpectQuery(selectKeys).WillReturnRows(sqlmock.NewRows(keyCols).
+			AddRow(k.ID, AlgEdDSA, sealed, k.CreatedAt, k.ActiveFrom, nil, nil))
+		mock.ExpectExec(`INSERT INTO signing_keys`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`UPDATE signing_keys SET retired_at = \$1, expires_at = \$2 WHERE retired_at IS NULL AND kid <> \$3`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		ring := New()
+		require.NoError(t, repo.Sync(ring))
+
+		// новый ключ уже опубликован, но подписывает пока старый
+		assert.Len(t, ring.JWKS().Keys, 2)
+		signing, err := ring.Signing()
+		require.NoError(t, err)
+		assert.Equal(t, k.ID, signing.ID)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("ResealWithPreviousSecret", func(t *testing.T) {
+		old, _ := newTestKeyDBRepository(t)
+		k := mustGenerate(t, AlgEdDSA)
+		sealed, err := old.seal(k.Private)
+		require.NoError(t, err)
+
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		repo, err := NewKeyDBRepository(db, zap.NewNop().Sugar(), "new-secret", Options{
+			Alg:            AlgEdDSA,
+			PreviousSecret: "test-secret",
+		})
+		require.NoError(t, err)
+
+		var resealed []byte
+		expectSyncStart(mock)
+		mock.ExpectQuery(selectKeys).WillReturnRows(sqlmock.NewRows(keyCols).
+			AddRow(k.ID, AlgEdDSA, sealed, k.CreatedAt, k.ActiveFrom, nil, nil))
+		mock.ExpectExec(`UPDATE signing_keys SET private_key = \$1 WHERE kid = \$2`).
+			WithArgs(capture(&resealed), k.ID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		ring := New()
+		require.NoError(t, repo.Sync(ring))
+		signing, err := ring.Signing()
+		require.NoError(t, err)
+		assert.Equal(t, k.ID, signing.ID)
+
+		// в базе ключ теперь открывается текущим secret
+		priv, err := repo.open(resealed)
+		require.NoError(t, err)
+		assert.Equal(t, k.Public(), priv.Public())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		old, _ := newTestKeyDBRepository(t)
+		k := mustGenerate(t, AlgEdDSA)
+		sealed, err := old.seal(k.Private)
+		require.NoError(t, err)
+
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		repo, err := NewKeyDBRepository(db, zap.NewNop().Sugar(), "new-secret", Options{Alg: AlgEdDSA})
+		require.NoError(t, err)
+
+		expectSyncStart(mock)
+		mock.ExpectQuery(selectKeys).WillReturnRows(sqlmock.NewRows(keyCols).
+			AddRow(k.ID, AlgEdDSA, sealed, k.CreatedAt, k.ActiveFrom, nil, nil))
+		mock.ExpectRollback()
+
+		assert.Equal(t, ErrCorruptedKey, repo.Sync(New()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("DatabaseError", func(t *testing.T) {
+		repo, mock := newTestKeyDBRepository(t)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+			WillReturnError(errors.New("database error"))
+		mock.ExpectRollback()
+
+		assert.Equal(t, ErrInternalDB, repo.Sync(New()))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestKeyDBRepository_SealOpen(t *testing.T) {
+	repo, _ := newTestKeyDBRepository(t)
+
+	for _, alg := range []string{AlgRS256, AlgEdDSA} {
+		k := mustGenerate(t, alg)
+
+		sealed, err := repo.seal(k.Private)
+		require.NoError(t, err)
+
+		priv, err := repo.open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, k.Public(), priv.Public())
+	}
+
+	// другой secret не расшифрует ключ
+	other, err := NewKeyDBRepository(nil, zap.NewNop().Sugar(), "other-secret", Options{Alg: AlgEdDSA})
+	require.NoError(t, err)
+	sealed, _ := repo.seal(mustGenerate(t, AlgEdDSA).Private)
+	_, err = other.open(sealed)
+	assert.Error(t, err)
+}
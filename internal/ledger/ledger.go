@@ -0,0 +1,131 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Виды проводок в журнале.
+const (
+	KindSignupGrant    = "signup_grant"
+	KindTransfer       = "transfer"
+	KindPurchase       = "purchase"
+	KindAdjustment     = "adjustment"
+	KindOpeningBalance = "opening_balance" // балансы до появления журнала, миграция 0015
+)
+
+// Системные счета - "вторая сторона" для операций, где монеты
+// появляются или уходят из кошельков пользователей.
+const (
+	AccountIssuance    = "system:issuance"    // выдача монет при регистрации
+	AccountStore       = "system:store"       // выручка магазина
+	AccountAdjustments = "system:adjustments" // ручные корректировки админом
+	AccountOpening     = "system:opening"     // балансы до появления журнала
+
+	userAccountPrefix = "user:"
+)
+
+var (
+	ErrEmptyEntry    = errors.New("ledger entry has no postings")
+	ErrUnbalanced    = errors.New("ledger entry is unbalanced")
+	ErrZeroPosting   = errors.New("ledger posting with zero amount")
+	ErrInvalidAmount = errors.New("ledger amount must be positive")
+)
+
+// Счет кошелька пользователя.
+func UserAccount(userID string) string {
+	return userAccountPrefix + userID
+}
+
+// Обратное преобразование, ok = false для системных счетов.
+func UserIDFromAccount(account string) (string, bool) {
+	if !strings.HasPrefix(account, userAccountPrefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(account, userAccountPrefix), true
+}
+
+// Проводка по одному счету: плюс - зачисление, минус - списание.
+type Posting struct {
+	Account string `json:"account"`
+	Amount  int    `json:"amount"`
+}
+
+// Запись журнала - набор проводок, сумма которых всегда равна нулю.
+type Entry struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Reference string    `json:"reference,omitempty"`
+	Postings  []Posting `json:"postings"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (e Entry) Validate() error {
+	if len(e.Postings) == 0 {
+		return ErrEmptyEntry
+	}
+
+	sum := 0
+	for _, p := range e.Postings {
+		if p.Amount == 0 {
+			return ErrZeroPosting
+		}
+		sum += p.Amount
+	}
+	if sum != 0 {
+		return ErrUnbalanced
+	}
+
+	return nil
+}
+
+// Перемещение amount монет со счета from на счет to.
+func Move(kind, reference, from, to string, amount int) (Entry, error) {
+	if amount <= 0 {
+		return Entry{}, ErrInvalidAmount
+	}
+
+	return Entry{
+		Kind:      kind,
+		Reference: reference,
+		Postings: []Posting{
+			{Account: from, Amount: -amount},
+			{Account: to, Amount: amount},
+		},
+	}, nil
+}
+
+// Ручная корректировка баланса пользователя на delta монет.
+func Adjustment(reason, userID string, delta int) (Entry, error) {
+	if delta < 0 {
+		return Move(KindAdjustment, reason, UserAccount(userID), AccountAdjustments, -delta)
+	}
+
+	return Move(KindAdjustment, reason, AccountAdjustments, UserAccount(userID), delta)
+}
+
+// Расхождение между кэшированным балансом в users и журналом.
+type Discrepancy struct {
+	UserID        string `json:"userId"`
+	Login         string `json:"login"`
+	CachedBalance int    `json:"cachedBalance"`
+	LedgerBalance int    `json:"ledgerBalance"`
+}
+
+// Результат сверки.
+type Report struct {
+	Discrepancies     []Discrepancy `json:"discrepancies"`
+	UnbalancedEntries []int64       `json:"unbalancedEntries"`
+}
+
+func (r Report) OK() bool {
+	return len(r.Discrepancies) == 0 && len(r.UnbalancedEntries) == 0
+}
+
+type LedgerRepo interface {
+	Balance(ctx context.Context, account string) (int, error)
+	Reconcile(ctx context.Context) (Report, error)
+}
@@ -0,0 +1,65 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: ledger.go
+
+// Package ledger is a generated GoMock package.
+package ledger
+
+import (
+	context "context"
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockLedgerRepo is a mock of LedgerRepo interface.
+type MockLedgerRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockLedgerRepoMockRecorder
+}
+
+// MockLedgerRepoMockRecorder is the mock recorder for MockLedgerRepo.
+type MockLedgerRepoMockRecorder struct {
+	mock *MockLedgerRepo
+}
+
+// NewMockLedgerRepo creates a new mock instance.
+func NewMockLedgerRepo(ctrl *gomock.Controller) *MockLedgerRepo {
+	mock := &MockLedgerRepo{ctrl: ctrl}
+	mock.recorder = &MockLedgerRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockLedgerRepo) EXPECT() *MockLedgerRepoMockRecorder {
+	return m.recorder
+}
+
+// Balance mocks base method.
+func (m *MockLedgerRepo) Balance(ctx context.Context, account string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Balance", ctx, account)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Balance indicates an expected call of Balance.
+func (mr *MockLedgerRepoMockRecorder) Balance(ctx, account interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Balance", reflect.TypeOf((*MockLedgerRepo)(nil).Balance), ctx, account)
+}
+
+// Reconcile mocks base method.
+func (m *MockLedgerRepo) Reconcile(ctx context.Context) (Report, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reconcile", ctx)
+	ret0, _ := ret[0].(Report)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Reconcile indicates an expected call of Reconcile.
+func (mr *MockLedgerRepoMockRecorder) Reconcile(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reconcile", reflect.TypeOf((*MockLedgerRepo)(nil).Reconcile), ctx)
+}
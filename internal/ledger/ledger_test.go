@@ -0,0 +1,142 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func newTestDBRepository(t *testing.T) (*LedgerDBRepository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return NewLedgerDBRepository(db, zap.NewNop().Sugar()), mock
+}
+
+func TestEntry_Validate(t *testing.T) {
+	tests := []struct {
+		name          string
+		entry         Entry
+		expectedError error
+	}{
+		{
+			name:  "Balanced",
+			entry: Entry{Postings: []Posting{{"a", -10}, {"b", 4}, {"c", 6}}},
+		},
+		{
+			name:          "Unbalanced",
+			entry:         Entry{Postings: []Posting{{"a", -10}, {"b", 9}}},
+			expectedError: ErrUnbalanced,
+		},
+		{
+			name:          "Empty",
+			entry:         Entry{},
+			expectedError: ErrEmptyEntry,
+		},
+		{
+			name:          "ZeroPosting",
+			entry:         Entry{Postings: []Posting{{"a", 0}, {"b", 0}}},
+			expectedError: ErrZeroPosting,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expectedError, tt.entry.Validate())
+		})
+	}
+}
+
+func TestAdjustment(t *testing.T) {
+	e, err := Adjustment("fix", "user1", -30)
+	assert.NoError(t, err)
+	assert.Equal(t, []Posting{{UserAccount("user1"), -30}, {AccountAdjustments, 30}}, e.Postings)
+
+	_, err = Adjustment("fix", "user1", 0)
+	assert.Equal(t, ErrInvalidAmount, err)
+}
+
+func TestPost(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO ledger_entries \(kind, reference\) VALUES \(\$1, \$2\) RETURNING entry_id`).
+		WithArgs(KindPurchase, "cup").
+		WillReturnRows(sqlmock.NewRows([]string{"entry_id"}).AddRow(7))
+	mock.ExpectExec(`INSERT INTO ledger_postings \(entry_id, account, amount\) VALUES \(\$1, \$2, \$3\), \(\$1, \$4, \$5\)`).
+		WithArgs(int64(7), UserAccount("user1"), -20, AccountStore, 20).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	tx, err := repo.DB.Begin()
+	assert.NoError(t, err)
+
+	e, err := Move(KindPurchase, "cup", UserAccount("user1"), AccountStore, 20)
+	assert.NoError(t, err)
+
+	id, err := Post(context.Background(), tx, e, repo.Logger)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+	assert.NoError(t, tx.Commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLedgerDBRepository_Reconcile(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockDBSetup    func(sqlmock.Sqlmock)
+		expectedReport Report
+		expectedError  error
+	}{
+		{
+			name: "Consistent",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`HAVING u.amount_in_wallet <> COALESCE\(SUM\(p.amount\), 0\)`).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "amount_in_wallet", "ledger_balance"}))
+				mock.ExpectQuery(`HAVING SUM\(amount\) <> 0`).
+					WillReturnRows(sqlmock.NewRows([]string{"entry_id"}))
+			},
+			expectedReport: Report{Discrepancies: []Discrepancy{}, UnbalancedEntries: []int64{}},
+		},
+		{
+			name: "Mismatch",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`HAVING u.amount_in_wallet <> COALESCE\(SUM\(p.amount\), 0\)`).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "amount_in_wallet", "ledger_balance"}).
+						AddRow("user1", "login1", 900, 1000))
+				mock.ExpectQuery(`HAVING SUM\(amount\) <> 0`).
+					WillReturnRows(sqlmock.NewRows([]string{"entry_id"}).AddRow(3))
+			},
+			expectedReport: Report{
+				Discrepancies:     []Discrepancy{{UserID: "user1", Login: "login1", CachedBalance: 900, LedgerBalance: 1000}},
+				UnbalancedEntries: []int64{3},
+			},
+		},
+		{
+			name: "DatabaseError",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM users u`).
+					WillReturnError(errors.New("database error"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
+
+			rep, err := repo.Reconcile(context.Background())
+			assert.Equal(t, tt.expectedError, err)
+			assert.Equal(t, tt.expectedReport, rep)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"proj/internal/dbtx"
+	"proj/internal/tracing"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInternalDB = errors.New("database internal error")
+)
+
+type LedgerDBRepository struct {
+	DB     *sql.DB
+	Logger *zap.SugaredLogger
+}
+
+func NewLedgerDBRepository(db *sql.DB, l *zap.SugaredLogger) *LedgerDBRepository {
+	return &LedgerDBRepository{
+		DB:     db,
+		Logger: l,
+	}
+}
+
+/*
+Запись в журнал делается внутри транзакции вызывающего кода,
+чтобы изменение кэшированного баланса и проводки либо
+сохранились вместе, либо откатились вместе.
+*/
+func Post(ctx context.Context, tx *sql.Tx, e Entry, l *zap.SugaredLogger) (int64, error) {
+	if err := e.Validate(); err != nil {
+		l.Errorf("%v. More details: kind - %s -", err, e.Kind)
+		return 0, err
+	}
+
+	q := `
+	INSERT INTO ledger_entries (kind, reference)
+	VALUES ($1, $2)
+	RETURNING entry_id
+	`
+	var entryID int64
+	err := tx.QueryRowContext(ctx, q, e.Kind, e.Reference).Scan(&entryID)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, dbtx.Internal(err, ErrInternalDB)
+	}
+
+	// Все проводки записи вставляем одним запросом
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ledger_postings (entry_id, account, amount) VALUES ")
+	args := make([]interface{}, 0, 1+2*len(e.Postings))
+	args = append(args, entryID)
+	for i, p := range e.Postings {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		args = append(args, p.Account, p.Amount)
+		sb.WriteString("($1, $" + strconv.Itoa(len(args)-1) + ", $" + strconv.Itoa(len(args)) + ")")
+	}
+
+	_, err = tx.ExecContext(ctx, sb.String(), args...)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return entryID, nil
+}
+
+// Баланс счета, посчитанный по журналу.
+func (lr *LedgerDBRepository) Balance(ctx context.Context, account string) (int, error) {
+	ctx, span := tracing.Start(ctx, "ledger.Balance")
+	defer span.End()
+
+	balance, err := lr.balance(ctx, account)
+	tracing.Fail(span, err)
+	return balance, err
+}
+
+func (lr *LedgerDBRepository) balance(ctx context.Context, account string) (int, error) {
+	q := `
+	SELECT COALESCE(SUM(amount), 0)
+	FROM ledger_postings
+	WHERE account = $1
+	`
+	var balance int
+	err := lr.DB.QueryRowContext(ctx, q, account).Scan(&balance)
+	if err != nil {
+		lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return 0, ErrInternalDB
+	}
+
+	return balance, nil
+}
+
+/*
+Сверка журнала:
+  - пользователи, у которых amount_in_wallet не совпадает с суммой проводок
+  - записи журнала, сумма проводок которых не равна нулю
+*/
+func (lr *LedgerDBRepository) Reconcile(ctx context.Context) (Report, error) {
+	ctx, span := tracing.Start(ctx, "ledger.Reconcile")
+	defer span.End()
+
+	rep, err := lr.reconcile(ctx)
+	tracing.Fail(span, err)
+	return rep, err
+}
+
+func (lr *LedgerDBRepository) reconcile(ctx context.Context) (Report, error) {
+	var rep Report
+
+	ds, err := findDiscrepancies(ctx, lr)
+	if err != nil {
+		return Report{}, err
+	}
+	rep.Discrepancies = ds
+
+	ids, err := findUnbalancedEntries(ctx, lr)
+	if err != nil {
+		return Report{}, err
+	}
+	rep.UnbalancedEntries = ids
+
+	return rep, nil
+}
+
+func findDiscrepancies(ctx context.Context, lr *LedgerDBRepository) ([]Discrepancy, error) {
+	q := `
+	SELECT u.user_id, u.login, u.amount_in_wallet, COALESCE(SUM(p.amount), 0) AS ledger_balance
+	FROM users u
+	LEFT JOIN ledger_postings p ON p.account = 'user:' || u.user_id::text
+	GROUP BY u.user_id, u.login, u.amount_in_wallet
+	HAVING u.amount_in_wallet <> COALESCE(SUM(p.amount), 0)
+	ORDER BY u.login
+	`
+	rows, err := lr.DB.QueryContext(ctx, q)
+	if err != nil {
+		lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	res := make([]Discrepancy, 0)
+	for rows.Next() {
+		var d Discrepancy
+		if err = rows.Scan(&d.UserID, &d.Login, &d.CachedBalance, &d.LedgerBalance); err != nil {
+			lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		res = append(res, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	return res, nil
+}
+
+func findUnbalancedEntries(ctx context.Context, lr *LedgerDBRepository) ([]int64, error) {
+	q := `
+	SELECT entry_id
+	FROM ledger_postings
+	GROUP BY entry_id
+	HAVING SUM(amount) <> 0
+	ORDER BY entry_id
+	`
+	rows, err := lr.DB.QueryContext(ctx, q)
+	if err != nil {
+		lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	res := make([]int64, 0)
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		res = append(res, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		lr.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	return res, nil
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "merch"
+
+/*
+Метрики сервиса. Регистр свой, а не глобальный из client_golang:
+в /metrics попадает только то, что зарегистрировано здесь.
+  - http_*  - запросы по шаблону маршрута, методу и коду ответа
+  - db_*    - состояние пула соединений (RegisterDB)
+  - остальное - бизнес-счетчики, их увеличивают репозитории
+*/
+var (
+	Registry = prometheus.NewRegistry()
+
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"route", "method", "status"})
+
+	CoinsTransferred = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "coins_transferred_total",
+		Help:      "Coins moved between users by successful transfers.",
+	})
+
+	Purchases = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "purchases_total",
+		Help:      "Successful purchases by item.",
+	}, []string{"item"})
+
+	InsufficientFunds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "insufficient_funds_total",
+		Help:      "Operations rejected because the wallet balance was too low.",
+	}, []string{"operation"})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Failed logins by reason.",
+	}, []string{"reason"})
+
+	Registrations = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "registrations_total",
+		Help:      "New users, self-registered or created by an administrator.",
+	})
+)
+
+// Значения метки operation у InsufficientFunds.
+const (
+	OpTransfer = "transfer"
+	OpPurchase = "purchase"
+	OpAdjust   = "adjust"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		// повторы транзакций из dbtx считаются в expvar, отдаем их и здесь
+		collectors.NewExpvarCollector(map[string]*prometheus.Desc{
+			"tx_retries": prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "", "tx_retries_total"),
+				"Transactions restarted after a serialization failure or deadlock.",
+				[]string{"tx"}, nil,
+			),
+			"tx_retries_exhausted": prometheus.NewDesc(
+				prometheus.BuildFQName(namespace, "", "tx_retries_exhausted_total"),
+				"Transactions that failed after the retry budget was spent.",
+				[]string{"tx"}, nil,
+			),
+		}),
+		HTTPRequests, HTTPDuration,
+		CoinsTransferred, Purchases, InsufficientFunds, AuthFailures, Registrations,
+	)
+}
+
+// Статистика пула sql.DB: открытые и занятые соединения, ожидания и т.д.
+// Значения читаются из db.Stats() на каждом запросе /metrics.
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
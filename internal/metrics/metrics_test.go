@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	require.NoError(t, RegisterDB(db, "shop"))
+
+	CoinsTransferred.Add(50)
+	Purchases.WithLabelValues("cup").Inc()
+	HTTPRequests.WithLabelValues("/api/info", "GET", "200").Inc()
+	HTTPDuration.WithLabelValues("/api/info", "GET", "200").Observe(0.02)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	for _, want := range []string{
+		`merch_coins_transferred_total 50`,
+		`merch_purchases_total{item="cup"} 1`,
+		`merch_http_requests_total{method="GET",route="/api/info",status="200"} 1`,
+		`merch_http_request_duration_seconds_bucket{method="GET",route="/api/info",status="200",le="0.025"} 1`,
+		`go_sql_max_open_connections{db_name="shop"} 0`,
+	} {
+		require.Contains(t, string(body), want)
+	}
+}
@@ -1,23 +1,65 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"proj/internal/audit"
 	"proj/internal/session"
+
+	"go.uber.org/zap"
 )
 
-func Auth(sm *session.SessionManager) func(http.Handler) http.Handler {
+const realm = "merch-store"
+
+var ErrForbidden = errors.New("permission denied")
+
+// Отправка ошибки клиенту. Передается снаружи, чтобы middleware
+// отвечал в том же формате, что и хендлеры.
+type ErrorSender func(w http.ResponseWriter, err error, statusCode int, logger *zap.SugaredLogger)
+
+func Auth(sm *session.SessionManager, sendError ErrorSender) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Проверка сессии пользователя
 			sess, err := sm.Check(r)
 			if err != nil {
-				http.Redirect(w, r, "/", http.StatusFound)
+				Unauthorized(w, err, sendError, sm.Logger)
 				return
 			}
 
-			// Добавляем сессию в контекст и передаем дальше
-			ctx := session.ContextWithSession(r.Context(), sess)
+			// Кладем пользователя в контекст, хендлеры токен больше не разбирают
+			ctx := session.ContextWithPrincipal(r.Context(), sess.Principal())
+			ctx = audit.WithActor(ctx, sess.UserID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+/*
+Ответ на непринятый токен. Причина видна клиенту в теле и в
+заголовке WWW-Authenticate (RFC 6750):
+  - токена нет 					  - только realm, без error
+  - токен истек 				  - можно обновить через /api/token/refresh
+  - сессия отозвана или закончилась - нужен повторный вход
+  - токен битый или чужой 		  - нужен повторный вход
+
+Ошибка базы - это не проблема клиента, отвечаем 500 без заголовка.
+*/
+func Unauthorized(w http.ResponseWriter, err error, sendError ErrorSender, logger *zap.SugaredLogger) {
+	if errors.Is(err, session.ErrInternalDB) {
+		sendError(w, err, http.StatusInternalServerError, logger)
+		return
+	}
+
+	w.Header().Set("WWW-Authenticate", Challenge(err))
+	sendError(w, err, http.StatusUnauthorized, logger)
+}
+
+func Challenge(err error) string {
+	if err == nil || errors.Is(err, session.ErrTokenMissing) {
+		return fmt.Sprintf(`Bearer realm=%q`, realm)
+	}
+
+	return fmt.Sprintf(`Bearer realm=%q, error="invalid_token", error_description=%q`, realm, err.Error())
+}
@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/keyring"
+	"proj/internal/rbac"
+	"proj/internal/session"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var checkCols = []string{"session_id", "user_id", "start_time", "end_time", "revoked_at", "last_seen_at"}
+
+func newTestSessionManager(t *testing.T) (*session.SessionManager, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	k, err := keyring.Generate(keyring.AlgEdDSA)
+	require.NoError(t, err)
+
+	return session.NewSessionManager(db, zap.NewNop().Sugar(), keyring.New(k)), mock
+}
+
+func sendErrorStub(w http.ResponseWriter, err error, statusCode int, _ *zap.SugaredLogger) {
+	http.Error(w, err.Error(), statusCode)
+}
+
+func signToken(t *testing.T, sm *session.SessionManager, exp time.Time, roles []string) string {
+	token, err := sm.Keys.Sign(jwt.MapClaims{
+		session.FieldSessionID: "session1",
+		session.FieldUser: map[string]interface{}{
+			session.FieldLogin: "username",
+			session.FieldRoles: roles,
+		},
+		"exp": exp.Unix(),
+	})
+	require.NoError(t, err)
+
+	return token
+}
+
+func expectSession(mock sqlmock.Sqlmock, revokedAt interface{}) {
+	mock.ExpectQuery(`FROM sessions WHERE session_id = \$1`).
+		WithArgs("session1").
+		WillReturnRows(sqlmock.NewRows(checkCols).
+			AddRow("session1", "user1", time.Now(), time.Now().Add(time.Hour), revokedAt, time.Now()))
+}
+
+func TestAuth(t *testing.T) {
+	tests := map[string]struct {
+		header     func(sm *session.SessionManager) string
+		dbSetup    func(mock sqlmock.Sqlmock)
+		statusCode int
+		challenge  string
+	}{
+		"valid token": {
+			header: func(sm *session.SessionManager) string {
+				return "Bearer " + signToken(t, sm, time.Now().Add(time.Minute), nil)
+			},
+			dbSetup:    func(mock sqlmock.Sqlmock) { expectSession(mock, nil) },
+			statusCode: http.StatusOK,
+		},
+		"missing token": {
+			header:     func(*session.SessionManager) string { return "" },
+			statusCode: http.StatusUnauthorized,
+			challenge:  `Bearer realm="merch-store"`,
+		},
+		"malformed token": {
+			header:     func(*session.SessionManager) string { return "Bearer garbage" },
+			statusCode: http.StatusUnauthorized,
+			challenge:  `Bearer realm="merch-store", error="invalid_token", error_description="access token malformed"`,
+		},
+		"expired token": {
+			header: func(sm *session.SessionManager) string {
+				return "Bearer " + signToken(t, sm, time.Now().Add(-time.Minute), nil)
+			},
+			statusCode: http.StatusUnauthorized,
+			challenge:  `Bearer realm="merch-store", error="invalid_token", error_description="access token expired"`,
+		},
+		"revoked session": {
+			header: func(sm *session.SessionManager) string {
+				return "Bearer " + signToken(t, sm, time.Now().Add(time.Minute), nil)
+			},
+			dbSetup:    func(mock sqlmock.Sqlmock) { expectSession(mock, time.Now()) },
+			statusCode: http.StatusUnauthorized,
+			challenge:  `Bearer realm="merch-store", error="invalid_token", error_description="session revoked"`,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			sm, mock := newTestSessionManager(t)
+			if tt.dbSetup != nil {
+				tt.dbSetup(mock)
+			}
+
+			var reached bool
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				p, ok := session.FromContext(r.Context())
+				require.True(t, ok)
+				require.Equal(t, "user1", p.UserID)
+				reached = true
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/info", nil)
+			if h := tt.header(sm); h != "" {
+				req.Header.Set("Authorization", h)
+			}
+			w := httptest.NewRecorder()
+
+			Auth(sm, sendErrorStub)(next).ServeHTTP(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			require.Equal(t, tt.statusCode == http.StatusOK, reached)
+			require.Equal(t, tt.challenge, w.Header().Get("WWW-Authenticate"))
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := map[string]struct {
+		roles      []string
+		statusCode int
+	}{
+		"permitted": {roles: []string{rbac.RoleAdmin}, statusCode: http.StatusOK},
+		"forbidden": {roles: []string{rbac.RoleEmployee}, statusCode: http.StatusForbidden},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			sm, mock := newTestSessionManager(t)
+			expectSession(mock, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/catalog", nil)
+			req.Header.Set("Authorization", "Bearer "+signToken(t, sm, time.Now().Add(time.Minute), tt.roles))
+			w := httptest.NewRecorder()
+
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			RequirePermission(sm, rbac.PermCatalogManage, sendErrorStub)(next).ServeHTTP(w, req)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			require.Empty(t, w.Header().Get("WWW-Authenticate"))
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"proj/internal/metrics"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Маршрут запросов, для которых роутер не нашел обработчик:
+// настоящий путь в метку не кладем, иначе меток будет без счета.
+const unmatchedRoute = "unmatched"
+
+/*
+Счетчик и время запросов по шаблону маршрута ("/api/buy/{item}"),
+методу и коду ответа. Стоит первым в цепочке, чтобы время включало
+авторизацию и таймауты, а код ответа - их ошибки.
+*/
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(sw, r)
+
+		route := routeTemplate(r)
+		status := strconv.Itoa(sw.status)
+		metrics.HTTPRequests.WithLabelValues(route, r.Method, status).Inc()
+		metrics.HTTPDuration.WithLabelValues(route, r.Method, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Шаблон маршрута, под который попал запрос.
+func routeTemplate(r *http.Request) string {
+	if cur := mux.CurrentRoute(r); cur != nil {
+		if tpl, err := cur.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return unmatchedRoute
+}
+
+// Запоминает код ответа. Хендлер, который ничего не записал
+// явно, отвечает 200.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/metrics"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	tests := map[string]struct {
+		path    string
+		handler http.HandlerFunc
+		route   string
+		status  string
+	}{
+		"route template": {
+			path:    "/api/buy/hoody",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) },
+			route:   "/api/buy/{item}",
+			status:  "200",
+		},
+		"explicit status": {
+			path:    "/api/buy/ghost",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusBadRequest) },
+			route:   "/api/buy/{item}",
+			status:  "400",
+		},
+		"unmatched route": {
+			path:   "/no/such/path",
+			route:  unmatchedRoute,
+			status: "404",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.Use(Metrics)
+			if tt.handler != nil {
+				r.HandleFunc("/api/buy/{item}", tt.handler)
+			}
+			r.NotFoundHandler = Metrics(http.NotFoundHandler())
+
+			counter := metrics.HTTPRequests.WithLabelValues(tt.route, "GET", tt.status)
+			before := testutil.ToFloat64(counter)
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+			require.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+func TestStatusWriter_FirstStatusWins(t *testing.T) {
+	w := &statusWriter{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+
+	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(http.StatusInternalServerError)
+
+	require.Equal(t, http.StatusCreated, w.status)
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+	"proj/internal/audit"
+	"proj/internal/rbac"
+	"proj/internal/session"
+)
+
+// RequirePermission пускает только сессии, у ролей которых есть право perm.
+func RequirePermission(sm *session.SessionManager, perm rbac.Permission, sendError ErrorSender) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sess, err := sm.Check(r)
+			if err != nil {
+				Unauthorized(w, err, sendError, sm.Logger)
+				return
+			}
+
+			if !rbac.HasPermission(sess.Roles, perm) {
+				sm.Logger.Warnf("userID - %s - without %s tried to access %s", sess.UserID, perm, r.URL.Path)
+				sendError(w, ErrForbidden, http.StatusForbidden, sm.Logger)
+				return
+			}
+
+			ctx := session.ContextWithPrincipal(r.Context(), sess.Principal())
+			ctx = audit.WithActor(ctx, sess.UserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
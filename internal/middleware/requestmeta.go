@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"proj/internal/audit"
+	"proj/internal/session"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	HeaderRequestID = "X-Request-ID"
+
+	requestIDMaxLen = 64
+)
+
+/*
+Данные запроса для журнала аудита: id запроса и адрес клиента.
+id берем из X-Request-ID, если его прислал прокси или клиент, иначе
+выдаем свой и возвращаем в ответе, чтобы запрос можно было найти
+в журнале. Автора добавляет middleware авторизации.
+*/
+func RequestMeta(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(HeaderRequestID)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(HeaderRequestID, id)
+		// по id из журнала аудита можно найти трассу запроса
+		trace.SpanFromContext(r.Context()).SetAttributes(attribute.String("request.id", id))
+
+		ctx := audit.WithMeta(r.Context(), audit.Meta{
+			RequestID: id,
+			IP:        session.DeviceFromRequest(r).IP,
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Чужой id пишется в журнал и в заголовок ответа, поэтому
+// пропускаем только короткие печатные ASCII-строки.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > requestIDMaxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
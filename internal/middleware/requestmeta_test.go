@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proj/internal/audit"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestMeta(t *testing.T) {
+	tests := map[string]struct {
+		header    string
+		keepsOwn  bool
+		generated bool
+	}{
+		"id from proxy":    {header: "req-42", keepsOwn: true},
+		"no id":            {generated: true},
+		"id with spaces":   {header: "req 42", generated: true},
+		"id is too long":   {header: strings.Repeat("a", requestIDMaxLen+1), generated: true},
+		"id at max length": {header: strings.Repeat("a", requestIDMaxLen), keepsOwn: true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var meta audit.Meta
+			h := RequestMeta(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				meta = audit.MetaFrom(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/api/info", nil)
+			req.RemoteAddr = "10.0.0.1:53211"
+			if tt.header != "" {
+				req.Header.Set(HeaderRequestID, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+
+			require.Equal(t, "10.0.0.1", meta.IP)
+			require.Empty(t, meta.Actor)
+			require.Equal(t, meta.RequestID, w.Header().Get(HeaderRequestID))
+			if tt.keepsOwn {
+				require.Equal(t, tt.header, meta.RequestID)
+			}
+			if tt.generated {
+				require.Len(t, meta.RequestID, 36)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Сроки выполнения запросов. Маршрут задается шаблоном пути,
+// как он зарегистрирован в роутере: "/api/buy/{item}".
+type Timeouts struct {
+	// Для маршрутов без своего значения, 0 - без ограничения
+	Default time.Duration
+	Routes  map[string]time.Duration
+}
+
+func (t Timeouts) For(route string) time.Duration {
+	if d, ok := t.Routes[route]; ok {
+		return d
+	}
+	return t.Default
+}
+
+/*
+Ограничивает время запроса: контекст запроса получает дедлайн,
+и репозитории прерывают работу с базой, когда он истекает. Сам ответ
+(504) отдает хендлер, увидев отмененный контекст.
+*/
+func Timeout(t Timeouts) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := t.Default
+			if route := mux.CurrentRoute(r); route != nil {
+				if tpl, err := route.GetPathTemplate(); err == nil {
+					d = t.For(tpl)
+				}
+			}
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout(t *testing.T) {
+	timeouts := Timeouts{
+		Default: time.Minute,
+		Routes: map[string]time.Duration{
+			"/api/buy/{item}": time.Second,
+			"/api/stream":     0,
+		},
+	}
+
+	tests := map[string]struct {
+		path     string
+		timeout  time.Duration
+		deadline bool
+	}{
+		"route timeout":   {path: "/api/buy/hoody", timeout: time.Second, deadline: true},
+		"default timeout": {path: "/api/info", timeout: time.Minute, deadline: true},
+		"no timeout":      {path: "/api/stream"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var (
+				deadline time.Time
+				ok       bool
+			)
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				deadline, ok = r.Context().Deadline()
+			}
+
+			r := mux.NewRouter()
+			r.Use(Timeout(timeouts))
+			r.HandleFunc("/api/buy/{item}", handler)
+			r.HandleFunc("/api/info", handler)
+			r.HandleFunc("/api/stream", handler)
+
+			start := time.Now()
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+			require.Equal(t, tt.deadline, ok)
+			if tt.deadline {
+				require.WithinDuration(t, start.Add(tt.timeout), deadline, time.Second)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestTracing(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	tests := map[string]struct {
+		header  string
+		handler http.HandlerFunc
+		check   func(t *testing.T, span sdktrace.ReadOnlySpan)
+	}{
+		"continues caller trace": {
+			header:  traceparent,
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) },
+			check: func(t *testing.T, span sdktrace.ReadOnlySpan) {
+				require.Equal(t, "GET /api/buy/{item}", span.Name())
+				require.Equal(t, trace.SpanKindServer, span.SpanKind())
+				require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+				require.Equal(t, "00f067aa0ba902b7", span.Parent().SpanID().String())
+				require.True(t, span.Parent().IsRemote())
+				require.Equal(t, codes.Unset, span.Status().Code)
+			},
+		},
+		"new trace without header": {
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				// хендлер получает контекст со спаном запроса
+				require.True(t, trace.SpanFromContext(r.Context()).IsRecording())
+			},
+			check: func(t *testing.T, span sdktrace.ReadOnlySpan) {
+				require.False(t, span.Parent().IsValid())
+			},
+		},
+		"server error is marked": {
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) },
+			check: func(t *testing.T, span sdktrace.ReadOnlySpan) {
+				require.Equal(t, codes.Error, span.Status().Code)
+			},
+		},
+		"client error is not": {
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusPaymentRequired) },
+			check: func(t *testing.T, span sdktrace.ReadOnlySpan) {
+				require.Equal(t, codes.Unset, span.Status().Code)
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := mux.NewRouter()
+			r.Use(Tracing)
+			r.HandleFunc("/api/buy/{item}", tt.handler)
+
+			req := httptest.NewRequest("GET", "/api/buy/hoody", nil)
+			if tt.header != "" {
+				req.Header.Set("traceparent", tt.header)
+			}
+			before := len(sr.Ended())
+			r.ServeHTTP(httptest.NewRecorder(), req)
+
+			spans := sr.Ended()
+			require.Len(t, spans, before+1)
+			tt.check(t, spans[before])
+		})
+	}
+}
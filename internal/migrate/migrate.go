@@ -0,0 +1,367 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Ключ для pg_advisory_lock: миграции применяет одна реплика за раз
+	lockID = 720_021
+)
+
+var (
+	ErrInternalDB       = errors.New("database internal error")
+	ErrInvalidMigration = errors.New("invalid migration")
+	ErrUnknownVersion   = errors.New("unknown migration version")
+	ErrNoDown           = errors.New("migration has no down script")
+)
+
+// Миграции лежат рядом и вкомпилированы в бинарник:
+// NNNN_name.up.sql и NNNN_name.down.sql
+//
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+var fileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+func (s Status) Applied() bool {
+	return s.AppliedAt != nil
+}
+
+// Читает миграции из fsys (файлы *.sql в корне), сортирует по версии.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		m := fileRe.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("%w: bad file name %s", ErrInvalidMigration, e.Name())
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: bad version in %s", ErrInvalidMigration, e.Name())
+		}
+
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("%w: version %d has names %s and %s", ErrInvalidMigration, version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("%w: version %d has no up script", ErrInvalidMigration, mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+/*
+Применяет версионированные миграции. Примененные версии хранятся
+в schema_migrations. Каждая миграция идет в своей транзакции вместе
+с записью о ней, так что упавшая миграция не оставляет базу
+наполовину измененной. Все операции берут advisory lock: реплики,
+которые стартуют одновременно, применяют миграции по очереди.
+*/
+type Migrator struct {
+	DB         *sql.DB
+	Logger     *zap.SugaredLogger
+	Migrations []Migration
+}
+
+func NewMigrator(db *sql.DB, l *zap.SugaredLogger) (*Migrator, error) {
+	sub, err := fs.Sub(embedded, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Load(sub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		DB:         db,
+		Logger:     l,
+		Migrations: migrations,
+	}, nil
+}
+
+// Последняя известная бинарнику версия.
+func (m *Migrator) Latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// Применяет все непримененные миграции, возвращает их количество.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	return m.to(ctx, m.Latest(), false)
+}
+
+// Откатывает последнюю примененную миграцию.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		current := currentVersion(applied)
+		if current == 0 {
+			m.Logger.Info("no migrations to roll back")
+			return nil
+		}
+
+		mig, ok := m.find(current)
+		if !ok {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, current)
+		}
+		return m.down(ctx, conn, mig)
+	})
+}
+
+// Приводит базу к версии version: применяет или откатывает миграции.
+// 0 - откатить все.
+func (m *Migrator) To(ctx context.Context, version int64) error {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	_, err := m.to(ctx, version, true)
+	return err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(_ *sql.Conn, applied map[int64]time.Time) error {
+		for _, mig := range m.Migrations {
+			s := Status{Version: mig.Version, Name: mig.Name}
+			if at, ok := applied[mig.Version]; ok {
+				s.AppliedAt = &at
+			}
+			statuses = append(statuses, s)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+func (m *Migrator) to(ctx context.Context, target int64, allowDown bool) (int, error) {
+	var n int
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		if current := currentVersion(applied); current > m.Latest() {
+			// база новее бинарника: откатываться сами не будем
+			m.Logger.Warnf("database schema version %d is newer than latest known %d", current, m.Latest())
+		}
+
+		for _, mig := range m.Migrations {
+			if mig.Version > target {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.up(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+
+		if !allowDown {
+			return nil
+		}
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			mig := m.Migrations[i]
+			if mig.Version <= target {
+				break
+			}
+			if _, ok := applied[mig.Version]; !ok {
+				continue
+			}
+			if err := m.down(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (m *Migrator) up(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	m.Logger.Infof("applying migration %d_%s", mig.Version, mig.Name)
+	return m.inTx(ctx, conn, mig, mig.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name)
+}
+
+func (m *Migrator) down(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	if mig.Down == "" {
+		return fmt.Errorf("%w: %d_%s", ErrNoDown, mig.Version, mig.Name)
+	}
+	m.Logger.Infof("rolling back migration %d_%s", mig.Version, mig.Name)
+	return m.inTx(ctx, conn, mig, mig.Down, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+}
+
+// Скрипт миграции и запись в schema_migrations - одной транзакцией.
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, mig Migration, script, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	// несколько операторов без параметров pq отправляет одним simple query
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		m.Logger.Errorf("%v. More details: migration %d_%s: %v", ErrInternalDB, mig.Version, mig.Name, err)
+		return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	if err := tx.Commit(); err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	return nil
+}
+
+/*
+advisory lock сессионный, поэтому держим одно соединение на все
+время работы: и блокировка, и транзакции миграций идут через него.
+Примененные версии читаем уже под блокировкой.
+*/
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer func() {
+		// ctx мог уже истечь, а отпустить блокировку нужно в любом случае
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+			m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	q := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)
+	`
+	if _, err := conn.ExecContext(ctx, q); err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var (
+			version int64
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		applied[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		m.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+	return Migration{}, false
+}
+
+func currentVersion(applied map[int64]time.Time) int64 {
+	var current int64
+	for v := range applied {
+		if v > current {
+			current = v
+		}
+	}
+	return current
+}
@@ -0,0 +1,272 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestLoad(t *testing.T) {
+	tests := map[string]struct {
+		fsys     fstest.MapFS
+		versions []int64
+		err      error
+	}{
+		"sorted by version": {
+			fsys: fstest.MapFS{
+				"0010_b.up.sql":   {Data: []byte("CREATE TABLE b ();")},
+				"0002_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+				"0002_a.down.sql": {Data: []byte("DROP TABLE a;")},
+				"README.md":       {Data: []byte("not a migration")},
+			},
+			versions: []int64{2, 10},
+		},
+		"bad file name": {
+			fsys: fstest.MapFS{"init.sql": {Data: []byte("SELECT 1;")}},
+			err:  ErrInvalidMigration,
+		},
+		"zero version": {
+			fsys: fstest.MapFS{"0000_a.up.sql": {Data: []byte("SELECT 1;")}},
+			err:  ErrInvalidMigration,
+		},
+		"down without up": {
+			fsys: fstest.MapFS{"0001_a.down.sql": {Data: []byte("DROP TABLE a;")}},
+			err:  ErrInvalidMigration,
+		},
+		"names differ": {
+			fsys: fstest.MapFS{
+				"0001_a.up.sql":   {Data: []byte("CREATE TABLE a ();")},
+				"0001_b.down.sql": {Data: []byte("DROP TABLE a;")},
+			},
+			err: ErrInvalidMigration,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			migrations, err := Load(tt.fsys)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+
+			versions := make([]int64, 0, len(migrations))
+			for _, m := range migrations {
+				versions = append(versions, m.Version)
+			}
+			require.Equal(t, tt.versions, versions)
+		})
+	}
+}
+
+// Встроенные миграции должны разбираться и уметь откатываться.
+func TestNewMigrator_Embedded(t *testing.T) {
+	m, err := NewMigrator(nil, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	require.NotEmpty(t, m.Migrations)
+
+	for _, mig := range m.Migrations {
+		require.NotEmpty(t, mig.Down, "migration %d_%s", mig.Version, mig.Name)
+	}
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock DB: %v", err)
+	}
+
+	return &Migrator{
+		DB:     db,
+		Logger: zap.NewNop().Sugar(),
+		Migrations: []Migration{
+			{Version: 1, Name: "users", Up: "CREATE TABLE users", Down: "DROP TABLE users"},
+			{Version: 2, Name: "items", Up: "CREATE TABLE items", Down: "DROP TABLE items"},
+		},
+	}, mock
+}
+
+// Блокировка, schema_migrations и чтение примененных версий.
+func expectLocked(mock sqlmock.Sqlmock, applied ...int64) {
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range applied {
+		rows.AddRow(v, time.Now())
+	}
+	mock.ExpectQuery(`SELECT version, applied_at FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WithArgs(lockID).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUp(mock sqlmock.Sqlmock, version int64, name string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE ` + name).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(version, name).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func expectDown(mock sqlmock.Sqlmock, version int64, name string) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`DROP TABLE ` + name).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations`).WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestMigrator(t *testing.T) {
+	tests := []struct {
+		name          string
+		run           func(m *Migrator) error
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "UpFromScratch",
+			run: func(m *Migrator) error {
+				n, err := m.Up(context.Background())
+				if n != 2 {
+					return errors.New("expected 2 applied migrations")
+				}
+				return err
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock)
+				expectUp(mock, 1, "users")
+				expectUp(mock, 2, "items")
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "UpSkipsApplied",
+			run: func(m *Migrator) error {
+				_, err := m.Up(context.Background())
+				return err
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1)
+				expectUp(mock, 2, "items")
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "UpFailedMigrationRolledBack",
+			run: func(m *Migrator) error {
+				_, err := m.Up(context.Background())
+				return err
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1)
+				mock.ExpectBegin()
+				mock.ExpectExec(`CREATE TABLE items`).WillReturnError(errors.New("syntax error"))
+				mock.ExpectRollback()
+				expectUnlock(mock)
+			},
+			expectedError: errors.New("migration 2_items: syntax error"),
+		},
+		{
+			name: "DownLatest",
+			run: func(m *Migrator) error {
+				return m.Down(context.Background())
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1, 2)
+				expectDown(mock, 2, "items")
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "DownNothingApplied",
+			run: func(m *Migrator) error {
+				return m.Down(context.Background())
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock)
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "ToLowerVersion",
+			run: func(m *Migrator) error {
+				return m.To(context.Background(), 1)
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1, 2)
+				expectDown(mock, 2, "items")
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "ToZero",
+			run: func(m *Migrator) error {
+				return m.To(context.Background(), 0)
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1, 2)
+				expectDown(mock, 2, "items")
+				expectDown(mock, 1, "users")
+				expectUnlock(mock)
+			},
+		},
+		{
+			name: "ToUnknownVersion",
+			run: func(m *Migrator) error {
+				return m.To(context.Background(), 3)
+			},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrUnknownVersion,
+		},
+		{
+			name: "LockFailed",
+			run: func(m *Migrator) error {
+				_, err := m.Up(context.Background())
+				return err
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`SELECT pg_advisory_lock`).WillReturnError(errors.New("connection refused"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, mock := newTestMigrator(t)
+			tt.mockDBSetup(mock)
+
+			err := tt.run(m)
+
+			switch {
+			case tt.expectedError == nil:
+				require.NoError(t, err)
+			case errors.Is(err, tt.expectedError):
+			default:
+				require.EqualError(t, err, tt.expectedError.Error())
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigrator_Status(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	expectLocked(mock, 1)
+	expectUnlock(mock)
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, statuses, 2)
+	require.True(t, statuses[0].Applied())
+	require.False(t, statuses[1].Applied())
+}
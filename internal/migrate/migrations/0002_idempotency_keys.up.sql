@@ -0,0 +1,14 @@
+-- Результаты запросов с заголовком Idempotency-Key (sendCoin, buy).
+-- status_code = 0 - запрос еще выполняется.
+CREATE TABLE idempotency_keys (
+    user_id UUID REFERENCES users(user_id) ON DELETE CASCADE,
+    idem_key VARCHAR(255) NOT NULL,
+    fingerprint CHAR(64) NOT NULL,
+    status_code INTEGER NOT NULL,
+    response BYTEA,
+    created_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, idem_key)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
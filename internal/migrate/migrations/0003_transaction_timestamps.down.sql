@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS transactions_receiver_created_idx;
+DROP INDEX IF EXISTS transactions_sender_created_idx;
+ALTER TABLE transactions DROP COLUMN IF EXISTS created_at;
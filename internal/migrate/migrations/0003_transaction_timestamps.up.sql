@@ -0,0 +1,6 @@
+-- Старым переводам время неизвестно, им достается время миграции.
+ALTER TABLE transactions ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- Индексы под постраничную историю (ORDER BY created_at DESC, trans_id DESC)
+CREATE INDEX transactions_sender_created_idx ON transactions (sender, created_at DESC, trans_id DESC);
+CREATE INDEX transactions_receiver_created_idx ON transactions (receiver, created_at DESC, trans_id DESC);
@@ -0,0 +1,19 @@
+-- Журнал двойной записи: каждая запись состоит из проводок,
+-- сумма которых равна нулю. Баланс счета - сумма его проводок.
+-- Счета: 'user:<user_id>' - кошельки, 'system:*' - системные счета.
+CREATE TABLE ledger_entries (
+    entry_id BIGSERIAL PRIMARY KEY,
+    kind VARCHAR(32) NOT NULL, -- signup_grant, transfer, purchase, adjustment
+    reference VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE ledger_postings (
+    posting_id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES ledger_entries(entry_id),
+    account VARCHAR(64) NOT NULL,
+    amount INTEGER NOT NULL CHECK (amount <> 0)
+);
+
+CREATE INDEX ledger_postings_account_idx ON ledger_postings (account);
+CREATE INDEX ledger_postings_entry_idx ON ledger_postings (entry_id);
@@ -0,0 +1,14 @@
+ALTER TABLE items DROP CONSTRAINT IF EXISTS items_type_fkey;
+
+ALTER TABLE store ALTER COLUMN "type" DROP DEFAULT;
+DROP SEQUENCE IF EXISTS store_type_seq;
+
+ALTER TABLE store
+    DROP CONSTRAINT store_pkey,
+    ADD CONSTRAINT store_type_key UNIQUE ("type"),
+    DROP CONSTRAINT IF EXISTS store_price_check,
+    DROP COLUMN IF EXISTS sort_order,
+    DROP COLUMN IF EXISTS active,
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS display_name,
+    DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,43 @@
+-- Каталог товаров. "type" - код, под которым предмет лежит в items,
+-- новые товары получают его из последовательности.
+ALTER TABLE store
+    ADD COLUMN slug VARCHAR(64),
+    ADD COLUMN display_name VARCHAR(128),
+    ADD COLUMN description TEXT NOT NULL DEFAULT '',
+    ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN sort_order INTEGER NOT NULL DEFAULT 0;
+
+UPDATE store SET slug = v.slug, display_name = v.display_name
+FROM (VALUES
+    (0, 't-shirt', 'T-shirt'),
+    (1, 'cup', 'Cup'),
+    (2, 'book', 'Book'),
+    (3, 'pen', 'Pen'),
+    (4, 'powerbank', 'Powerbank'),
+    (5, 'hoody', 'Hoody'),
+    (6, 'umbrella', 'Umbrella'),
+    (7, 'socks', 'Socks'),
+    (8, 'wallet', 'Wallet'),
+    (9, 'pink-hoody', 'Pink hoody')
+) AS v("type", slug, display_name)
+WHERE store."type" = v."type";
+
+-- товары, добавленные в базу вручную, получают имя по коду
+UPDATE store SET slug = 'item-' || "type", display_name = 'Item ' || "type"
+WHERE slug IS NULL;
+
+UPDATE store SET sort_order = "type";
+
+ALTER TABLE store
+    ALTER COLUMN slug SET NOT NULL,
+    ALTER COLUMN display_name SET NOT NULL,
+    ADD CONSTRAINT store_slug_key UNIQUE (slug),
+    ADD CONSTRAINT store_price_check CHECK (price > 0),
+    DROP CONSTRAINT store_type_key,
+    ADD CONSTRAINT store_pkey PRIMARY KEY ("type");
+
+CREATE SEQUENCE store_type_seq AS INTEGER OWNED BY store."type";
+ALTER TABLE store ALTER COLUMN "type" SET DEFAULT nextval('store_type_seq');
+SELECT setval('store_type_seq', (SELECT COALESCE(MAX("type"), 0) + 1 FROM store), false);
+
+ALTER TABLE items ADD CONSTRAINT items_type_fkey FOREIGN KEY ("type") REFERENCES store("type");
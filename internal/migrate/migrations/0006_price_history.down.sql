@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS price_history;
+ALTER TABLE store
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS price_version;
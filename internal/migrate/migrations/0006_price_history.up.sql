@@ -0,0 +1,17 @@
+ALTER TABLE store
+    ADD COLUMN price_version INTEGER NOT NULL DEFAULT 1, -- растет при каждой смене цены
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+-- История цен: одна строка на каждую версию цены товара.
+CREATE TABLE price_history (
+    history_id BIGSERIAL PRIMARY KEY,
+    "type" INTEGER NOT NULL REFERENCES store("type"),
+    price_version INTEGER NOT NULL,
+    price INTEGER NOT NULL,
+    changed_by UUID REFERENCES users(user_id) ON DELETE SET NULL, -- NULL для начального заполнения
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE ("type", price_version)
+);
+
+INSERT INTO price_history ("type", price_version, price)
+SELECT "type", price_version, price FROM store;
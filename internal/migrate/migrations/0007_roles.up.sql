@@ -0,0 +1,2 @@
+-- employee, store_manager, finance, admin
+ALTER TABLE users ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{employee}';
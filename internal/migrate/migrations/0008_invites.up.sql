@@ -0,0 +1,9 @@
+-- одноразовые приглашения для /api/register
+CREATE TABLE invites (
+    code VARCHAR(64) PRIMARY KEY,
+    created_by UUID NOT NULL REFERENCES users(user_id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_by UUID REFERENCES users(user_id),
+    used_at TIMESTAMPTZ
+);
@@ -0,0 +1,4 @@
+-- после отзыва токен сессии больше не принимается
+ALTER TABLE sessions ADD COLUMN revoked_at TIMESTAMPTZ;
+
+CREATE INDEX sessions_user_id_idx ON sessions (user_id);
@@ -0,0 +1,5 @@
+-- устройство, с которого выполнен вход
+ALTER TABLE sessions
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN ip TEXT NOT NULL DEFAULT '',
+    ADD COLUMN last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
@@ -0,0 +1,2 @@
+-- sha256 текущего refresh-токена, старые токены не хранятся
+ALTER TABLE sessions ADD COLUMN refresh_hash CHAR(64);
@@ -0,0 +1,10 @@
+-- ключи подписи JWT, private_key зашифрован ключом из secret конфига
+CREATE TABLE signing_keys (
+    kid UUID PRIMARY KEY,
+    alg VARCHAR(16) NOT NULL,
+    private_key BYTEA NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    active_from TIMESTAMPTZ NOT NULL,
+    retired_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ
+);
@@ -0,0 +1,7 @@
+ALTER TABLE transactions
+    DROP CONSTRAINT IF EXISTS transactions_check,
+    DROP CONSTRAINT IF EXISTS transactions_amount_check;
+
+ALTER TABLE users
+    DROP CONSTRAINT IF EXISTS users_amount_in_wallet_check,
+    DROP COLUMN IF EXISTS active;
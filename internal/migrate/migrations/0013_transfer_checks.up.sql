@@ -0,0 +1,10 @@
+-- Проверки действуют для новых строк. Старые данные не проверяются
+-- (NOT VALID), чтобы обновление не упало на истории до этих правил.
+ALTER TABLE users
+    -- неактивным пользователям нельзя переводить монеты
+    ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD CONSTRAINT users_amount_in_wallet_check CHECK (amount_in_wallet >= 0) NOT VALID;
+
+ALTER TABLE transactions
+    ADD CONSTRAINT transactions_amount_check CHECK (amount > 0) NOT VALID,
+    ADD CONSTRAINT transactions_check CHECK (sender <> receiver) NOT VALID;
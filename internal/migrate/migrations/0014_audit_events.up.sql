@@ -0,0 +1,48 @@
+-- Журнал действий: кто, что и над чем сделал. Записи связаны в цепочку:
+-- hash = sha256(prev_hash + поля записи), поэтому правку или удаление
+-- записи задним числом видно при проверке цепочки.
+CREATE TABLE audit_events (
+    event_id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL,
+    actor VARCHAR(64) NOT NULL DEFAULT '', -- user_id или оператор утилиты
+    action VARCHAR(64) NOT NULL,
+    target VARCHAR(255) NOT NULL DEFAULT '',
+    balance_before INTEGER,
+    balance_after INTEGER,
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    -- JSON, а не JSONB: хэш считается по тексту как есть
+    details JSON NOT NULL DEFAULT '{}',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX audit_events_actor_idx ON audit_events (actor, event_id DESC);
+CREATE INDEX audit_events_action_idx ON audit_events (action, event_id DESC);
+CREATE INDEX audit_events_target_idx ON audit_events (target, event_id DESC);
+
+-- Голова цепочки, одна строка. Запись события блокирует ее FOR UPDATE,
+-- так события выстраиваются в одну цепочку без гонок. По ней же видно,
+-- если хвост журнала удалили.
+CREATE TABLE audit_head (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    last_event_id BIGINT NOT NULL DEFAULT 0,
+    hash CHAR(64) NOT NULL
+);
+
+INSERT INTO audit_head (hash) VALUES (repeat('0', 64));
+
+-- Журнал только дописывается
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_no_update
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
@@ -0,0 +1,4 @@
+DELETE FROM ledger_postings
+WHERE entry_id IN (SELECT entry_id FROM ledger_entries WHERE kind = 'opening_balance');
+
+DELETE FROM ledger_entries WHERE kind = 'opening_balance';
@@ -0,0 +1,21 @@
+-- Балансы, накопленные до журнала, переносятся в него одной записью
+-- opening_balance на пользователя: system:opening -> user:<user_id>.
+-- Пользователи, у которых уже есть проводки, не трогаются: расхождение
+-- у них - настоящая ошибка, ее должна показать сверка.
+WITH opening AS (
+    SELECT u.user_id, u.amount_in_wallet,
+        nextval(pg_get_serial_sequence('ledger_entries', 'entry_id')) AS entry_id
+    FROM users u
+    WHERE u.amount_in_wallet <> 0
+    AND NOT EXISTS (
+        SELECT 1 FROM ledger_postings p WHERE p.account = 'user:' || u.user_id::text
+    )
+), entries AS (
+    INSERT INTO ledger_entries (entry_id, kind)
+    SELECT entry_id, 'opening_balance' FROM opening
+    RETURNING entry_id
+)
+INSERT INTO ledger_postings (entry_id, account, amount)
+SELECT o.entry_id, 'system:opening', -o.amount_in_wallet FROM opening o JOIN entries e USING (entry_id)
+UNION ALL
+SELECT o.entry_id, 'user:' || o.user_id::text, o.amount_in_wallet FROM opening o JOIN entries e USING (entry_id);
@@ -0,0 +1 @@
+ALTER TABLE sessions DROP COLUMN IF EXISTS rotated_refresh_hashes;
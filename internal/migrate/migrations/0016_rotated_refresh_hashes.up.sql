@@ -0,0 +1,4 @@
+-- sha256 замененных refresh-токенов сессии. Повторное предъявление
+-- такого токена - признак утечки, а неизвестный секрет - просто ошибка:
+-- session_id виден в access-токене, по нему одному сессию не отозвать.
+ALTER TABLE sessions ADD COLUMN rotated_refresh_hashes TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,14 @@
+-- События, которых еще нет в цепочке, откат не переживут (SET NOT NULL
+-- упадет): сначала дайте серверу досчитать цепочку.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP INDEX IF EXISTS audit_events_unchained_idx;
+ALTER TABLE audit_head DROP COLUMN IF EXISTS last_seq;
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS chain_seq,
+    ALTER COLUMN prev_hash SET NOT NULL,
+    ALTER COLUMN hash SET NOT NULL;
@@ -0,0 +1,40 @@
+-- Цепочку хэшей досчитывает фоновая задача (AuditDBRepository.Seal):
+-- запись события больше не блокирует audit_head, и переводы, покупки
+-- и входы не выстраиваются в очередь за одной строкой. Пока событие
+-- не попало в цепочку, хэшей у него нет.
+ALTER TABLE audit_events
+    ALTER COLUMN prev_hash DROP NOT NULL,
+    ALTER COLUMN hash DROP NOT NULL,
+    -- место в цепочке. Не event_id: транзакция с меньшим event_id
+    -- может закоммититься позже, чем цепочку досчитали дальше
+    ADD COLUMN chain_seq BIGINT UNIQUE;
+
+ALTER TABLE audit_head ADD COLUMN last_seq BIGINT NOT NULL DEFAULT 0;
+
+-- раньше события вставали в цепочку под блокировкой головы,
+-- поэтому их порядок в цепочке совпадает с event_id
+ALTER TABLE audit_events DISABLE TRIGGER audit_events_no_update;
+UPDATE audit_events SET chain_seq = event_id;
+ALTER TABLE audit_events ENABLE TRIGGER audit_events_no_update;
+UPDATE audit_head SET last_seq = last_event_id;
+
+CREATE INDEX audit_events_unchained_idx ON audit_events (event_id) WHERE hash IS NULL;
+
+-- Журнал по-прежнему только дописывается. Единственное изменение -
+-- один раз проставить хэши и место в цепочке, не трогая поля события.
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' THEN
+        IF OLD.hash IS NULL AND NEW.hash IS NOT NULL
+            AND (NEW.event_id, NEW.created_at, NEW.actor, NEW.action, NEW.target,
+                 NEW.balance_before, NEW.balance_after, NEW.request_id, NEW.ip, NEW.details::text)
+            IS NOT DISTINCT FROM
+                (OLD.event_id, OLD.created_at, OLD.actor, OLD.action, OLD.target,
+                 OLD.balance_before, OLD.balance_after, OLD.request_id, OLD.ip, OLD.details::text)
+        THEN
+            RETURN NEW;
+        END IF;
+    END IF;
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
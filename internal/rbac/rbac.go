@@ -0,0 +1,69 @@
+package rbac
+
+import "errors"
+
+// Роли пользователей.
+const (
+	RoleEmployee     = "employee"
+	RoleStoreManager = "store_manager"
+	RoleFinance      = "finance"
+	RoleAdmin        = "admin"
+)
+
+// Права, которые проверяются на маршрутах.
+type Permission string
+
+const (
+	PermCatalogManage  Permission = "catalog:manage"  // товары и цены
+	PermUsersManage    Permission = "users:manage"    // роли пользователей
+	PermSessionsRevoke Permission = "sessions:revoke" // отзыв чужих сессий
+	PermAuditRead      Permission = "audit:read"      // журнал аудита
+)
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+
+	// DefaultRoles - роли нового пользователя.
+	DefaultRoles = []string{RoleEmployee}
+
+	rolePermissions = map[string][]Permission{
+		RoleEmployee:     {},
+		RoleStoreManager: {PermCatalogManage},
+		RoleFinance:      {PermAuditRead},
+		RoleAdmin: {
+			PermCatalogManage, PermUsersManage, PermSessionsRevoke, PermAuditRead,
+		},
+	}
+)
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Проверяем список ролей перед сохранением.
+func ValidateRoles(roles []string) error {
+	if len(roles) == 0 {
+		return ErrUnknownRole
+	}
+	for _, r := range roles {
+		if !ValidRole(r) {
+			return ErrUnknownRole
+		}
+	}
+
+	return nil
+}
+
+// Есть ли право хотя бы у одной из ролей.
+func HasPermission(roles []string, perm Permission) bool {
+	for _, r := range roles {
+		for _, p := range rolePermissions[r] {
+			if p == perm {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,38 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name     string
+		roles    []string
+		perm     Permission
+		expected bool
+	}{
+		{"EmployeeCannotManageCatalog", []string{RoleEmployee}, PermCatalogManage, false},
+		{"StoreManagerManagesCatalog", []string{RoleEmployee, RoleStoreManager}, PermCatalogManage, true},
+		{"FinanceCannotManageCatalog", []string{RoleFinance}, PermCatalogManage, false},
+		{"FinanceCannotManageUsers", []string{RoleFinance}, PermUsersManage, false},
+		{"AdminHasEverything", []string{RoleAdmin}, PermSessionsRevoke, true},
+		{"FinanceReadsAudit", []string{RoleFinance}, PermAuditRead, true},
+		{"StoreManagerCannotReadAudit", []string{RoleStoreManager}, PermAuditRead, false},
+		{"UnknownRole", []string{"root"}, PermCatalogManage, false},
+		{"NoRoles", nil, PermAuditRead, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, HasPermission(tt.roles, tt.perm))
+		})
+	}
+}
+
+func TestValidateRoles(t *testing.T) {
+	assert.NoError(t, ValidateRoles([]string{RoleEmployee, RoleAdmin}))
+	assert.Equal(t, ErrUnknownRole, ValidateRoles([]string{"root"}))
+	assert.Equal(t, ErrUnknownRole, ValidateRoles(nil))
+}
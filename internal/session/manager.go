@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"proj/internal/audit"
+	"proj/internal/keyring"
+	"proj/internal/tracing"
 	"strings"
 	"time"
 
@@ -17,83 +20,103 @@ var (
 	ErrInternalDB       = errors.New("database error")
 	ErrInternalGo       = errors.New("golang lib errors")
 	ErrSingingToken     = errors.New("error signing token")
-
-	sessKey SessionKey = "sessionKey"
 )
 
-type SessionKey string
-
 const (
 	FieldSessionID = "session_id"
+	FieldUser      = "user"
+	FieldUserID    = "id"
+	FieldLogin     = "login"
+	FieldRoles     = "roles"
+
+	bearerScheme = "Bearer"
 )
 
 type SessionManager struct {
-	DB          *sql.DB
-	Logger      *zap.SugaredLogger
-	tokenSecret string
+	DB     *sql.DB
+	Logger *zap.SugaredLogger
+	// Ключи подписи access-токенов, ротируются снаружи
+	Keys *keyring.Keyring
+
+	// Время жизни access-токена, сессия живет endTimeDur
+	// и продлевается refresh-токеном.
+	AccessTTL time.Duration
 }
 
-func NewSessionManager(db *sql.DB, l *zap.SugaredLogger, secret string) *SessionManager {
+func NewSessionManager(db *sql.DB, l *zap.SugaredLogger, keys *keyring.Keyring) *SessionManager {
 	return &SessionManager{
-		DB:          db,
-		Logger:      l,
-		tokenSecret: secret,
+		DB:        db,
+		Logger:    l,
+		Keys:      keys,
+		AccessTTL: DefaultAccessTTL,
 	}
 }
 
+// Контекст берется из запроса: Check читает его из r, остальным
+// методам передается явно.
 type SessionManagerRepo interface {
 	Check(r *http.Request) (*Session, error)
-	Create(w http.ResponseWriter, userID string, login string) (*Session, string, error)
-
-	GetSecret() string
+	Create(ctx context.Context, w http.ResponseWriter, userID string, login string, roles []string, dev Device) (*Session, Tokens, error)
+	Refresh(ctx context.Context, refreshToken string) (*Session, Tokens, error)
+	List(ctx context.Context, userID string) ([]Session, error)
+	Revoke(ctx context.Context, userID, sessionID string) error
+	RevokeAll(ctx context.Context, userID string) (int64, error)
 }
 
+// Единственное место, где проверяется access-токен. Токен берется из
+// заголовка "Authorization: Bearer <token>", схема без учета регистра.
 func (sm *SessionManager) Check(r *http.Request) (*Session, error) {
-	// Получаем значение заголовка Authorization
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		sm.Logger.Errorf("%v", ErrNoAuth)
-		return nil, ErrNoAuth
-	}
+	ctx, span := tracing.Start(r.Context(), "session.Check")
+	defer span.End()
 
-	const bearerPrefix = "Bearer "
-	if !strings.HasPrefix(authHeader, bearerPrefix) {
-		return nil, ErrNoAuth
+	sess, err := sm.check(r.WithContext(ctx))
+	tracing.Fail(span, err)
+	return sess, err
+}
+
+func (sm *SessionManager) check(r *http.Request) (*Session, error) {
+	tokenString, ok := bearerToken(r)
+	if !ok {
+		sm.Logger.Infof("%v", ErrTokenMissing)
+		return nil, ErrTokenMissing
 	}
-	tokenString := strings.TrimPrefix(authHeader, bearerPrefix)
-	// Распарсиваем токен, используя секретный ключ
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			sm.Logger.Errorf("%v", ErrUnexpectedMethod)
-			return nil, ErrUnexpectedMethod
-		}
-		return []byte(sm.tokenSecret), nil
-	})
+
+	// Распарсиваем токен, ключ проверки выбирается по kid
+	token, err := jwt.Parse(tokenString, sm.Keyfunc)
 	if err != nil || !token.Valid {
-		sm.Logger.Errorf("%v. More details: %v", ErrNoAuth, err)
-		return nil, ErrNoAuth
+		// Истекший токен клиент может обновить, остальное - только перелогин
+		var ve *jwt.ValidationError
+		if errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorExpired != 0 {
+			sm.Logger.Infof("%v. More details: %v", ErrTokenExpired, err)
+			return nil, ErrTokenExpired
+		}
+
+		sm.Logger.Errorf("%v. More details: %v", ErrTokenMalformed, err)
+		return nil, ErrTokenMalformed
 	}
 
 	// Извлекаем claims из токена
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || claims[FieldSessionID] == nil {
-		sm.Logger.Errorf("%v. More details: %v", ErrNoAuth, err)
-		return nil, ErrNoAuth
+	if !ok {
+		sm.Logger.Errorf("%v. More details: unexpected claims type", ErrTokenMalformed)
+		return nil, ErrTokenMalformed
 	}
 	sessionID, ok := claims[FieldSessionID].(string)
 	if !ok {
-		sm.Logger.Errorf("%v. More details: %v", ErrNoAuth, err)
-		return nil, ErrNoAuth
+		sm.Logger.Errorf("%v. More details: no %s claim", ErrTokenMalformed, FieldSessionID)
+		return nil, ErrTokenMalformed
 	}
 
 	// Проверяем наличие сессии в базе данных
 	var sess Session
 	query := `
-	SELECT session_id, user_id, start_time, end_time 
+	SELECT session_id, user_id, start_time, end_time, revoked_at, last_seen_at
 	FROM sessions 
 	WHERE session_id = $1
 	`
-	err = sm.DB.QueryRow(query, sessionID).Scan(&sess.ID, &sess.UserID, &sess.StartTime, &sess.EndTime)
+	err = sm.DB.QueryRowContext(r.Context(), query, sessionID).Scan(
+		&sess.ID, &sess.UserID, &sess.StartTime, &sess.EndTime, &sess.RevokedAt, &sess.LastSeenAt,
+	)
 	if errors.Is(err, sql.ErrNoRows) {
 		sm.Logger.Errorf("%v. More details: %v", ErrNoAuth, err)
 		return nil, ErrNoAuth
@@ -102,102 +125,359 @@ func (sm *SessionManager) Check(r *http.Request) (*Session, error) {
 		return nil, ErrInternalDB
 	}
 
+	// Отозванная сессия - то же, что и отсутствующая
+	if sess.RevokedAt != nil {
+		sm.Logger.Infof("%v. More details: session - %s -", ErrRevoked, sess.ID)
+		return nil, ErrRevoked
+	}
+
 	// Проверяем, не истекло ли время действия сессии
 	if time.Now().After(sess.EndTime) {
-		sm.Logger.Errorf("%v. More details: %v", ErrNoAuth, err)
-		return nil, ErrNoAuth
+		sm.Logger.Infof("%v. More details: session - %s -", ErrSessionExpired, sess.ID)
+		return nil, ErrSessionExpired
 	}
 
+	sess.Login, sess.Roles = userFromClaims(claims)
+	sm.touch(r.Context(), &sess)
+
 	return &sess, nil
 }
 
-type AuthResponse struct {
-	Token string `json:"token"`
+// Отмечаем активность сессии. Ошибка тут не повод отказать
+// в запросе, поэтому только логируем.
+func (sm *SessionManager) touch(ctx context.Context, sess *Session) {
+	if time.Since(sess.LastSeenAt) < lastSeenGranularity {
+		return
+	}
+
+	query := `UPDATE sessions SET last_seen_at = NOW() WHERE session_id = $1`
+	if _, err := sm.DB.ExecContext(ctx, query, sess.ID); err != nil {
+		sm.Logger.Warnf("%v. More details: %v", ErrInternalDB, err)
+		return
+	}
+	sess.LastSeenAt = time.Now()
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, bearerScheme) {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
 }
 
-// Немного сложная логика с удалением сессии сделал для того,
-// чтобы сессии не скапливались и не засоряли память, а эффективно
-// "существовали" - звучит жутко...
+// Логин и роли лежат в claims["user"], user_id берем из сессии в базе.
+func userFromClaims(claims jwt.MapClaims) (login string, roles []string) {
+	u, ok := claims[FieldUser].(map[string]interface{})
+	if !ok {
+		return "", nil
+	}
+	login, _ = u[FieldLogin].(string)
+
+	raw, ok := u[FieldRoles].([]interface{})
+	if !ok {
+		return login, nil
+	}
+
+	roles = make([]string, 0, len(raw))
+	for _, r := range raw {
+		if s, ok := r.(string); ok {
+			roles = append(roles, s)
+		}
+	}
+
+	return login, roles
+}
+
+// Каждый вход - отдельная сессия, так телефон и ноутбук
+// можно разлогинить независимо. Заодно чистим просроченные
+// сессии пользователя, чтобы они не скапливались. Событие входа
+// пишется в одной транзакции с сессией: сессия без записи в
+// журнале, как и запись без сессии, не остается.
 func (sm *SessionManager) Create(
+	ctx context.Context,
 	w http.ResponseWriter,
 	userID string,
 	login string,
-) (*Session, string, error) {
-	sess := &Session{}
+	roles []string,
+	dev Device,
+) (*Session, Tokens, error) {
+	ctx, span := tracing.Start(ctx, "session.Create")
+	defer span.End()
 
-	// Проверяем, существует ли уже сессия и она не просрочена
-	query := `
-    SELECT session_id, user_id, start_time, end_time
-    FROM sessions
-    WHERE user_id = $1
-    `
-	err := sm.DB.QueryRow(query, userID).Scan(&sess.ID, &sess.UserID, &sess.StartTime, &sess.EndTime)
+	sess, tokens, err := sm.create(ctx, w, userID, login, roles, dev)
+	tracing.Fail(span, err)
+	return sess, tokens, err
+}
+
+func (sm *SessionManager) create(
+	ctx context.Context,
+	w http.ResponseWriter,
+	userID string,
+	login string,
+	roles []string,
+	dev Device,
+) (*Session, Tokens, error) {
+	sess := NewSession(userID, dev)
+
+	var tokens Tokens
+	refresh, refreshHash, err := newRefreshToken(sess.ID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			// Если сессия не найдена, создаем новую
-			sess = NewSession(userID)
-		} else {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalGo, err)
+		return nil, Tokens{}, ErrInternalGo
+	}
+
+	err = sm.inTx(ctx, func(tx *sql.Tx) error {
+		query := `DELETE FROM sessions WHERE user_id = $1 AND end_time < NOW()`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		query = `
+		INSERT INTO sessions (session_id, user_id, start_time, end_time, user_agent, ip, last_seen_at, refresh_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`
+		_, err := tx.ExecContext(ctx, query,
+			sess.ID, sess.UserID, sess.StartTime, sess.EndTime, sess.UserAgent, sess.IP, sess.LastSeenAt, refreshHash,
+		)
+		if err != nil {
 			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-			return nil, "", ErrInternalDB
+			return ErrInternalDB
 		}
-	} else {
-		// Если сессия найдена, проверяем, не просрочена ли она
-		if sess.EndTime.Before(time.Now()) {
-			// Если сессия просрочена, удаляем её
-			query = `DELETE FROM sessions WHERE session_id = $1`
-			_, err := sm.DB.Exec(query, sess.ID)
-			if err != nil {
-				sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-				return nil, "", ErrInternalDB
-			}
-
-			// Создаем новую сессию
-			sess = NewSession(userID)
-		} else { // Если все ок, просто вернем ее
-			return sess, generateJWT(sm, sess, login), nil
+
+		ev := audit.NewEvent(ctx, audit.ActionLogin, login).
+			WithActor(userID).
+			WithDetails(map[string]interface{}{"sessionId": sess.ID})
+		if err := sm.record(ctx, tx, ev); err != nil {
+			return err
 		}
+
+		// без токена сессия бесполезна: не подписали - откатываем ее
+		sess.Roles = roles
+		tokens, err = sm.issueTokens(sess, login, refresh)
+		return err
+	})
+	if err != nil {
+		return nil, Tokens{}, err
 	}
 
-	// Вставляем новую сессию в базу данных
-	query = `
-	INSERT INTO sessions (session_id, user_id, start_time, end_time)
-    VALUES ($1, $2, $3, $4)
+	return sess, tokens, nil
+}
+
+// Живые сессии пользователя, последние активные первыми.
+func (sm *SessionManager) List(ctx context.Context, userID string) ([]Session, error) {
+	ctx, span := tracing.Start(ctx, "session.List")
+	defer span.End()
+
+	sessions, err := sm.list(ctx, userID)
+	tracing.Fail(span, err)
+	return sessions, err
+}
+
+func (sm *SessionManager) list(ctx context.Context, userID string) ([]Session, error) {
+	query := `
+	SELECT session_id, user_id, start_time, end_time, user_agent, ip, last_seen_at
+	FROM sessions
+	WHERE user_id = $1 AND revoked_at IS NULL AND end_time > NOW()
+	ORDER BY last_seen_at DESC
 	`
-	_, err = sm.DB.Exec(query, sess.ID, sess.UserID, sess.StartTime, sess.EndTime)
+	rows, err := sm.DB.QueryContext(ctx, query, userID)
 	if err != nil {
 		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return nil, "", ErrInternalDB
+		return nil, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var s Session
+		err = rows.Scan(&s.ID, &s.UserID, &s.StartTime, &s.EndTime, &s.UserAgent, &s.IP, &s.LastSeenAt)
+		if err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, ErrInternalDB
+		}
+		sessions = append(sessions, s)
 	}
 
-	return sess, generateJWT(sm, sess, login), nil
+	if err = rows.Err(); err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, ErrInternalDB
+	}
+
+	return sessions, nil
 }
 
-func generateJWT(sm *SessionManager, sess *Session, login string) string {
+// Access-токен живет AccessTTL, но не дольше самой сессии. Подпись
+// падает, если в keyring нет активного ключа, например синхронизация
+// ключей отстала от окна их действия.
+func generateJWT(sm *SessionManager, sess *Session, login string) (string, time.Time, error) {
+	now := time.Now()
+	exp := now.Add(sm.AccessTTL)
+	if exp.After(sess.EndTime) {
+		exp = sess.EndTime
+	}
+
 	// Генерация JWT токена
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user": map[string]interface{}{
-			"login": login,
-			"id":    sess.UserID,
+	token, err := sm.Keys.Sign(jwt.MapClaims{
+		FieldUser: map[string]interface{}{
+			FieldLogin:  login,
+			FieldUserID: sess.UserID,
+			FieldRoles:  sess.Roles,
 		},
-		"iat":          sess.StartTime.Unix(),
-		"exp":          sess.EndTime.Unix(),
+		"iat":          now.Unix(),
+		"exp":          exp.Unix(),
 		FieldSessionID: sess.ID,
 	})
-
-	token, err := t.SignedString([]byte(sm.tokenSecret))
 	if err != nil {
 		sm.Logger.Errorf("%v. More details: %v", ErrSingingToken, err)
-		return ""
+		return "", time.Time{}, ErrSingingToken
 	}
 
-	return token
+	return token, exp, nil
 }
 
-func (sm *SessionManager) GetSecret() string {
-	return sm.tokenSecret
+func (sm *SessionManager) issueTokens(sess *Session, login, refresh string) (Tokens, error) {
+	access, exp, err := generateJWT(sm, sess, login)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		Access:    access,
+		Refresh:   refresh,
+		ExpiresAt: exp,
+	}, nil
+}
+
+// Отзыв одной сессии пользователя. Чужую сессию
+// отозвать нельзя - для нее вернется ErrNoAuth.
+func (sm *SessionManager) Revoke(ctx context.Context, userID, sessionID string) error {
+	ctx, span := tracing.Start(ctx, "session.Revoke")
+	defer span.End()
+
+	err := sm.revoke(ctx, userID, sessionID)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (sm *SessionManager) revoke(ctx context.Context, userID, sessionID string) error {
+	err := sm.inTx(ctx, func(tx *sql.Tx) error {
+		query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE session_id = $1 AND user_id = $2 AND revoked_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, sessionID, userID)
+		if err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+		if n == 0 {
+			return ErrNoAuth
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionRevoke, userID).
+			WithDetails(map[string]interface{}{"sessionId": sessionID})
+		return sm.record(ctx, tx, ev)
+	})
+	if err != nil {
+		return err
+	}
+
+	sm.Logger.Infof("session - %s - revoked", sessionID)
+	return nil
+}
+
+// Отзыв всех живых сессий пользователя, возвращает их количество.
+func (sm *SessionManager) RevokeAll(ctx context.Context, userID string) (int64, error) {
+	ctx, span := tracing.Start(ctx, "session.RevokeAll")
+	defer span.End()
+
+	n, err := sm.revokeAll(ctx, userID)
+	tracing.Fail(span, err)
+	return n, err
+}
+
+func (sm *SessionManager) revokeAll(ctx context.Context, userID string) (int64, error) {
+	var n int64
+	err := sm.inTx(ctx, func(tx *sql.Tx) error {
+		query := `
+		UPDATE sessions
+		SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+		`
+		res, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		n, err = res.RowsAffected()
+		if err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return ErrInternalDB
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionRevokeAll, userID).
+			WithDetails(map[string]interface{}{"revoked": n})
+		return sm.record(ctx, tx, ev)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sm.Logger.Infof("%d sessions of userID - %s - revoked", n, userID)
+	return n, nil
+}
+
+// Изменение сессий и событие аудита о нем сохраняются вместе.
+func (sm *SessionManager) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := sm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	return nil
+}
+
+// Ошибки журнала аудита для клиента - такие же ошибки базы.
+func (sm *SessionManager) record(ctx context.Context, tx *sql.Tx, ev audit.Event) error {
+	if _, err := audit.Record(ctx, tx, ev, sm.Logger); err != nil {
+		return ErrInternalDB
+	}
+	return nil
 }
 
-func ContextWithSession(ctx context.Context, s *Session) context.Context {
-	// создаем новый контекст с нашим ключом и сессией
-	return context.WithValue(ctx, sessKey, s)
+// Ключ для проверки подписи токена по его kid.
+func (sm *SessionManager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	return sm.Keys.Keyfunc(t)
 }
@@ -1,10 +1,14 @@
 package session
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"proj/internal/audit"
+	"proj/internal/keyring"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +18,8 @@ import (
 	"go.uber.org/zap"
 )
 
+var checkCols = []string{"session_id", "user_id", "start_time", "end_time", "revoked_at", "last_seen_at"}
+
 func newTestSessionManager(t *testing.T) (*SessionManager, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -22,7 +28,12 @@ func newTestSessionManager(t *testing.T) (*SessionManager, sqlmock.Sqlmock) {
 
 	logger := zap.NewNop().Sugar()
 
-	return NewSessionManager(db, logger, "test-secret"), mock
+	k, err := keyring.Generate(keyring.AlgEdDSA)
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+
+	return NewSessionManager(db, logger, keyring.New(k)), mock
 }
 
 func TestSessionManager_Check(t *testing.T) {
@@ -36,10 +47,10 @@ func TestSessionManager_Check(t *testing.T) {
 			name:  "Success",
 			token: "valid-token",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE session_id = \$1`).
+				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time, revoked_at, last_seen_at FROM sessions WHERE session_id = \$1`).
 					WithArgs("session1").
-					WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "start_time", "end_time"}).
-						AddRow("session1", "user1", time.Now(), time.Now().Add(endTimeDur)))
+					WillReturnRows(sqlmock.NewRows(checkCols).
+						AddRow("session1", "user1", time.Now(), time.Now().Add(endTimeDur), nil, time.Now()))
 			},
 			expectedError: nil,
 		},
@@ -49,13 +60,25 @@ func TestSessionManager_Check(t *testing.T) {
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				// Нет запросов к базе данных, так как токен невалидный
 			},
-			expectedError: ErrNoAuth,
+			expectedError: ErrTokenMalformed,
+		},
+		{
+			name:          "MissingToken",
+			token:         "",
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrTokenMissing,
+		},
+		{
+			name:          "ExpiredToken",
+			token:         "expired-token",
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrTokenExpired,
 		},
 		{
 			name:  "SessionNotFound",
 			token: "valid-token",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE session_id = \$1`).
+				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time, revoked_at, last_seen_at FROM sessions WHERE session_id = \$1`).
 					WithArgs("session1").
 					WillReturnError(sql.ErrNoRows)
 			},
@@ -65,18 +88,29 @@ func TestSessionManager_Check(t *testing.T) {
 			name:  "SessionExpired",
 			token: "valid-token",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE session_id = \$1`).
+				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time, revoked_at, last_seen_at FROM sessions WHERE session_id = \$1`).
 					WithArgs("session1").
-					WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "start_time", "end_time"}).
-						AddRow("session1", "user1", time.Now().Add(-2*endTimeDur), time.Now().Add(-endTimeDur)))
+					WillReturnRows(sqlmock.NewRows(checkCols).
+						AddRow("session1", "user1", time.Now().Add(-2*endTimeDur), time.Now().Add(-endTimeDur), nil, time.Now()))
 			},
-			expectedError: ErrNoAuth,
+			expectedError: ErrSessionExpired,
+		},
+		{
+			name:  "SessionRevoked",
+			token: "valid-token",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time, revoked_at, last_seen_at FROM sessions WHERE session_id = \$1`).
+					WithArgs("session1").
+					WillReturnRows(sqlmock.NewRows(checkCols).
+						AddRow("session1", "user1", time.Now(), time.Now().Add(endTimeDur), time.Now(), time.Now()))
+			},
+			expectedError: ErrRevoked,
 		},
 		{
 			name:  "DatabaseError",
 			token: "valid-token",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE session_id = \$1`).
+				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time, revoked_at, last_seen_at FROM sessions WHERE session_id = \$1`).
 					WithArgs("session1").
 					WillReturnError(errors.New("database error"))
 			},
@@ -91,14 +125,26 @@ func TestSessionManager_Check(t *testing.T) {
 
 			// Создаем http с токеном
 			req := httptest.NewRequest(http.MethodGet, "/", nil)
-			req.Header.Set("Authorization", "Bearer "+tt.token)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
 
 			// Генерация валидного токена для теста
 			if tt.token == "valid-token" {
-				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+				tokenString, _ := sm.Keys.Sign(jwt.MapClaims{
+					FieldSessionID: "session1",
+					FieldUser: map[string]interface{}{
+						FieldLogin: "username",
+						FieldRoles: []string{"employee", "admin"},
+					},
+				})
+				req.Header.Set("Authorization", "Bearer "+tokenString)
+			}
+			if tt.token == "expired-token" {
+				tokenString, _ := sm.Keys.Sign(jwt.MapClaims{
 					FieldSessionID: "session1",
+					"exp":          time.Now().Add(-time.Minute).Unix(),
 				})
-				tokenString, _ := token.SignedString([]byte(sm.GetSecret()))
 				req.Header.Set("Authorization", "Bearer "+tokenString)
 			}
 
@@ -110,6 +156,8 @@ func TestSessionManager_Check(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, sess)
+				assert.Equal(t, "username", sess.Login)
+				assert.Equal(t, []string{"employee", "admin"}, sess.Roles)
 			}
 
 			assert.NoError(t, mock.ExpectationsWereMet())
@@ -117,11 +165,77 @@ func TestSessionManager_Check(t *testing.T) {
 	}
 }
 
+func TestSessionManager_Check_TouchesLastSeen(t *testing.T) {
+	sm, mock := newTestSessionManager(t)
+
+	mock.ExpectQuery(`FROM sessions WHERE session_id = \$1`).
+		WithArgs("session1").
+		WillReturnRows(sqlmock.NewRows(checkCols).
+			AddRow("session1", "user1", time.Now(), time.Now().Add(endTimeDur), nil, time.Now().Add(-time.Hour)))
+	mock.ExpectExec(`UPDATE sessions SET last_seen_at = NOW\(\) WHERE session_id = \$1`).
+		WithArgs("session1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	tokenString, _ := sm.Keys.Sign(jwt.MapClaims{FieldSessionID: "session1"})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	sess, err := sm.Check(req)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), sess.LastSeenAt, time.Second)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// Токен, подписанный ключом не из keyring, не принимается.
+func TestSessionManager_Check_ForeignKey(t *testing.T) {
+	sm, mock := newTestSessionManager(t)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{FieldSessionID: "session1"})
+	tokenString, _ := token.SignedString([]byte("leaked-secret"))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenString)
+
+	_, err := sm.Check(req)
+	assert.Equal(t, ErrTokenMalformed, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := map[string]struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		"bearer":           {header: "Bearer abc", token: "abc", ok: true},
+		"lower case":       {header: "bearer abc", token: "abc", ok: true},
+		"no scheme":        {header: "abc"},
+		"other scheme":     {header: "Basic abc"},
+		"empty token":      {header: "Bearer  "},
+		"no header at all": {},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+
+			token, ok := bearerToken(req)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.token, token)
+		})
+	}
+}
+
 func TestSessionManager_Create(t *testing.T) {
+	const insertSession = `INSERT INTO sessions \(session_id, user_id, start_time, end_time, user_agent, ip, last_seen_at, refresh_hash\) VALUES \(\$1, \$2, \$3, \$4, \$5, \$6, \$7, \$8\)`
+
 	tests := []struct {
 		name          string
 		userID        string
 		login         string
+		noSigningKey  bool
 		mockDBSetup   func(sqlmock.Sqlmock)
 		expectedError error
 	}{
@@ -130,71 +244,97 @@ func TestSessionManager_Create(t *testing.T) {
 			userID: "user1",
 			login:  "login1",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				//  запрос для проверки существующей сессии
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE user_id = \$1`).
+				mock.ExpectBegin()
+				// чистка просроченных сессий пользователя
+				mock.ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND end_time < NOW\(\)`).
 					WithArgs("user1").
-					WillReturnError(sql.ErrNoRows)
+					WillReturnResult(sqlmock.NewResult(0, 1))
 
-				//  запрос для вставки новой сессии
-				mock.ExpectExec(`INSERT INTO sessions \(session_id, user_id, start_time, end_time\) VALUES \(\$1, \$2, \$3, \$4\)`).
-					WithArgs(sqlmock.AnyArg(), "user1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+				mock.ExpectExec(insertSession).
+					WithArgs(sqlmock.AnyArg(), "user1", sqlmock.AnyArg(), sqlmock.AnyArg(),
+						"curl/8.0", "10.0.0.1", sqlmock.AnyArg(), sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectAudit(mock, audit.ActionLogin)
+				mock.ExpectCommit()
 			},
 			expectedError: nil,
 		},
 		{
-			name:   "SuccessExistingSession",
+			name:   "DatabaseErrorOnCleanup",
 			userID: "user1",
 			login:  "login1",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE user_id = \$1`).
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND end_time < NOW\(\)`).
 					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "start_time", "end_time"}).
-						AddRow("session1", "user1", time.Now(), time.Now().Add(endTimeDur)))
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
 			},
-			expectedError: nil,
+			expectedError: ErrInternalDB,
 		},
 		{
-			name:   "DatabaseError",
+			name:   "DatabaseErrorOnInsert",
 			userID: "user1",
 			login:  "login1",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				//  запрос для проверки существующей сессии
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE user_id = \$1`).
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND end_time < NOW\(\)`).
 					WithArgs("user1").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertSession).
 					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
 			},
 			expectedError: ErrInternalDB,
 		},
 		{
-			name:   "SuccessExpiredSession",
+			// сессия не остается без события входа
+			name:   "AuditErrorRollsBack",
 			userID: "user1",
 			login:  "login1",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery(`SELECT session_id, user_id, start_time, end_time FROM sessions WHERE user_id = \$1`).
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND end_time < NOW\(\)`).
 					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "start_time", "end_time"}).
-						AddRow("session1", "user1", time.Now().Add(-2*endTimeDur), time.Now().Add(-endTimeDur))) // Просроченная сессия
-
-				mock.ExpectExec(`DELETE FROM sessions WHERE session_id = \$1`).
-					WithArgs("session1").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertSession).
 					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				mock.ExpectExec(`INSERT INTO sessions \(session_id, user_id, start_time, end_time\) VALUES \(\$1, \$2, \$3, \$4\)`).
-					WithArgs(sqlmock.AnyArg(), "user1", sqlmock.AnyArg(), sqlmock.AnyArg()).
+				mock.ExpectQuery(`INSERT INTO audit_events`).
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrInternalDB,
+		},
+		{
+			// нет активного ключа - сессия без токена не создается
+			name:         "NoSigningKeyRollsBack",
+			userID:       "user1",
+			login:        "login1",
+			noSigningKey: true,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM sessions WHERE user_id = \$1 AND end_time < NOW\(\)`).
+					WithArgs("user1").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(insertSession).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectAudit(mock, audit.ActionLogin)
+				mock.ExpectRollback()
 			},
-			expectedError: nil,
+			expectedError: ErrSingingToken,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			sm, mock := newTestSessionManager(t)
+			if tt.noSigningKey {
+				sm.Keys = keyring.New()
+			}
 			tt.mockDBSetup(mock)
 
-			// Вызываем метод Create
-			sess, token, err := sm.Create(httptest.NewRecorder(), tt.userID, tt.login)
+			dev := Device{UserAgent: "curl/8.0", IP: "10.0.0.1"}
+			sess, tokens, err := sm.Create(context.Background(), httptest.NewRecorder(), tt.userID, tt.login, []string{"employee"}, dev)
 
 			// Проверяем результаты
 			if tt.expectedError != nil {
@@ -203,7 +343,14 @@ func TestSessionManager_Create(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, sess)
-				assert.NotEmpty(t, token)
+				assert.NotEmpty(t, tokens.Access)
+				_, err = jwt.Parse(tokens.Access, sm.Keyfunc)
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(tokens.Refresh, sess.ID+refreshSep))
+				// access-токен короткий, сессия - нет
+				assert.WithinDuration(t, time.Now().Add(DefaultAccessTTL), tokens.ExpiresAt, time.Minute)
+				assert.Equal(t, []string{"employee"}, sess.Roles)
+				assert.Equal(t, "10.0.0.1", sess.IP)
 			}
 
 			// Проверяем, что все ожидания мока выполнены
@@ -211,3 +358,80 @@ func TestSessionManager_Create(t *testing.T) {
 		})
 	}
 }
+
+func TestSessionManager_List(t *testing.T) {
+	sm, mock := newTestSessionManager(t)
+
+	mock.ExpectQuery(`FROM sessions WHERE user_id = \$1 AND revoked_at IS NULL AND end_time > NOW\(\) ORDER BY last_seen_at DESC`).
+		WithArgs("user1").
+		WillReturnRows(sqlmock.NewRows([]string{"session_id", "user_id", "start_time", "end_time", "user_agent", "ip", "last_seen_at"}).
+			AddRow("session2", "user1", time.Now(), time.Now().Add(endTimeDur), "phone", "10.0.0.2", time.Now()).
+			AddRow("session1", "user1", time.Now(), time.Now().Add(endTimeDur), "laptop", "10.0.0.1", time.Now().Add(-time.Hour)))
+
+	sessions, err := sm.List(context.Background(), "user1")
+	assert.NoError(t, err)
+	assert.Len(t, sessions, 2)
+	assert.Equal(t, "phone", sessions[0].UserAgent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeviceFromRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth", nil)
+	req.RemoteAddr = "10.0.0.1:53211"
+	req.Header.Set("User-Agent", "curl/8.0")
+
+	assert.Equal(t, Device{UserAgent: "curl/8.0", IP: "10.0.0.1"}, DeviceFromRequest(req))
+}
+
+// Запись события аудита в конце транзакции.
+func expectAudit(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow(1))
+}
+
+func TestSessionManager_Revoke(t *testing.T) {
+	sm, mock := newTestSessionManager(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE session_id = \$1 AND user_id = \$2 AND revoked_at IS NULL`).
+		WithArgs("session1", "user1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActionRevoke)
+	mock.ExpectCommit()
+	// чужая или уже отозванная сессия
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE session_id = \$1 AND user_id = \$2 AND revoked_at IS NULL`).
+		WithArgs("session1", "user2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.NoError(t, sm.Revoke(context.Background(), "user1", "session1"))
+	assert.Equal(t, ErrNoAuth, sm.Revoke(context.Background(), "user2", "session1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSessionManager_RevokeAll(t *testing.T) {
+	sm, mock := newTestSessionManager(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE user_id = \$1 AND revoked_at IS NULL`).
+		WithArgs("user1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	expectAudit(mock, audit.ActionRevokeAll)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE user_id = \$1 AND revoked_at IS NULL`).
+		WithArgs("user2").
+		WillReturnError(errors.New("database error"))
+	mock.ExpectRollback()
+
+	n, err := sm.RevokeAll(context.Background(), "user1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+
+	_, err = sm.RevokeAll(context.Background(), "user2")
+	assert.Equal(t, ErrInternalDB, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
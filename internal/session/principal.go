@@ -0,0 +1,38 @@
+package session
+
+import "context"
+
+type principalKey struct{}
+
+// Аутентифицированный пользователь запроса. Middleware кладет его
+// в контекст после проверки токена, хендлеры читают через FromContext.
+type Principal struct {
+	UserID    string
+	Login     string
+	SessionID string
+	Roles     []string
+}
+
+func (s *Session) Principal() Principal {
+	return Principal{
+		UserID:    s.UserID,
+		Login:     s.Login,
+		SessionID: s.ID,
+		Roles:     s.Roles,
+	}
+}
+
+func ContextWithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// Пользователь запроса. ok == false, если запрос не прошел
+// через middleware авторизации или в токене нет user_id.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(Principal)
+	if !ok || p.UserID == "" || p.SessionID == "" {
+		return Principal{}, false
+	}
+
+	return p, true
+}
@@ -0,0 +1,22 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromContext(t *testing.T) {
+	sess := &Session{ID: "session1", UserID: "user1", Login: "username", Roles: []string{"admin"}}
+
+	p, ok := FromContext(ContextWithPrincipal(context.Background(), sess.Principal()))
+	assert.True(t, ok)
+	assert.Equal(t, Principal{UserID: "user1", Login: "username", SessionID: "session1", Roles: []string{"admin"}}, p)
+
+	_, ok = FromContext(context.Background())
+	assert.False(t, ok)
+
+	_, ok = FromContext(ContextWithPrincipal(context.Background(), Principal{SessionID: "session1"}))
+	assert.False(t, ok)
+}
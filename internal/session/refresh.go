@@ -0,0 +1,194 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"proj/internal/tracing"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	refreshSecretBytes = 32
+	refreshSep         = "."
+
+	// Сколько последних замененных refresh-токенов помним для
+	// поиска повторов: при access-токене на 15 минут это около суток.
+	// Более старый токен просто не примут, но сессию не отзовут.
+	rotatedRefreshKept = 100
+)
+
+var (
+	ErrRefreshReused = errors.New("refresh token reuse detected")
+)
+
+/*
+Refresh-токен имеет вид <session_id>.<секрет>. Для клиента он
+непрозрачен, а нам позволяет найти сессию, даже если секрет
+уже устарел. В базе храним только sha256 от секрета.
+*/
+func newRefreshToken(sessionID string) (token, hash string, err error) {
+	b := make([]byte, refreshSecretBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return sessionID + refreshSep + secret, hashRefreshSecret(secret), nil
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func parseRefreshToken(token string) (sessionID, secret string, ok bool) {
+	sessionID, secret, ok = strings.Cut(token, refreshSep)
+	if !ok || secret == "" {
+		return "", "", false
+	}
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return "", "", false
+	}
+
+	return sessionID, secret, true
+}
+
+/*
+Обмен refresh-токена на новую пару. Старый refresh-токен после
+этого недействителен. Если кто-то предъявил уже замененный токен,
+значит он утек: отзываем всю сессию, и вору, и владельцу
+придется войти заново.
+
+Секрет, который сессии никогда не выдавался, - просто ErrNoAuth:
+session_id виден в access-токене, и отзыв по любому неверному
+секрету позволил бы разлогинить чужую сессию.
+*/
+func (sm *SessionManager) Refresh(ctx context.Context, refreshToken string) (*Session, Tokens, error) {
+	ctx, span := tracing.Start(ctx, "session.Refresh")
+	defer span.End()
+
+	sess, tokens, err := sm.refresh(ctx, refreshToken)
+	tracing.Fail(span, err)
+	return sess, tokens, err
+}
+
+func (sm *SessionManager) refresh(ctx context.Context, refreshToken string) (*Session, Tokens, error) {
+	sessionID, secret, ok := parseRefreshToken(refreshToken)
+	if !ok {
+		sm.Logger.Infof("%v. More details: malformed refresh token", ErrNoAuth)
+		return nil, Tokens{}, ErrNoAuth
+	}
+
+	tx, err := sm.DB.BeginTx(ctx, nil)
+	if err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, Tokens{}, ErrInternalDB
+	}
+	defer func() {
+		err = tx.Rollback()
+		if err != nil && !errors.Is(err, sql.ErrTxDone) {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	// Роли берем из users, а не из старого токена: так
+	// изменения ролей вступают в силу при обновлении.
+	var (
+		sess        Session
+		login       string
+		refreshHash sql.NullString
+		rotated     []string
+	)
+	query := `
+	SELECT s.session_id, s.user_id, s.start_time, s.end_time, s.revoked_at, s.refresh_hash,
+		s.rotated_refresh_hashes, u.login, u.roles
+	FROM sessions s
+	JOIN users u ON u.user_id = s.user_id
+	WHERE s.session_id = $1
+	FOR UPDATE OF s
+	`
+	err = tx.QueryRowContext(ctx, query, sessionID).Scan(
+		&sess.ID, &sess.UserID, &sess.StartTime, &sess.EndTime, &sess.RevokedAt,
+		&refreshHash, pq.Array(&rotated), &login, pq.Array(&sess.Roles),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		sm.Logger.Infof("%v. More details: session - %s -", ErrNoAuth, sessionID)
+		return nil, Tokens{}, ErrNoAuth
+	} else if err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, Tokens{}, ErrInternalDB
+	}
+
+	if sess.RevokedAt != nil {
+		return nil, Tokens{}, ErrRevoked
+	}
+	if time.Now().After(sess.EndTime) {
+		return nil, Tokens{}, ErrSessionExpired
+	}
+
+	presented := hashRefreshSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(refreshHash.String)) != 1 {
+		if !slices.Contains(rotated, presented) {
+			sm.Logger.Infof("%v. More details: unknown refresh secret for session - %s -", ErrNoAuth, sess.ID)
+			return nil, Tokens{}, ErrNoAuth
+		}
+
+		query = `UPDATE sessions SET revoked_at = NOW() WHERE session_id = $1`
+		if _, err := tx.ExecContext(ctx, query, sess.ID); err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, Tokens{}, ErrInternalDB
+		}
+		if err := tx.Commit(); err != nil {
+			sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, Tokens{}, ErrInternalDB
+		}
+
+		sm.Logger.Warnf("%v. Session - %s - of userID - %s - revoked", ErrRefreshReused, sess.ID, sess.UserID)
+		return nil, Tokens{}, ErrRefreshReused
+	}
+
+	refresh, newHash, err := newRefreshToken(sess.ID)
+	if err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalGo, err)
+		return nil, Tokens{}, ErrInternalGo
+	}
+
+	// хэш текущего токена уходит в замененные, оставляем последние rotatedRefreshKept
+	query = `
+	UPDATE sessions
+	SET refresh_hash = $1,
+		rotated_refresh_hashes = (array_append(rotated_refresh_hashes, refresh_hash))[GREATEST(cardinality(rotated_refresh_hashes) + 2 - $3, 1):],
+		last_seen_at = NOW()
+	WHERE session_id = $2
+	`
+	if _, err := tx.ExecContext(ctx, query, newHash, sess.ID, rotatedRefreshKept); err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, Tokens{}, ErrInternalDB
+	}
+
+	// подписываем до коммита: иначе старый refresh-токен уже
+	// заменен, а новой пары клиент не получил
+	tokens, err := sm.issueTokens(&sess, login, refresh)
+	if err != nil {
+		return nil, Tokens{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		sm.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, Tokens{}, ErrInternalDB
+	}
+
+	sess.LastSeenAt = time.Now()
+	return &sess, tokens, nil
+}
@@ -0,0 +1,151 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"proj/internal/keyring"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const refreshSelect = `SELECT s.session_id, s.user_id, s.start_time, s.end_time, s.revoked_at, s.refresh_hash, s.rotated_refresh_hashes, u.login, u.roles FROM sessions s JOIN users u ON u.user_id = s.user_id WHERE s.session_id = \$1 FOR UPDATE OF s`
+
+var refreshCols = []string{"session_id", "user_id", "start_time", "end_time", "revoked_at", "refresh_hash", "rotated_refresh_hashes", "login", "roles"}
+
+func TestSessionManager_Refresh(t *testing.T) {
+	const sessionID = "c1e4ec79-7ed7-4017-a749-11ad53572ed0"
+
+	token, hash, err := newRefreshToken(sessionID)
+	if err != nil {
+		t.Fatalf("Failed to generate refresh token: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		noSigningKey  bool
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name:  "Success",
+			token: token,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(refreshSelect).
+					WithArgs(sessionID).
+					WillReturnRows(sqlmock.NewRows(refreshCols).
+						AddRow(sessionID, "user1", time.Now(), time.Now().Add(endTimeDur), nil, hash, "{}", "login1", "{employee}"))
+				mock.ExpectExec(`UPDATE sessions SET refresh_hash = \$1, rotated_refresh_hashes = \(array_append\(rotated_refresh_hashes, refresh_hash\)\)\[GREATEST\(cardinality\(rotated_refresh_hashes\) \+ 2 - \$3, 1\):\], last_seen_at = NOW\(\) WHERE session_id = \$2`).
+					WithArgs(sqlmock.AnyArg(), sessionID, rotatedRefreshKept).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+		},
+		{
+			// токен уже заменен - его хэш среди замененных
+			name:  "ReusedToken",
+			token: token,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(refreshSelect).
+					WithArgs(sessionID).
+					WillReturnRows(sqlmock.NewRows(refreshCols).
+						AddRow(sessionID, "user1", time.Now(), time.Now().Add(endTimeDur), nil, "other-hash", "{"+hash+"}", "login1", "{employee}"))
+				mock.ExpectExec(`UPDATE sessions SET revoked_at = NOW\(\) WHERE session_id = \$1`).
+					WithArgs(sessionID).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			expectedError: ErrRefreshReused,
+		},
+		{
+			// секрет подобран по session_id из access-токена: сессию не отзываем
+			name:  "UnknownSecret",
+			token: sessionID + refreshSep + "guessed",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(refreshSelect).
+					WithArgs(sessionID).
+					WillReturnRows(sqlmock.NewRows(refreshCols).
+						AddRow(sessionID, "user1", time.Now(), time.Now().Add(endTimeDur), nil, hash, "{"+hash+"}", "login1", "{employee}"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrNoAuth,
+		},
+		{
+			name:  "RevokedSession",
+			token: token,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(refreshSelect).
+					WithArgs(sessionID).
+					WillReturnRows(sqlmock.NewRows(refreshCols).
+						AddRow(sessionID, "user1", time.Now(), time.Now().Add(endTimeDur), time.Now(), hash, "{}", "login1", "{employee}"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrRevoked,
+		},
+		{
+			// новую пару не подписали - старый refresh-токен остается в силе
+			name:         "NoSigningKeyRollsBack",
+			token:        token,
+			noSigningKey: true,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(refreshSelect).
+					WithArgs(sessionID).
+					WillReturnRows(sqlmock.NewRows(refreshCols).
+						AddRow(sessionID, "user1", time.Now(), time.Now().Add(endTimeDur), nil, hash, "{}", "login1", "{employee}"))
+				mock.ExpectExec(`UPDATE sessions SET refresh_hash`).
+					WithArgs(sqlmock.AnyArg(), sessionID, rotatedRefreshKept).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrSingingToken,
+		},
+		{
+			name:          "MalformedToken",
+			token:         "garbage",
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrNoAuth,
+		},
+		{
+			name:  "DatabaseError",
+			token: token,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(refreshSelect).
+					WithArgs(sessionID).
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm, mock := newTestSessionManager(t)
+			if tt.noSigningKey {
+				sm.Keys = keyring.New()
+			}
+			tt.mockDBSetup(mock)
+
+			sess, tokens, err := sm.Refresh(context.Background(), tt.token)
+			if tt.expectedError != nil {
+				assert.Equal(t, tt.expectedError, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, []string{"employee"}, sess.Roles)
+				assert.NotEmpty(t, tokens.Access)
+				// старый refresh-токен заменен новым
+				assert.NotEqual(t, token, tokens.Refresh)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
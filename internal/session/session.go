@@ -2,6 +2,8 @@ package session
 
 import (
 	"errors"
+	"net"
+	"net/http"
 	"time"
 
 	"github.com/google/uuid"
@@ -9,10 +11,25 @@ import (
 
 const (
 	endTimeDur = 14 * 24 * time.Hour
+
+	DefaultAccessTTL = 15 * time.Minute
+
+	// Чаще раза в минуту last_seen_at не обновляем, чтобы
+	// не писать в базу на каждый запрос.
+	lastSeenGranularity = time.Minute
+
+	userAgentMaxLen = 255
 )
 
 var (
-	ErrNoAuth = errors.New("session not found")
+	ErrNoAuth         = errors.New("session not found")
+	ErrRevoked        = errors.New("session revoked")
+	ErrSessionExpired = errors.New("session expired")
+
+	// Причины, по которым не принят access-токен
+	ErrTokenMissing   = errors.New("access token missing")
+	ErrTokenMalformed = errors.New("access token malformed")
+	ErrTokenExpired   = errors.New("access token expired")
 )
 
 type Session struct {
@@ -20,16 +37,60 @@ type Session struct {
 	UserID    string    `json:"user_id"`
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
+	// Момент отзыва, у живой сессии пустой
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Выставляется при выдаче списка для сессии, которой подписан запрос
+	Current bool `json:"current"`
+
+	// Логин и роли берутся из claims токена, в таблице sessions не хранятся
+	Login string   `json:"-"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// Пара токенов, которую получает клиент при входе и обновлении.
+type Tokens struct {
+	Access    string
+	Refresh   string
+	ExpiresAt time.Time
+}
+
+// Устройство, с которого открыта сессия.
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// IP берем из RemoteAddr: заголовкам вроде X-Forwarded-For без
+// доверенного прокси верить нельзя.
+func DeviceFromRequest(r *http.Request) Device {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	ua := r.UserAgent()
+	if len(ua) > userAgentMaxLen {
+		ua = ua[:userAgentMaxLen]
+	}
+
+	return Device{UserAgent: ua, IP: ip}
 }
 
-func NewSession(userID string) *Session {
+func NewSession(userID string, dev Device) *Session {
 	startTime := time.Now()
 	endTime := startTime.Add(endTimeDur)
 
 	return &Session{
-		ID:        uuid.New().String(),
-		UserID:    userID,
-		StartTime: startTime,
-		EndTime:   endTime,
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		StartTime:  startTime,
+		EndTime:    endTime,
+		UserAgent:  dev.UserAgent,
+		IP:         dev.IP,
+		LastSeenAt: startTime,
 	}
 }
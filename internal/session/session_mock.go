@@ -5,6 +5,7 @@
 package session
 
 import (
+	context "context"
 	http "net/http"
 	reflect "reflect"
 
@@ -50,31 +51,77 @@ func (mr *MockSessionManagerRepoMockRecorder) Check(r interface{}) *gomock.Call
 }
 
 // Create mocks base method.
-func (m *MockSessionManagerRepo) Create(w http.ResponseWriter, userID, login string) (*Session, string, error) {
+func (m *MockSessionManagerRepo) Create(ctx context.Context, w http.ResponseWriter, userID, login string, roles []string, dev Device) (*Session, Tokens, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Create", w, userID, login)
+	ret := m.ctrl.Call(m, "Create", ctx, w, userID, login, roles, dev)
 	ret0, _ := ret[0].(*Session)
-	ret1, _ := ret[1].(string)
+	ret1, _ := ret[1].(Tokens)
 	ret2, _ := ret[2].(error)
 	return ret0, ret1, ret2
 }
 
 // Create indicates an expected call of Create.
-func (mr *MockSessionManagerRepoMockRecorder) Create(w, userID, login interface{}) *gomock.Call {
+func (mr *MockSessionManagerRepoMockRecorder) Create(ctx, w, userID, login, roles, dev interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionManagerRepo)(nil).Create), w, userID, login)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Create", reflect.TypeOf((*MockSessionManagerRepo)(nil).Create), ctx, w, userID, login, roles, dev)
 }
 
-// GetSecret mocks base method.
-func (m *MockSessionManagerRepo) GetSecret() string {
+// List mocks base method.
+func (m *MockSessionManagerRepo) List(ctx context.Context, userID string) ([]Session, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetSecret")
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "List", ctx, userID)
+	ret0, _ := ret[0].([]Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockSessionManagerRepoMockRecorder) List(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockSessionManagerRepo)(nil).List), ctx, userID)
+}
+
+// Refresh mocks base method.
+func (m *MockSessionManagerRepo) Refresh(ctx context.Context, refreshToken string) (*Session, Tokens, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refresh", ctx, refreshToken)
+	ret0, _ := ret[0].(*Session)
+	ret1, _ := ret[1].(Tokens)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Refresh indicates an expected call of Refresh.
+func (mr *MockSessionManagerRepoMockRecorder) Refresh(ctx, refreshToken interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refresh", reflect.TypeOf((*MockSessionManagerRepo)(nil).Refresh), ctx, refreshToken)
+}
+
+// Revoke mocks base method.
+func (m *MockSessionManagerRepo) Revoke(ctx context.Context, userID, sessionID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Revoke", ctx, userID, sessionID)
+	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// GetSecret indicates an expected call of GetSecret.
-func (mr *MockSessionManagerRepoMockRecorder) GetSecret() *gomock.Call {
+// Revoke indicates an expected call of Revoke.
+func (mr *MockSessionManagerRepoMockRecorder) Revoke(ctx, userID, sessionID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revoke", reflect.TypeOf((*MockSessionManagerRepo)(nil).Revoke), ctx, userID, sessionID)
+}
+
+// RevokeAll mocks base method.
+func (m *MockSessionManagerRepo) RevokeAll(ctx context.Context, userID string) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RevokeAll", ctx, userID)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RevokeAll indicates an expected call of RevokeAll.
+func (mr *MockSessionManagerRepoMockRecorder) RevokeAll(ctx, userID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSecret", reflect.TypeOf((*MockSessionManagerRepo)(nil).GetSecret))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RevokeAll", reflect.TypeOf((*MockSessionManagerRepo)(nil).RevokeAll), ctx, userID)
 }
@@ -0,0 +1,240 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+Обертка драйвера базы: каждый запрос, begin, commit и rollback - свой
+спан, дочерний к спану из контекста запроса. Время ожидания блокировки
+(FOR UPDATE) входит в спан запроса: postgres не отвечает, пока ее не
+получит.
+
+	db := sql.OpenDB(tracing.WrapConnector(pq.Connector))
+
+Текст запроса пишется с плейсхолдерами, значения аргументов в спан
+не попадают.
+*/
+func WrapConnector(c driver.Connector) driver.Connector {
+	return &connector{Connector: c}
+}
+
+type connector struct {
+	driver.Connector
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	cn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: cn}, nil
+}
+
+// Методы, которых нет у драйвера, отдают driver.ErrSkip: database/sql
+// тогда сам перейдет на запасной путь (Prepare + Exec и т.п.).
+type conn struct {
+	driver.Conn
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := startQuery(ctx, query)
+	defer span.End()
+
+	rows, err := q.QueryContext(ctx, query, args)
+	failQuery(span, err)
+	return rows, err
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	ctx, span := startQuery(ctx, query)
+	defer span.End()
+
+	res, err := e.ExecContext(ctx, query, args)
+	failQuery(span, err)
+	return res, err
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		st  driver.Stmt
+		err error
+	)
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		st, err = p.PrepareContext(ctx, query)
+	} else {
+		st, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{Stmt: st, query: query}, nil
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	spanCtx, span := startStatement(ctx, "BEGIN")
+	defer span.End()
+
+	var (
+		tx  driver.Tx
+		err error
+	)
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(spanCtx, opts)
+	} else {
+		// драйвер без BeginTx: уровень изоляции не передать
+		tx, err = c.Conn.Begin() //nolint:staticcheck
+	}
+	if err != nil {
+		Fail(span, err)
+		return nil, err
+	}
+	return &txn{Tx: tx, ctx: ctx}, nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *conn) ResetSession(ctx context.Context) error {
+	if r, ok := c.Conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *conn) IsValid() bool {
+	if v, ok := c.Conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// Commit и Rollback не получают контекст, их спаны вешаем
+// на контекст, в котором транзакцию начали.
+type txn struct {
+	driver.Tx
+	ctx context.Context
+}
+
+func (t *txn) Commit() error {
+	_, span := startStatement(t.ctx, "COMMIT")
+	defer span.End()
+
+	err := t.Tx.Commit()
+	Fail(span, err)
+	return err
+}
+
+func (t *txn) Rollback() error {
+	_, span := startStatement(t.ctx, "ROLLBACK")
+	defer span.End()
+
+	err := t.Tx.Rollback()
+	Fail(span, err)
+	return err
+}
+
+type stmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startQuery(ctx, s.query)
+	defer span.End()
+
+	var (
+		rows driver.Rows
+		err  error
+	)
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.Stmt.Query(values(args)) //nolint:staticcheck
+	}
+	failQuery(span, err)
+	return rows, err
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startQuery(ctx, s.query)
+	defer span.End()
+
+	var (
+		res driver.Result
+		err error
+	)
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.Stmt.Exec(values(args)) //nolint:staticcheck
+	}
+	failQuery(span, err)
+	return res, err
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	vs := make([]driver.Value, len(args))
+	for i, a := range args {
+		vs[i] = a.Value
+	}
+	return vs
+}
+
+/*
+Спан запроса называется по операции: SELECT, UPDATE, INSERT...
+Полный текст - в атрибуте db.query.text. Пробелы и переводы строк
+из запросов в коде схлопываем, чтобы текст читался в одну строку.
+*/
+func startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	text := strings.Join(strings.Fields(query), " ")
+	op := text
+	if i := strings.IndexByte(op, ' '); i > 0 {
+		op = op[:i]
+	}
+	op = strings.ToUpper(op)
+
+	return Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(op),
+			semconv.DBQueryText(text),
+		),
+	)
+}
+
+func startStatement(ctx context.Context, op string) (context.Context, trace.Span) {
+	return Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(op)),
+	)
+}
+
+// ErrSkip - не ошибка запроса, а просьба database/sql выполнить
+// его другим путем; такой спан не помечаем.
+func failQuery(span trace.Span, err error) {
+	if !errors.Is(err, driver.ErrSkip) {
+		Fail(span, err)
+	}
+}
@@ -0,0 +1,132 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Провайдер, который складывает законченные спаны в память.
+func newRecorder(t *testing.T) *tracetest.SpanRecorder {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+	return sr
+}
+
+// sqlmock не отдает driver.Connector, собираем его из драйвера.
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.drv.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.drv }
+
+func newTracedDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	dsn := t.Name()
+	mockDB, mock, err := sqlmock.NewWithDSN(dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() { mockDB.Close() })
+
+	db := sql.OpenDB(WrapConnector(dsnConnector{dsn: dsn, drv: mockDB.Driver()}))
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func attr(attrs []attribute.KeyValue, key attribute.Key) string {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestWrapConnector(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"query is a child of the request span": func(t *testing.T) {
+			sr := newRecorder(t)
+			db, mock := newTracedDB(t)
+			mock.ExpectQuery("SELECT amount_in_wallet").
+				WithArgs("user1").
+				WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
+
+			ctx, parent := Start(context.Background(), "user.Info")
+			var coins int
+			err := db.QueryRowContext(ctx, `
+			SELECT amount_in_wallet
+			FROM users
+			WHERE user_id = $1
+			`, "user1").Scan(&coins)
+			parent.End()
+			require.NoError(t, err)
+
+			spans := sr.Ended()
+			require.Len(t, spans, 2)
+			q := spans[0]
+			require.Equal(t, "SELECT", q.Name())
+			require.Equal(t, parent.SpanContext().SpanID(), q.Parent().SpanID())
+			require.Equal(t, "postgresql", attr(q.Attributes(), semconv.DBSystemKey))
+			// текст в одну строку, без значений аргументов
+			require.Equal(t, "SELECT amount_in_wallet FROM users WHERE user_id = $1", attr(q.Attributes(), semconv.DBQueryTextKey))
+			require.NoError(t, mock.ExpectationsWereMet())
+		},
+
+		"transaction statements share the parent": func(t *testing.T) {
+			sr := newRecorder(t)
+			db, mock := newTracedDB(t)
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			ctx, parent := Start(context.Background(), "user.AdjustBalance")
+			tx, err := db.BeginTx(ctx, nil)
+			require.NoError(t, err)
+			_, err = tx.ExecContext(ctx, "UPDATE users SET amount_in_wallet = amount_in_wallet + $1", 10)
+			require.NoError(t, err)
+			require.NoError(t, tx.Commit())
+			parent.End()
+
+			spans := sr.Ended()
+			require.Len(t, spans, 4)
+			names := make([]string, 0, len(spans))
+			for _, s := range spans[:3] {
+				names = append(names, s.Name())
+				require.Equal(t, parent.SpanContext().SpanID(), s.Parent().SpanID())
+			}
+			require.Equal(t, []string{"BEGIN", "UPDATE", "COMMIT"}, names)
+			require.NoError(t, mock.ExpectationsWereMet())
+		},
+
+		"failed statement is marked": func(t *testing.T) {
+			sr := newRecorder(t)
+			db, mock := newTracedDB(t)
+			mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnError(errors.New("connection reset"))
+
+			_, err := db.ExecContext(context.Background(), "DELETE FROM idempotency_keys WHERE expires_at < $1", 1)
+			require.Error(t, err)
+
+			spans := sr.Ended()
+			require.Len(t, spans, 1)
+			require.Equal(t, "DELETE", spans[0].Name())
+			require.Equal(t, codes.Error, spans[0].Status().Code)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Куда отправляются спаны.
+const (
+	ExporterNone   = "none"   // спаны не пишутся, заголовки traceparent пробрасываются
+	ExporterStdout = "stdout" // JSON в stdout, удобно локально
+	ExporterFile   = "file"   // JSON в файл, без коллектора
+	ExporterOTLP   = "otlp"   // OTLP/HTTP в коллектор
+)
+
+const (
+	DefaultServiceName = "merch-store"
+
+	// Имя инструментирования у всех спанов сервиса
+	instrumentation = "proj"
+)
+
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+type Config struct {
+	Exporter string
+	// host:port коллектора для otlp, пусто - OTEL_EXPORTER_OTLP_ENDPOINT
+	// или localhost:4318
+	Endpoint string
+	// Без TLS до коллектора
+	Insecure bool
+	// Файл для ExporterFile, дописывается
+	File        string
+	ServiceName string
+	// Доля новых трасс, которые записываются, от 0 до 1. Если вызывающий
+	// прислал traceparent, решение берется из него.
+	SampleRatio float64
+}
+
+func ValidExporter(name string) bool {
+	switch name {
+	case ExporterNone, ExporterStdout, ExporterFile, ExporterOTLP:
+		return true
+	}
+	return false
+}
+
+/*
+Настраивает глобальные TracerProvider и пропагатор W3C (traceparent,
+tracestate, baggage). Возвращает функцию остановки: она досылает
+накопленные спаны, ее нужно вызвать перед выходом.
+
+С ExporterNone провайдер остается пустым: спаны не создаются, но
+контекст трассы из входящих заголовков передается дальше.
+*/
+func Setup(ctx context.Context, c Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	noopShutdown := func(context.Context) error { return nil }
+	if c.Exporter == "" || c.Exporter == ExporterNone {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		return noopShutdown, nil
+	}
+
+	exp, closer, err := newExporter(ctx, c)
+	if err != nil {
+		return noopShutdown, err
+	}
+
+	name := c.ServiceName
+	if name == "" {
+		name = DefaultServiceName
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(name),
+	))
+	if err != nil {
+		return noopShutdown, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(c.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, c Config) (sdktrace.SpanExporter, io.Closer, error) {
+	switch c.Exporter {
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		return exp, nil, err
+
+	case ExporterFile:
+		if c.File == "" {
+			return nil, nil, fmt.Errorf("%w: file exporter without file", ErrUnknownExporter)
+		}
+		f, err := os.OpenFile(c.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return exp, f, nil
+
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if c.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(c.Endpoint))
+		}
+		if c.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		return exp, nil, err
+	}
+
+	return nil, nil, fmt.Errorf("%w: %q", ErrUnknownExporter, c.Exporter)
+}
+
+// Новый спан от спана в контексте. Провайдер берется глобальный,
+// поэтому до Setup и в тестах спаны ничего не стоят.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, opts...)
+}
+
+// Отмечает спан ошибочным. nil - ничего не делает.
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup(t *testing.T) {
+	tests := map[string]func(t *testing.T){
+		"file exporter works offline": func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "spans.jsonl")
+			shutdown, err := Setup(context.Background(), Config{
+				Exporter:    ExporterFile,
+				File:        path,
+				SampleRatio: 1,
+			})
+			require.NoError(t, err)
+
+			_, span := Start(context.Background(), "user.BuyItem")
+			span.End()
+			// остановка досылает спаны из батча
+			require.NoError(t, shutdown(context.Background()))
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			var stub struct{ Name string }
+			require.NoError(t, json.Unmarshal(data, &stub))
+			require.Equal(t, "user.BuyItem", stub.Name)
+		},
+
+		"none records nothing": func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), Config{Exporter: ExporterNone})
+			require.NoError(t, err)
+
+			_, span := Start(context.Background(), "user.BuyItem")
+			require.False(t, span.IsRecording())
+			span.End()
+			require.NoError(t, shutdown(context.Background()))
+		},
+
+		"unknown exporter": func(t *testing.T) {
+			_, err := Setup(context.Background(), Config{Exporter: "zipkin"})
+			require.ErrorIs(t, err, ErrUnknownExporter)
+		},
+
+		"file exporter without file": func(t *testing.T) {
+			_, err := Setup(context.Background(), Config{Exporter: ExporterFile})
+			require.ErrorIs(t, err, ErrUnknownExporter)
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+			test(t)
+		})
+	}
+}
+
+func TestValidExporter(t *testing.T) {
+	for _, name := range []string{ExporterNone, ExporterStdout, ExporterFile, ExporterOTLP} {
+		require.True(t, ValidExporter(name), name)
+	}
+	require.False(t, ValidExporter("jaeger"))
+	require.False(t, ValidExporter(""))
+}
@@ -1,37 +1,6 @@
 package types
 
-// Типы для предметов, тк в бд храним кодом (числом).
-const (
-	TypeItemTShirt = iota
-	TypeItemCup
-	TypeItemBook
-	TypeItemPen
-	TypeItemPowerbank
-	TypeItemHoody
-	TypeItemUmbrella
-	TypeItemSocks
-	TypeItemWallet
-	TypeItemPinkHoody
-
-	TypeItemError = -1
-)
-
-var (
-	itemsPoolForCode = []string{
-		"t-shirt", "cup", "book",
-		"pen", "powerbank", "hoody",
-		"umbrella", "socks", "wallet",
-		"pink-hoody",
-	}
-
-	itemsPoolForTitle = map[string]int{
-		"t-shirt": TypeItemTShirt, "cup": TypeItemCup,
-		"book": TypeItemBook, "pen": TypeItemPen,
-		"powerbank": TypeItemPowerbank, "hoody": TypeItemHoody,
-		"umbrella": TypeItemUmbrella, "socks": TypeItemSocks,
-		"wallet": TypeItemWallet, "pink-hoody": TypeItemPinkHoody,
-	}
-)
+import "time"
 
 // Тип ответа информации о пользователе.
 type InfoResponse struct {
@@ -42,29 +11,10 @@ type InfoResponse struct {
 
 // Структура для предметов у юзера.
 type Item struct {
-	Type     string `json:"type"`     // тип возвращаем как строку
+	Type     string `json:"type"`     // slug предмета из каталога
 	Quantity int    `json:"quantity"` // количество таких предметов у нас в инвентаре
 }
 
-func CodeToStringItem(code int) string {
-	return itemsPoolForCode[code]
-}
-
-func StringToCodeItem(title string) int {
-	code, found := itemsPoolForTitle[title]
-	if !found {
-		return TypeItemError
-	}
-
-	return code
-}
-
-// Структура для хранения данных о предмете из бд.
-type ItemInStore struct {
-	Type  int
-	Price int
-}
-
 type Transaction struct {
 	Received []ReceivedTrans `json:"received"`
 	Sent     []SentTrans     `json:"sent"`
@@ -79,3 +29,37 @@ type SentTrans struct {
 	ToUser string `json:"toUser,omitempty"`
 	Amount int    `json:"amount"`
 }
+
+// Направления для фильтра истории переводов.
+const (
+	DirectionAll      = "all"
+	DirectionSent     = "sent"
+	DirectionReceived = "received"
+)
+
+// Фильтр для постраничной истории переводов.
+// Нулевые значения означают "без ограничения".
+type HistoryFilter struct {
+	Direction    string
+	Counterparty string
+	MinAmount    int
+	MaxAmount    int
+	From         time.Time // включительно
+	To           time.Time // не включительно
+	Limit        int
+	Cursor       string
+}
+
+// Одна запись истории с точки зрения запрашивающего пользователя.
+type HistoryEntry struct {
+	ID           int64     `json:"id"`
+	Direction    string    `json:"direction"`
+	Counterparty string    `json:"counterparty"`
+	Amount       int       `json:"amount"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+type HistoryPage struct {
+	Items      []HistoryEntry `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
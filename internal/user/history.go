@@ -0,0 +1,192 @@
+package user
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"proj/internal/tracing"
+	"proj/internal/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HistoryDefaultLimit = 20
+	HistoryMaxLimit     = 100
+)
+
+var (
+	ErrInvalidCursor        = errors.New("invalid history cursor")
+	ErrInvalidHistoryFilter = errors.New("invalid history filter")
+)
+
+// Курсор - позиция последней отданной записи (created_at, trans_id).
+// Для клиента это непрозрачная строка.
+type historyCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+func encodeHistoryCursor(c historyCursor) string {
+	raw := fmt.Sprintf("%d:%d", c.CreatedAt.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeHistoryCursor(s string) (historyCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return historyCursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return historyCursor{}, ErrInvalidCursor
+	}
+
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return historyCursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return historyCursor{}, ErrInvalidCursor
+	}
+
+	return historyCursor{CreatedAt: time.Unix(0, ns), ID: id}, nil
+}
+
+/*
+Постраничная история переводов пользователя. Пагинация по ключу
+(created_at, trans_id), а не через OFFSET, чтобы новые переводы
+не сдвигали страницы и запрос не деградировал на длинной истории.
+Берем на одну запись больше лимита - так узнаем, есть ли следующая страница.
+*/
+func (ur *UserDBRepository) History(ctx context.Context, userID string, f types.HistoryFilter) (types.HistoryPage, error) {
+	ctx, span := tracing.Start(ctx, "user.History")
+	defer span.End()
+
+	page, err := ur.history(ctx, userID, f)
+	tracing.Fail(span, err)
+	return page, err
+}
+
+func (ur *UserDBRepository) history(ctx context.Context, userID string, f types.HistoryFilter) (types.HistoryPage, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = HistoryDefaultLimit
+	}
+	if limit > HistoryMaxLimit {
+		limit = HistoryMaxLimit
+	}
+
+	q, args, err := buildHistoryQuery(userID, f, limit+1)
+	if err != nil {
+		return types.HistoryPage{}, err
+	}
+
+	rows, err := ur.DB.QueryContext(ctx, q, args...)
+	if err != nil {
+		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return types.HistoryPage{}, ErrInternalDB
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	items := make([]types.HistoryEntry, 0, limit+1)
+
+	for rows.Next() {
+		var e types.HistoryEntry
+		err = rows.Scan(&e.ID, &e.CreatedAt, &e.Amount, &e.Direction, &e.Counterparty)
+		if err != nil {
+			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return types.HistoryPage{}, ErrInternalDB
+		}
+
+		items = append(items, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return types.HistoryPage{}, ErrInternalDB
+	}
+
+	var page types.HistoryPage
+	if len(items) > limit {
+		items = items[:limit]
+		last := items[len(items)-1]
+		page.NextCursor = encodeHistoryCursor(historyCursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
+	page.Items = items
+
+	return page, nil
+}
+
+// Собираем запрос истории с учетом только заданных фильтров.
+func buildHistoryQuery(userID string, f types.HistoryFilter, limit int) (string, []interface{}, error) {
+	var sb strings.Builder
+	args := []interface{}{userID}
+
+	// следующий номер плейсхолдера
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	sb.WriteString(`
+	SELECT
+        t.trans_id,
+        t.created_at,
+        t.amount,
+        CASE WHEN t.sender = $1 THEN 'sent' ELSE 'received' END AS direction,
+        CASE WHEN t.sender = $1 THEN u_to.login ELSE u_from.login END AS counterparty
+    FROM transactions t
+    JOIN users u_from ON t.sender = u_from.user_id
+    JOIN users u_to ON t.receiver = u_to.user_id
+	`)
+
+	switch f.Direction {
+	case "", types.DirectionAll:
+		sb.WriteString(" WHERE (t.sender = $1 OR t.receiver = $1)")
+	case types.DirectionSent:
+		sb.WriteString(" WHERE t.sender = $1")
+	case types.DirectionReceived:
+		sb.WriteString(" WHERE t.receiver = $1")
+	default:
+		return "", nil, ErrInvalidHistoryFilter
+	}
+
+	if f.Counterparty != "" {
+		p := arg(f.Counterparty)
+		sb.WriteString(" AND CASE WHEN t.sender = $1 THEN u_to.login ELSE u_from.login END = " + p)
+	}
+	if f.MinAmount > 0 {
+		sb.WriteString(" AND t.amount >= " + arg(f.MinAmount))
+	}
+	if f.MaxAmount > 0 {
+		sb.WriteString(" AND t.amount <= " + arg(f.MaxAmount))
+	}
+	if !f.From.IsZero() {
+		sb.WriteString(" AND t.created_at >= " + arg(f.From))
+	}
+	if !f.To.IsZero() {
+		sb.WriteString(" AND t.created_at < " + arg(f.To))
+	}
+	if f.Cursor != "" {
+		c, err := decodeHistoryCursor(f.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		ts, id := arg(c.CreatedAt), arg(c.ID)
+		sb.WriteString(" AND (t.created_at, t.trans_id) < (" + ts + ", " + id + ")")
+	}
+
+	sb.WriteString(" ORDER BY t.created_at DESC, t.trans_id DESC LIMIT " + arg(limit))
+
+	return sb.String(), args, nil
+}
@@ -0,0 +1,273 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"proj/internal/audit"
+	"proj/internal/dbtx"
+	"proj/internal/ledger"
+	"proj/internal/metrics"
+	"proj/internal/rbac"
+	"proj/internal/tracing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// Длина кода приглашения в байтах, в hex выходит вдвое длиннее.
+	inviteCodeBytes = 16
+
+	DefaultInviteTTL = 7 * 24 * time.Hour
+)
+
+/*
+Явная регистрация. Логин из AllowedLogins регистрируется сразу,
+остальным нужен действующий код приглашения, который гасится
+в той же транзакции, что и создание пользователя.
+*/
+func (ur *UserDBRepository) Register(ctx context.Context, login, password, invite string) (User, error) {
+	ctx, span := tracing.Start(ctx, "user.Register")
+	defer span.End()
+
+	u, err := ur.register(ctx, login, password, invite)
+	tracing.Fail(span, err)
+	return u, err
+}
+
+func (ur *UserDBRepository) register(ctx context.Context, login, password, invite string) (User, error) {
+	if ur.Registration.allows(login) {
+		return createNewUser(ctx, audit.ActionRegister, login, password, "", rbac.DefaultRoles, ur)
+	}
+
+	if invite == "" {
+		ur.Logger.Infof("%v. More details: login - %s -", ErrRegistrationClosed, login)
+		return User{}, ErrRegistrationClosed
+	}
+
+	return createNewUser(ctx, audit.ActionRegister, login, password, invite, rbac.DefaultRoles, ur)
+}
+
+// Создание пользователя администратором: без приглашения и политики
+// регистрации, сразу с нужными ролями.
+func (ur *UserDBRepository) CreateUser(ctx context.Context, login, password string, roles []string) (User, error) {
+	ctx, span := tracing.Start(ctx, "user.CreateUser")
+	defer span.End()
+
+	u, err := ur.createUser(ctx, login, password, roles)
+	tracing.Fail(span, err)
+	return u, err
+}
+
+func (ur *UserDBRepository) createUser(ctx context.Context, login, password string, roles []string) (User, error) {
+	if err := rbac.ValidateRoles(roles); err != nil {
+		return User{}, err
+	}
+
+	return createNewUser(ctx, audit.ActionUserCreate, login, password, "", roles, ur)
+}
+
+// Смена пароля администратором. Живые сессии не трогаем,
+// их отзывает вызывающий код.
+func (ur *UserDBRepository) ResetPassword(ctx context.Context, login, password string) error {
+	ctx, span := tracing.Start(ctx, "user.ResetPassword")
+	defer span.End()
+
+	err := ur.resetPassword(ctx, login, password)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (ur *UserDBRepository) resetPassword(ctx context.Context, login, password string) error {
+	hp, err := hashPassword(ctx, password)
+	if err != nil {
+		ur.Logger.Errorf("%v. More details: %v", ErrInternalGo, err)
+		return ErrInternalGo
+	}
+
+	err = ur.Tx.Run(ctx, "reset_password", func(tx *sql.Tx) error {
+		q := `
+		UPDATE users
+		SET hash_password = $1
+		WHERE login = $2
+		`
+		if err := execOne(ctx, tx, ur.Logger, q, hp, login); err != nil {
+			return err
+		}
+
+		_, err := audit.Record(ctx, tx, audit.NewEvent(ctx, audit.ActionPasswordSet, login), ur.Logger)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ur.Logger.Infof("password of user - %s - reset", login)
+	return nil
+}
+
+// Выдача нового приглашения, createdBy - user_id того, кто приглашает.
+func (ur *UserDBRepository) CreateInvite(ctx context.Context, createdBy string) (Invite, error) {
+	ctx, span := tracing.Start(ctx, "user.CreateInvite")
+	defer span.End()
+
+	inv, err := ur.createInvite(ctx, createdBy)
+	tracing.Fail(span, err)
+	return inv, err
+}
+
+func (ur *UserDBRepository) createInvite(ctx context.Context, createdBy string) (Invite, error) {
+	b := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		ur.Logger.Errorf("%v. More details: %v", ErrInternalGo, err)
+		return Invite{}, ErrInternalGo
+	}
+
+	ttl := ur.Registration.InviteTTL
+	if ttl <= 0 {
+		ttl = DefaultInviteTTL
+	}
+
+	inv := Invite{
+		Code:      hex.EncodeToString(b),
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+
+	// сам код в журнал не пишем: по нему можно зарегистрироваться
+	err := ur.Tx.Run(ctx, "create_invite", func(tx *sql.Tx) error {
+		q := `
+		INSERT INTO invites (code, created_by, expires_at)
+		VALUES ($1, $2, $3)
+		`
+		if _, err := tx.ExecContext(ctx, q, inv.Code, inv.CreatedBy, inv.ExpiresAt); err != nil {
+			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return dbtx.Internal(err, ErrInternalDB)
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionInvite, "").
+			WithActor(createdBy).
+			WithDetails(map[string]interface{}{"expiresAt": inv.ExpiresAt})
+		_, err := audit.Record(ctx, tx, ev, ur.Logger)
+		return err
+	})
+	if err != nil {
+		return Invite{}, err
+	}
+
+	ur.Logger.Infof("invite created by userID - %s - expires at %s", createdBy, inv.ExpiresAt)
+	return inv, nil
+}
+
+// Создание пользователя и стартовое начисление в журнале делаем в одной транзакции.
+// Непустой invite гасится там же, чтобы одно приглашение не сработало дважды.
+// action - под каким действием создание попадает в журнал аудита.
+func createNewUser(ctx context.Context, action, l, p, invite string, roles []string, ur *UserDBRepository) (User, error) {
+	// кодируем пароль
+	hp, err := hashPassword(ctx, p)
+	if err != nil {
+		ur.Logger.Errorf("%v. More details: %v", ErrInternalGo, err)
+		return User{}, err
+	}
+
+	// стартовые монеты выдаются с системного счета
+	newID := uuid.New().String()
+	grant, err := ledger.Move(ledger.KindSignupGrant, "", ledger.AccountIssuance,
+		ledger.UserAccount(newID), startAmountOfMoney)
+	if err != nil {
+		return User{}, err
+	}
+
+	err = ur.Tx.Run(ctx, "create_user", func(tx *sql.Tx) error {
+		// создаем нового пользователя
+		q := `
+		INSERT INTO users (user_id, login, hash_password, amount_in_wallet, roles)
+		VALUES ($1, $2, $3, $4, $5)
+		`
+		_, err := tx.ExecContext(ctx, q, newID, l, hp, startAmountOfMoney, pq.Array(roles))
+		if err != nil {
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation {
+				ur.Logger.Infof("%v. More details: login - %s -", ErrUserExists, l)
+				return ErrUserExists
+			}
+
+			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return dbtx.Internal(err, ErrInternalDB)
+		}
+
+		if invite != "" {
+			if err := redeemInvite(ctx, invite, newID, tx, ur.Logger); err != nil {
+				return err
+			}
+		}
+
+		if _, err := ledger.Post(ctx, tx, grant, ur.Logger); err != nil {
+			return err
+		}
+
+		// при самостоятельной регистрации автор - сам новый пользователь
+		ev := audit.NewEvent(ctx, action, l).
+			WithBalances(0, startAmountOfMoney).
+			WithDetails(map[string]interface{}{"userId": newID, "roles": roles, "invite": invite != ""})
+		if ev.Actor == "" {
+			ev.Actor = newID
+		}
+		_, err = audit.Record(ctx, tx, ev, ur.Logger)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+	metrics.Registrations.Inc()
+
+	u := User{
+		UserID:         newID,
+		Login:          l,
+		passwordHash:   string(hp),
+		AmountInWallet: startAmountOfMoney,
+		Roles:          roles,
+	}
+	ur.Logger.Infof("new user - %s - created", l)
+	return u, nil
+}
+
+// Гасим приглашение: только неиспользованное и не истекшее.
+func redeemInvite(ctx context.Context, code, userID string, tx *sql.Tx, l *zap.SugaredLogger) error {
+	q := `
+	UPDATE invites
+	SET used_by = $1, used_at = NOW()
+	WHERE code = $2 AND used_by IS NULL AND expires_at > NOW()
+	`
+	res, err := tx.ExecContext(ctx, q, userID, code)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return ErrInternalDB
+	}
+	if n == 0 {
+		l.Infof("%v. More details: code - %s -", ErrInvalidInvite, code)
+		return ErrInvalidInvite
+	}
+
+	return nil
+}
+
+// bcrypt - самая долгая часть регистрации, в трассе она отдельным спаном.
+func hashPassword(ctx context.Context, password string) ([]byte, error) {
+	_, span := tracing.Start(ctx, "bcrypt.Hash")
+	defer span.End()
+
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
@@ -5,9 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"proj/internal/audit"
+	"proj/internal/catalog"
+	"proj/internal/dbtx"
+	"proj/internal/idempotency"
+	"proj/internal/ledger"
+	"proj/internal/metrics"
+	"proj/internal/rbac"
+	"proj/internal/tracing"
 	"proj/internal/types"
 
-	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -20,6 +28,16 @@ const (
 	AllocSize = 10
 
 	DefaultQuantityOnFirstPurchase = 1
+
+	// Сколько последних переводов в каждую сторону отдаем в /api/info,
+	// полная история доступна постранично через /api/history.
+	InfoHistoryLimit = 20
+
+	// Коды ошибок postgres: нарушение уникальности, CHECK-ограничения
+	// и выход числа за пределы типа.
+	pqUniqueViolation = "23505"
+	pqCheckViolation  = "23514"
+	pqOutOfRange      = "22003"
 )
 
 var (
@@ -29,49 +47,125 @@ var (
 	ErrInsufficientFunds = errors.New("insufficient funds")
 	ErrUserNotFound      = errors.New("user not found")
 	ErrItemNotFound      = errors.New("item not found")
+
+	ErrUserExists         = errors.New("user already exists")
+	ErrRegistrationClosed = errors.New("registration requires an invite")
+	ErrInvalidInvite      = errors.New("invite is invalid, used or expired")
 )
 
+// Нехватка средств с подробностями: сколько нужно и сколько есть.
+// errors.Is(err, ErrInsufficientFunds) для нее выполняется.
+type InsufficientFundsError struct {
+	Required  int
+	Available int
+}
+
+func (e *InsufficientFundsError) Error() string {
+	return ErrInsufficientFunds.Error()
+}
+
+func (e *InsufficientFundsError) Unwrap() error {
+	return ErrInsufficientFunds
+}
+
 type UserDBRepository struct {
-	DB     *sql.DB
-	Logger *zap.SugaredLogger
+	DB           *sql.DB
+	Logger       *zap.SugaredLogger
+	Registration RegistrationPolicy
+	Transfers    TransferPolicy
+	// Транзакции денежных операций с повтором при дедлоках
+	Tx *dbtx.Runner
 }
 
 func NewUserDBRepository(db *sql.DB, l *zap.SugaredLogger) *UserDBRepository {
 	return &UserDBRepository{
 		DB:     db,
 		Logger: l,
+		Tx:     dbtx.NewRunner(db, l, ErrInternalDB),
 	}
 }
 
 /*
-Вообще, я бы разделил Auth и Register на два метода
-чтобы было проще работать, если пользователь только регистрируется.
-Но удовлетворяя ТЗ сделаем в одной, но немного посложнее:
+Старый вход для /api/auth, оставлен ради обратной совместимости:
 
 	Есть ли такой юзер по логину?
 		* Да => Сверим пароли:
 			* Совпало - ОК
 			* Не совпало - Неверный пароль
 		* Нет:
-			* Создадим его
+			* Создадим его, не глядя на RegistrationPolicy
 */
-func (ur *UserDBRepository) Authorize(login, password string) (User, error) {
-	// проверим, что юзер уже существует
+func (ur *UserDBRepository) Authorize(ctx context.Context, login, password string) (User, error) {
+	ctx, span := tracing.Start(ctx, "user.Authorize")
+	defer span.End()
+
+	u, err := ur.authorize(ctx, login, password)
+	tracing.Fail(span, err)
+	return u, err
+}
+
+func (ur *UserDBRepository) authorize(ctx context.Context, login, password string) (User, error) {
+	u, err := ur.login(ctx, login, password)
+	if errors.Is(err, ErrUserNotFound) {
+		return createNewUser(ctx, audit.ActionRegister, login, password, "", rbac.DefaultRoles, ur)
+	}
+
+	return ur.auditLogin(ctx, login, u, err)
+}
+
+// Вход существующего пользователя, неизвестный логин и
+// неверный пароль различаем разными ошибками.
+func (ur *UserDBRepository) Login(ctx context.Context, login, password string) (User, error) {
+	ctx, span := tracing.Start(ctx, "user.Login")
+	defer span.End()
+
+	u, err := ur.login(ctx, login, password)
+	u, err = ur.auditLogin(ctx, login, u, err)
+	tracing.Fail(span, err)
+	return u, err
+}
+
+/*
+Неудачная попытка входа попадает в журнал аудита. Если записать ее
+не удалось, только логируем - ответ клиенту от этого не меняется.
+Успешный вход пишет SessionManager.Create в одной транзакции с сессией.
+*/
+func (ur *UserDBRepository) auditLogin(ctx context.Context, login string, u User, err error) (User, error) {
+	if err == nil {
+		return u, nil
+	}
+
+	if errors.Is(err, ErrBadPassword) || errors.Is(err, ErrUserNotFound) {
+		reason := "bad_password"
+		if errors.Is(err, ErrUserNotFound) {
+			reason = "user_not_found"
+		}
+		metrics.AuthFailures.WithLabelValues(reason).Inc()
+
+		ev := audit.NewEvent(ctx, audit.ActionLoginFailed, login).
+			WithDetails(map[string]interface{}{"reason": err.Error()})
+		if werr := audit.Write(ctx, ur.DB, ev, ur.Logger); werr != nil {
+			ur.Logger.Warnf("error to audit failed login of - %s -: %v", login, werr)
+		}
+	}
+	return User{}, err
+}
+
+func (ur *UserDBRepository) login(ctx context.Context, login, password string) (User, error) {
 	var u User
 
-	// Проверим, есть ли пользователь с таким именем
 	query := `
-	SELECT user_id, login, hash_password, amount_in_wallet
+	SELECT user_id, login, hash_password, amount_in_wallet, roles
 	FROM users
 	WHERE login = $1
 	`
-	err := ur.DB.QueryRow(query, login).Scan(
-		&u.UserID, &u.Login, &u.passwordHash, &u.AmountInWallet,
+	err := ur.DB.QueryRowContext(ctx, query, login).Scan(
+		&u.UserID, &u.Login, &u.passwordHash, &u.AmountInWallet, pq.Array(&u.Roles),
 	)
 	if err != nil {
-		//  Если такого нет (с таким же логином), то создадим его
 		if errors.Is(err, sql.ErrNoRows) {
-			return createNewUser(login, password, ur)
+			ur.Logger.Infof("%v. More details: login - %s -", ErrUserNotFound, login)
+			return User{}, ErrUserNotFound
 		}
 
 		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
@@ -79,7 +173,10 @@ func (ur *UserDBRepository) Authorize(login, password string) (User, error) {
 	}
 
 	// Сверим пароли
-	if err := bcrypt.CompareHashAndPassword([]byte(u.passwordHash), []byte(password)); err != nil {
+	_, span := tracing.Start(ctx, "bcrypt.Compare")
+	err = bcrypt.CompareHashAndPassword([]byte(u.passwordHash), []byte(password))
+	span.End()
+	if err != nil {
 		// Пароли не совпали
 		ur.Logger.Errorf("%v. More details: user - %s - enter invalid password",
 			ErrBadPassword, login,
@@ -92,39 +189,50 @@ func (ur *UserDBRepository) Authorize(login, password string) (User, error) {
 	return u, nil
 }
 
-// Вспомогательная функция, чтобы сделать функцию Authorize более читаемой.
-func createNewUser(l, p string, ur *UserDBRepository) (User, error) {
-	// кодируем пароль
-	hp, err := bcrypt.GenerateFromPassword([]byte(p), bcrypt.DefaultCost)
-	if err != nil {
-		ur.Logger.Errorf("%v. More details: %v", ErrInternalGo, err)
-		return User{}, err
-	}
+// Поиск пользователя по логину без проверки пароля.
+func (ur *UserDBRepository) GetByLogin(ctx context.Context, login string) (User, error) {
+	ctx, span := tracing.Start(ctx, "user.GetByLogin")
+	defer span.End()
 
-	// создаем нового пользователя
-	q := `
-	INSERT INTO users (user_id, login, hash_password, amount_in_wallet)
-	VALUES ($1, $2, $3, $4)
+	u, err := ur.getByLogin(ctx, login)
+	tracing.Fail(span, err)
+	return u, err
+}
+
+func (ur *UserDBRepository) getByLogin(ctx context.Context, login string) (User, error) {
+	var u User
+
+	query := `
+	SELECT user_id, login, amount_in_wallet, roles
+	FROM users
+	WHERE login = $1
 	`
-	newID := uuid.New().String()
-	_, err = ur.DB.Exec(q, newID, l, hp, startAmountOfMoney)
+	err := ur.DB.QueryRowContext(ctx, query, login).Scan(
+		&u.UserID, &u.Login, &u.AmountInWallet, pq.Array(&u.Roles),
+	)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, ErrUserNotFound
+		}
+
 		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return User{}, err
+		return User{}, ErrInternalDB
 	}
 
-	u := User{
-		UserID:         newID,
-		Login:          l,
-		passwordHash:   string(hp),
-		AmountInWallet: startAmountOfMoney,
-	}
-	ur.Logger.Infof("new user - %s - created", l)
 	return u, nil
 }
 
 // Функция для получении пользователю информации.
-func (ur *UserDBRepository) Info(userID string) (types.InfoResponse, error) {
+func (ur *UserDBRepository) Info(ctx context.Context, userID string) (types.InfoResponse, error) {
+	ctx, span := tracing.Start(ctx, "user.Info")
+	defer span.End()
+
+	info, err := ur.info(ctx, userID)
+	tracing.Fail(span, err)
+	return info, err
+}
+
+func (ur *UserDBRepository) info(ctx context.Context, userID string) (types.InfoResponse, error) {
 	var info types.InfoResponse
 
 	// запрос для coins
@@ -133,7 +241,7 @@ func (ur *UserDBRepository) Info(userID string) (types.InfoResponse, error) {
 	FROM users
 	WHERE user_id = $1
 	`
-	err := ur.DB.QueryRow(query, userID).Scan(&info.Coins)
+	err := ur.DB.QueryRowContext(ctx, query, userID).Scan(&info.Coins)
 	if err != nil {
 		// Если такого пользователя нет, ошибка в запросе
 		if errors.Is(err, sql.ErrNoRows) {
@@ -146,7 +254,7 @@ func (ur *UserDBRepository) Info(userID string) (types.InfoResponse, error) {
 	}
 
 	// Запрос для инвентаря
-	items, err := getInventory(userID, ur)
+	items, err := getInventory(ctx, userID, ur)
 	if err != nil {
 		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
 		return types.InfoResponse{}, ErrInternalDB
@@ -154,7 +262,7 @@ func (ur *UserDBRepository) Info(userID string) (types.InfoResponse, error) {
 	info.Inventory = items
 
 	// Запрос для истории транзакций (moneyHistory)
-	coinHistory, err := getCoinHistory(userID, ur)
+	coinHistory, err := getCoinHistory(ctx, userID, ur)
 	if err != nil {
 		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
 		return types.InfoResponse{}, ErrInternalDB
@@ -164,14 +272,17 @@ func (ur *UserDBRepository) Info(userID string) (types.InfoResponse, error) {
 	return info, nil
 }
 
-// Функция для получения инвентаря.
-func getInventory(userID string, ur *UserDBRepository) ([]types.Item, error) {
+// Функция для получения инвентаря. Коды предметов разрешаем
+// через каталог (таблица store), чтобы отдавать их slug.
+func getInventory(ctx context.Context, userID string, ur *UserDBRepository) ([]types.Item, error) {
 	q := `
-	SELECT type, quantity
-	FROM items
-	WHERE user_id = $1
+	SELECT s.slug, i.quantity
+	FROM items i
+	JOIN store s ON s."type" = i."type"
+	WHERE i.user_id = $1
+	ORDER BY s.sort_order, s.slug
 	`
-	rows, err := ur.DB.Query(q, userID)
+	rows, err := ur.DB.QueryContext(ctx, q, userID)
 	if err != nil {
 		// Если такого пользователя нет, ошибка в запросе
 		if errors.Is(err, sql.ErrNoRows) {
@@ -193,15 +304,12 @@ func getInventory(userID string, ur *UserDBRepository) ([]types.Item, error) {
 
 	for rows.Next() {
 		var i types.Item
-		var t int // переменная для числового кода типа
-		err = rows.Scan(&t, &i.Quantity)
+		err = rows.Scan(&i.Type, &i.Quantity)
 		if err != nil {
 			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
 			return nil, err
 		}
 
-		i.Type = types.CodeToStringItem(t)
-
 		items = append(items, i)
 	}
 
@@ -215,6 +323,7 @@ func getInventory(userID string, ur *UserDBRepository) ([]types.Item, error) {
 
 // Функция для получения истории транзакций.
 func getCoinHistory(
+	ctx context.Context,
 	userID string,
 	ur *UserDBRepository,
 ) (types.Transaction, error) {
@@ -223,7 +332,7 @@ func getCoinHistory(
 
 	// Получим сначала все операции, где пользователю
 	// отправляли монеты (received)
-	rts, err := getReceivedTransactions(userID, ur)
+	rts, err := getReceivedTransactions(ctx, userID, ur)
 	if err != nil {
 		return types.Transaction{}, err
 	}
@@ -231,7 +340,7 @@ func getCoinHistory(
 
 	// Получим теперь все операции, где пользователь
 	// отправлял монеты (sent)
-	sts, err := getSentTransactions(userID, ur)
+	sts, err := getSentTransactions(ctx, userID, ur)
 	if err != nil {
 		return types.Transaction{}, err
 	}
@@ -243,16 +352,18 @@ func getCoinHistory(
 // Вспомогательная функция для декомпозиции getCoinHistory,
 // чтобы в случае дополнительного функционала было
 // проще добавлять и читать код.
-func getReceivedTransactions(userID string, ur *UserDBRepository) ([]types.ReceivedTrans, error) {
+func getReceivedTransactions(ctx context.Context, userID string, ur *UserDBRepository) ([]types.ReceivedTrans, error) {
 	q := `
 	SELECT 
         u_from.login AS from_user,
         t.amount
     FROM transactions t
     JOIN users u_from ON t.sender = u_from.user_id
-    WHERE t.receiver = $1  
+    WHERE t.receiver = $1
+    ORDER BY t.created_at DESC, t.trans_id DESC
+    LIMIT $2
 	`
-	rows, err := ur.DB.Query(q, userID)
+	rows, err := ur.DB.QueryContext(ctx, q, userID, InfoHistoryLimit)
 	if err != nil {
 		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
 		return nil, err
@@ -289,7 +400,7 @@ func getReceivedTransactions(userID string, ur *UserDBRepository) ([]types.Recei
 // Вспомогательная функция для декомпозиции getCoinHistory,
 // чтобы в случае дополнительного функционала было
 // проще добавлять и читать код.
-func getSentTransactions(userID string, ur *UserDBRepository) ([]types.SentTrans, error) {
+func getSentTransactions(ctx context.Context, userID string, ur *UserDBRepository) ([]types.SentTrans, error) {
 	q := `
 	SELECT
         u_to.login AS to_user,
@@ -297,8 +408,10 @@ func getSentTransactions(userID string, ur *UserDBRepository) ([]types.SentTrans
     FROM transactions t
     JOIN users u_to ON t.receiver = u_to.user_id
     WHERE t.sender = $1
+    ORDER BY t.created_at DESC, t.trans_id DESC
+    LIMIT $2
 	`
-	rows, err := ur.DB.Query(q, userID)
+	rows, err := ur.DB.QueryContext(ctx, q, userID, InfoHistoryLimit)
 	if err != nil {
 		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
 		return nil, err
@@ -331,186 +444,93 @@ func getSentTransactions(userID string, ur *UserDBRepository) ([]types.SentTrans
 	return res, nil
 }
 
-/*
-Функция для отправки денег юзеру, разобьем на 4 подфункции:
-  - достаточно ли средств -> enoughCoinsInWallet
-  - списание 			  -> chargeOffFromWallet
-  - отправка 			  -> sendCoinsToWallet
-  - добавление транзакции -> addNewTransactions
-*/
-func (ur *UserDBRepository) SendCoin(userID, toUserLogin string, amount int) error {
-	// Начинаем транзакцию в бд, чтобы обеспечить атомарность нашего запроса
-	tx, err := ur.DB.BeginTx(context.Background(), nil)
-	if err != nil {
-		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return err
-	}
-	defer func() {
-		err = tx.Rollback()
-		if err != nil {
-			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		}
-	}()
-
-	// можем ли списать
-	if err = enoughCoinsInWallet(userID, amount, tx, ur.Logger); err != nil {
-		return err
-	}
-
-	// списание со счета отправителя
-	if err = chargeOffFromWallet(userID, amount, tx, ur.Logger); err != nil {
-		return err
-	}
-
-	// отправка на счет получателя
-	if err = sendCoinsToWallet(toUserLogin, amount, tx, ur.Logger); err != nil {
-		return err
-	}
-
-	// добавление транзакции о проведенной операции
-	if err = addNewTransactions(userID, toUserLogin, amount, tx, ur.Logger); err != nil {
-		return err
-	}
-
-	// Если все произошло успешно, завершаем транзакцию бд
-	if err := tx.Commit(); err != nil {
-		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return ErrInternalDB
-	}
-
-	return nil
-}
-
-// Отправка денег на счет получателя.
-func sendCoinsToWallet(toUserLogin string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
-	q := `
-	UPDATE users
-	SET amount_in_wallet = amount_in_wallet + $1
-	WHERE login = $2
- 	`
-
-	_, err := tx.Exec(q, amount, toUserLogin)
-	if err != nil {
-		fmt.Println(err)
-		l.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return ErrInternalDB
-	}
-
-	return nil
-}
-
-func addNewTransactions(
-	senderID, receiverLogin string,
-	amount int,
-	tx *sql.Tx,
-	l *zap.SugaredLogger,
-) error {
-	// получим id для получателя, тк есть у нас только его ник
-	q := `
-	SELECT user_id
-	FROM users
-	WHERE login = $1
-	`
-	var receiverID string
-	err := tx.QueryRow(q, receiverLogin).Scan(&receiverID)
-	if err != nil {
-		// Если такого пользователя нет, ошибка в запросе
-		if errors.Is(err, sql.ErrNoRows) {
-			l.Errorf("%v. More details: %v", ErrUserNotFound, err)
-			return ErrUserNotFound
-		}
-
-		l.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return err
-	}
-
-	// Добавляем транзакцию для отправителя и получателя
-	q = `
-	INSERT INTO transactions (sender, receiver, amount)
-	VALUES ($1, $2, $3)
-	`
-	_, err = tx.Exec(q, senderID, receiverID, amount)
-	if err != nil {
-		l.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return err
-	}
-
-	return nil
-}
-
 /*
 Функция для покупки предметов пользователем. Декомпозируем на:
   - Получим предмет с его ценой 				   -> getItemByTitle
   - Проверим, можно ли списать такую сумму с счета -> enoughCoinsInWallet
   - Списываем со счета 							   -> chargeOffFromWallet
   - Добавляем предмет в инвентарь				   -> addItemInInventory
+  - Проводка покупки в журнале 					   -> ledger.Post
 
 (если такой уже был - увеличиваем количество).
 */
-func (ur *UserDBRepository) BuyItem(userID, itemTitle string) error {
-	tx, err := ur.DB.BeginTx(context.Background(), nil)
+func (ur *UserDBRepository) BuyItem(ctx context.Context, userID, itemTitle string) error {
+	ctx, span := tracing.Start(ctx, "user.BuyItem")
+	defer span.End()
+
+	err := ur.Tx.Run(ctx, "buy_item", func(tx *sql.Tx) error {
+		return ur.buyItem(ctx, tx, userID, itemTitle)
+	})
 	if err != nil {
-		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
+		tracing.Fail(span, err)
+		countInsufficientFunds(metrics.OpPurchase, err)
 		return err
 	}
-	defer func() {
-		err = tx.Rollback()
-		if err != nil {
-			ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		}
-	}()
 
+	// купить можно только существующий товар, так что метка item
+	// принимает лишь значения из каталога
+	metrics.Purchases.WithLabelValues(itemTitle).Inc()
+	return nil
+}
+
+func (ur *UserDBRepository) buyItem(ctx context.Context, tx *sql.Tx, userID, itemTitle string) error {
 	// получили данные о предмете из бд
-	item, err := getItemByTitle(itemTitle, tx, ur.Logger)
+	item, err := getItemByTitle(ctx, itemTitle, tx, ur.Logger)
 	if err != nil {
 		return err
 	}
 
 	// можем ли списать данную сумму со счета
-	err = enoughCoinsInWallet(userID, item.Price, tx, ur.Logger)
+	balance, err := enoughCoinsInWallet(ctx, userID, item.Price, tx, ur.Logger)
 	if err != nil {
 		return err
 	}
 
 	// списываем деньги со счета
-	err = chargeOffFromWallet(userID, item.Price, tx, ur.Logger)
+	err = chargeOffFromWallet(ctx, userID, item.Price, tx, ur.Logger)
 	if err != nil {
 		return err
 	}
 
 	// добавляем предмет в инвентарь
-	err = addItemInInventory(userID, itemTitle, tx, ur.Logger)
+	err = addItemInInventory(ctx, userID, item, tx, ur.Logger)
 	if err != nil {
 		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		ur.Logger.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return ErrInternalDB
+	// монеты уходят на счет магазина, в ссылке запоминаем версию цены
+	ref := fmt.Sprintf("%s@v%d", item.Slug, item.PriceVersion)
+	entry, err := ledger.Move(ledger.KindPurchase, ref, ledger.UserAccount(userID),
+		ledger.AccountStore, item.Price)
+	if err != nil {
+		return err
+	}
+	if _, err = ledger.Post(ctx, tx, entry, ur.Logger); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// Функция получения данных о предмете.
-func getItemByTitle(itemTitle string, tx *sql.Tx, l *zap.SugaredLogger) (types.ItemInStore, error) {
-	itemCode := types.StringToCodeItem(itemTitle)
-	if itemCode == types.TypeItemError {
-		l.Errorf("%v", ErrItemNotFound)
-		return types.ItemInStore{}, ErrItemNotFound
+	ev := audit.NewEvent(ctx, audit.ActionPurchase, item.Slug).
+		WithActor(userID).
+		WithBalances(balance, balance-item.Price).
+		WithDetails(map[string]interface{}{"price": item.Price, "priceVersion": item.PriceVersion})
+	if _, err = audit.Record(ctx, tx, ev, ur.Logger); err != nil {
+		return err
 	}
-	var i types.ItemInStore
-	i.Type = itemCode
 
-	q := `
-	SELECT price
-	FROM store
-	WHERE type = $1
-	`
-	err := tx.QueryRow(q, itemCode).Scan(&i.Price)
+	// результат для повторов с тем же Idempotency-Key
+	return idempotency.CompleteTx(ctx, tx, ur.Logger)
+}
+
+// Функция получения данных о предмете из каталога. Строка товара
+// блокируется до конца покупки, чтобы цену не поменяли на ходу.
+func getItemByTitle(ctx context.Context, itemTitle string, tx *sql.Tx, l *zap.SugaredLogger) (catalog.Item, error) {
+	i, err := catalog.LockBySlug(ctx, tx, itemTitle, l)
 	if err != nil {
-		l.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return types.ItemInStore{}, ErrInternalDB
+		if errors.Is(err, catalog.ErrItemNotFound) {
+			return catalog.Item{}, ErrItemNotFound
+		}
+
+		return catalog.Item{}, dbtx.Internal(err, ErrInternalDB)
 	}
 
 	return i, nil
@@ -521,7 +541,7 @@ func getItemByTitle(itemTitle string, tx *sql.Tx, l *zap.SugaredLogger) (types.I
   - Если предмета нет - добавим его с количеством 1
   - если есть просто инкрементим количество
 */
-func addItemInInventory(userID, titleItem string, tx *sql.Tx, l *zap.SugaredLogger) error {
+func addItemInInventory(ctx context.Context, userID string, item catalog.Item, tx *sql.Tx, l *zap.SugaredLogger) error {
 	// проверим, есть ли такой предмет
 	q := `
 	SELECT type 
@@ -529,18 +549,17 @@ func addItemInInventory(userID, titleItem string, tx *sql.Tx, l *zap.SugaredLogg
 	WHERE user_id = $1 AND type = $2
 	`
 	var exists int
-	typeItemCode := types.StringToCodeItem(titleItem)
-	err := tx.QueryRow(q, userID, typeItemCode).Scan(&exists)
+	err := tx.QueryRowContext(ctx, q, userID, item.Code).Scan(&exists)
 	if err != nil {
 		// Если такого нет, создадим предмет
 		if errors.Is(err, sql.ErrNoRows) {
-			err = createNewItemInInventory(userID, typeItemCode, tx)
+			err = createNewItemInInventory(ctx, userID, item.Code, tx)
 			if err != nil {
 				l.Errorf("%v. More details: %v", ErrInternalDB, err)
 				return err
 			}
 
-			l.Info("added new item - %s - for user_id - %s -", titleItem, userID)
+			l.Infof("added new item - %s - for user_id - %s -", item.Slug, userID)
 			return nil
 		}
 
@@ -554,7 +573,7 @@ func addItemInInventory(userID, titleItem string, tx *sql.Tx, l *zap.SugaredLogg
 	SET quantity = quantity + 1
 	WHERE user_id = $1 AND type = $2
 	`
-	_, err = tx.Exec(q, userID, typeItemCode)
+	_, err = tx.ExecContext(ctx, q, userID, item.Code)
 	if err != nil {
 		l.Errorf("%v. More details: %v", ErrInternalDB, err)
 		return err
@@ -563,20 +582,29 @@ func addItemInInventory(userID, titleItem string, tx *sql.Tx, l *zap.SugaredLogg
 	return nil
 }
 
-func createNewItemInInventory(userID string, codeItem int, tx *sql.Tx) error {
+func createNewItemInInventory(ctx context.Context, userID string, codeItem int, tx *sql.Tx) error {
 	q := `
 	INSERT INTO items (user_id, type, quantity)
 	VALUES ($1, $2, $3)
 	`
-	_, err := tx.Exec(q, userID, codeItem, DefaultQuantityOnFirstPurchase)
+	_, err := tx.ExecContext(ctx, q, userID, codeItem, DefaultQuantityOnFirstPurchase)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// Проверка на наличие нужного количества средств.
-func enoughCoinsInWallet(userID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
+// Отказ из-за нехватки средств попадает в метрики.
+func countInsufficientFunds(op string, err error) {
+	var ife *InsufficientFundsError
+	if errors.As(err, &ife) {
+		metrics.InsufficientFunds.WithLabelValues(op).Inc()
+	}
+}
+
+// Проверка на наличие нужного количества средств,
+// возвращает баланс до списания.
+func enoughCoinsInWallet(ctx context.Context, userID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) (int, error) {
 	// FOR UPDATE позволяет блокировать баланс на время транзакции
 	q := `
 	SELECT amount_in_wallet
@@ -585,38 +613,255 @@ func enoughCoinsInWallet(userID string, amount int, tx *sql.Tx, l *zap.SugaredLo
 	FOR UPDATE
 	`
 	var AmountInWallet int
-	err := tx.QueryRow(q, userID).Scan(&AmountInWallet)
+	err := tx.QueryRowContext(ctx, q, userID).Scan(&AmountInWallet)
 	if err != nil {
 		// Если мы не нашли такого пользователя
 		if errors.Is(err, sql.ErrNoRows) {
 			l.Errorf("%v. More details: %v", ErrUserNotFound, err)
-			return ErrUserNotFound
+			return 0, ErrUserNotFound
 		}
 
 		l.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return ErrInternalDB
+		return 0, dbtx.Internal(err, ErrInternalDB)
 	}
 
 	// Если недостаточно средств
 	if AmountInWallet-amount < 0 {
-		return ErrInsufficientFunds
+		return AmountInWallet, &InsufficientFundsError{Required: amount, Available: AmountInWallet}
 	}
 
-	return nil
+	return AmountInWallet, nil
 }
 
 // Списание со счета средств.
-func chargeOffFromWallet(userID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
+func chargeOffFromWallet(ctx context.Context, userID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
 	q := `
 	UPDATE users 
 	SET amount_in_wallet = amount_in_wallet - $1
 	WHERE user_id = $2
 	`
 
-	_, err := tx.Exec(q, amount, userID)
+	_, err := tx.ExecContext(ctx, q, amount, userID)
 	if err != nil {
+		// CHECK (amount_in_wallet >= 0) - последний рубеж, если
+		// проверку баланса кто-то обошел
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqCheckViolation {
+			l.Errorf("%v. More details: %v", ErrInsufficientFunds, err)
+			return ErrInsufficientFunds
+		}
+
 		l.Errorf("%v. More details: %v", ErrInternalDB, err)
-		return ErrInternalDB
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return nil
+}
+
+/*
+Ручная корректировка баланса (для админов): меняем кэш в users
+и пишем проводку против системного счета корректировок.
+Уводить баланс в минус не даем.
+*/
+func (ur *UserDBRepository) AdjustBalance(ctx context.Context, userID string, delta int, reason string) error {
+	ctx, span := tracing.Start(ctx, "user.AdjustBalance")
+	defer span.End()
+
+	err := ur.adjustBalance(ctx, userID, delta, reason)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (ur *UserDBRepository) adjustBalance(ctx context.Context, userID string, delta int, reason string) error {
+	entry, err := ledger.Adjustment(reason, userID, delta)
+	if err != nil {
+		return err
+	}
+
+	err = ur.Tx.Run(ctx, "adjust_balance", func(tx *sql.Tx) error {
+		return adjustTx(ctx, tx, ur.Logger, entry, userID, delta, reason)
+	})
+	if err != nil {
+		return err
+	}
+
+	ur.Logger.Infof("balance of userID - %s - adjusted by %d: %s", userID, delta, reason)
+	return nil
+}
+
+/*
+Начисление по списку одной транзакцией: либо зачислены все строки,
+либо ни одной. Ошибка или отмена посреди пакета ничего не оставляет,
+и повторный запуск не начислит первым строкам второй раз.
+*/
+func (ur *UserDBRepository) GrantBalances(ctx context.Context, grants []Grant, reason string) error {
+	ctx, span := tracing.Start(ctx, "user.GrantBalances")
+	defer span.End()
+
+	err := ur.grantBalances(ctx, grants, reason)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (ur *UserDBRepository) grantBalances(ctx context.Context, grants []Grant, reason string) error {
+	entries := make([]ledger.Entry, 0, len(grants))
+	for _, g := range grants {
+		// списывать пакетом нельзя, только начислять
+		if g.Amount <= 0 {
+			return ledger.ErrInvalidAmount
+		}
+		entry, err := ledger.Adjustment(reason, g.UserID, g.Amount)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry)
+	}
+
+	total := 0
+	err := ur.Tx.Run(ctx, "grant_balances", func(tx *sql.Tx) error {
+		total = 0
+		for i, g := range grants {
+			if err := adjustTx(ctx, tx, ur.Logger, entries[i], g.UserID, g.Amount, reason); err != nil {
+				return err
+			}
+			total += g.Amount
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ur.Logger.Infof("granted %d coins to %d users: %s", total, len(grants), reason)
+	return nil
+}
+
+// Корректировка внутри транзакции: блокируем баланс, меняем его,
+// пишем проводку и событие аудита.
+func adjustTx(ctx context.Context, tx *sql.Tx, l *zap.SugaredLogger, entry ledger.Entry, userID string, delta int, reason string) error {
+	// блокируем баланс и проверяем, что не уйдем в минус
+	amount := 0
+	if delta < 0 {
+		amount = -delta
+	}
+	balance, err := enoughCoinsInWallet(ctx, userID, amount, tx, l)
+	if err != nil {
+		countInsufficientFunds(metrics.OpAdjust, err)
+		return err
+	}
+
+	q := `
+	UPDATE users
+	SET amount_in_wallet = amount_in_wallet + $1
+	WHERE user_id = $2
+	`
+	if _, err = tx.ExecContext(ctx, q, delta, userID); err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	if _, err = ledger.Post(ctx, tx, entry, l); err != nil {
+		return err
+	}
+
+	ev := audit.NewEvent(ctx, audit.ActionAdjust, userID).
+		WithBalances(balance, balance+delta).
+		WithDetails(map[string]interface{}{"delta": delta, "reason": reason})
+	_, err = audit.Record(ctx, tx, ev, l)
+	return err
+}
+
+// Назначение ролей пользователю (полная замена списка).
+func (ur *UserDBRepository) SetRoles(ctx context.Context, login string, roles []string) error {
+	ctx, span := tracing.Start(ctx, "user.SetRoles")
+	defer span.End()
+
+	err := ur.setRoles(ctx, login, roles)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (ur *UserDBRepository) setRoles(ctx context.Context, login string, roles []string) error {
+	if err := rbac.ValidateRoles(roles); err != nil {
+		return err
+	}
+
+	err := ur.Tx.Run(ctx, "set_roles", func(tx *sql.Tx) error {
+		q := `
+		UPDATE users
+		SET roles = $1
+		WHERE login = $2
+		`
+		if err := execOne(ctx, tx, ur.Logger, q, pq.Array(roles), login); err != nil {
+			return err
+		}
+
+		ev := audit.NewEvent(ctx, audit.ActionSetRoles, login).
+			WithDetails(map[string]interface{}{"roles": roles})
+		_, err := audit.Record(ctx, tx, ev, ur.Logger)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ur.Logger.Infof("roles of user - %s - set to %v", login, roles)
+	return nil
+}
+
+// Деактивированному пользователю нельзя переводить монеты
+// (ErrRecipientInactive), вход и покупки ему доступны.
+func (ur *UserDBRepository) SetActive(ctx context.Context, login string, active bool) error {
+	ctx, span := tracing.Start(ctx, "user.SetActive")
+	defer span.End()
+
+	err := ur.setActive(ctx, login, active)
+	tracing.Fail(span, err)
+	return err
+}
+
+func (ur *UserDBRepository) setActive(ctx context.Context, login string, active bool) error {
+	action := audit.ActionUserOff
+	if active {
+		action = audit.ActionUserActivate
+	}
+
+	err := ur.Tx.Run(ctx, "set_active", func(tx *sql.Tx) error {
+		q := `
+		UPDATE users
+		SET active = $1
+		WHERE login = $2
+		`
+		if err := execOne(ctx, tx, ur.Logger, q, active, login); err != nil {
+			return err
+		}
+
+		_, err := audit.Record(ctx, tx, audit.NewEvent(ctx, action, login), ur.Logger)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	ur.Logger.Infof("user - %s - active set to %t", login, active)
+	return nil
+}
+
+// Обновление одного пользователя: не нашли строку - ErrUserNotFound.
+func execOne(ctx context.Context, tx *sql.Tx, l *zap.SugaredLogger, q string, args ...interface{}) error {
+	res, err := tx.ExecContext(ctx, q, args...)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+	if n == 0 {
+		return ErrUserNotFound
 	}
 
 	return nil
@@ -0,0 +1,346 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"proj/internal/audit"
+	"proj/internal/dbtx"
+	"proj/internal/idempotency"
+	"proj/internal/ledger"
+	"proj/internal/metrics"
+	"proj/internal/tracing"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Потолок одного перевода: amount в базе - INTEGER.
+const maxTransferAmount = math.MaxInt32
+
+var (
+	ErrInvalidAmount     = errors.New("transfer amount must be positive")
+	ErrSelfTransfer      = errors.New("cannot send coins to yourself")
+	ErrTransferLimit     = errors.New("transfer amount exceeds the per-transfer limit")
+	ErrDailyLimit        = errors.New("daily outgoing transfer limit exceeded")
+	ErrRecipientInactive = errors.New("recipient is not active")
+	ErrBalanceOverflow   = errors.New("recipient balance would overflow")
+)
+
+// Ограничения переводов между пользователями, 0 - без ограничения.
+type TransferPolicy struct {
+	// Максимальная сумма одного перевода
+	MaxAmount int
+	// Сколько пользователь может отправить за последние 24 часа
+	DailyLimit int
+}
+
+// Превышение лимита с подробностями: сам лимит и сколько еще можно
+// отправить. errors.Is(err, Err) для нее выполняется.
+type LimitError struct {
+	Err       error
+	Limit     int
+	Remaining int
+}
+
+func (e *LimitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// Проверки, для которых не нужна база.
+func (p TransferPolicy) Validate(amount int) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	limit := maxTransferAmount
+	if p.MaxAmount > 0 && p.MaxAmount < limit {
+		limit = p.MaxAmount
+	}
+	if amount > limit {
+		return &LimitError{Err: ErrTransferLimit, Limit: limit, Remaining: limit}
+	}
+
+	return nil
+}
+
+// Суточный лимит считаем по уже проведенным переводам. Вызывать
+// после блокировки строки отправителя, иначе параллельные переводы
+// проскочат лимит вместе.
+func (p TransferPolicy) checkDailyLimit(ctx context.Context, senderID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
+	if p.DailyLimit <= 0 {
+		return nil
+	}
+
+	q := `
+	SELECT COALESCE(SUM(amount), 0)
+	FROM transactions
+	WHERE sender = $1 AND created_at > NOW() - INTERVAL '24 hours'
+	`
+	var sent int
+	if err := tx.QueryRowContext(ctx, q, senderID).Scan(&sent); err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	if sent+amount > p.DailyLimit {
+		return &LimitError{Err: ErrDailyLimit, Limit: p.DailyLimit, Remaining: max(p.DailyLimit-sent, 0)}
+	}
+
+	return nil
+}
+
+// Получатель перевода: по логину или по user_id, задается одно из полей.
+type Recipient struct {
+	Login  string
+	UserID string
+}
+
+/*
+Перевод монет. Порядок важен:
+  - сумма допустима 		  -> Transfers.Validate
+  - находим получателя 		  -> resolveRecipient, до движения денег
+  - блокируем оба кошелька 	  -> lockWallets, по возрастанию user_id
+  - хватает ли средств и лимита -> проверки по заблокированным строкам
+  - списание, зачисление, запись в transactions и проводка в журнале
+
+Встречные переводы A->B и B->A берут блокировки в одном порядке,
+поэтому не встают в дедлок, а баланс читается уже под блокировкой,
+поэтому параллельные списания не теряются.
+*/
+func (ur *UserDBRepository) SendCoin(ctx context.Context, userID string, to Recipient, amount int) error {
+	ctx, span := tracing.Start(ctx, "user.SendCoin")
+	defer span.End()
+
+	if err := ur.Transfers.Validate(amount); err != nil {
+		ur.Logger.Infof("%v. More details: userID - %s -, amount - %d -", err, userID, amount)
+		tracing.Fail(span, err)
+		return err
+	}
+
+	// Дедлок или конфликт сериализации - не ошибка клиента,
+	// Runner повторит перевод целиком
+	err := ur.Tx.Run(ctx, "send_coin", func(tx *sql.Tx) error {
+		return ur.sendCoin(ctx, tx, userID, to, amount)
+	})
+	if err != nil {
+		tracing.Fail(span, err)
+		countInsufficientFunds(metrics.OpTransfer, err)
+		return err
+	}
+
+	metrics.CoinsTransferred.Add(float64(amount))
+	return nil
+}
+
+func (ur *UserDBRepository) sendCoin(ctx context.Context, tx *sql.Tx, userID string, to Recipient, amount int) error {
+	receiverID, err := resolveRecipient(ctx, to, tx, ur.Logger)
+	if err != nil {
+		return err
+	}
+	if receiverID == userID {
+		ur.Logger.Infof("%v. More details: userID - %s -", ErrSelfTransfer, userID)
+		return ErrSelfTransfer
+	}
+
+	wallets, err := lockWallets(ctx, tx, ur.Logger, userID, receiverID)
+	if err != nil {
+		return err
+	}
+	sender, ok := wallets[userID]
+	if !ok {
+		ur.Logger.Errorf("%v. More details: sender - %s -", ErrUserNotFound, userID)
+		return ErrUserNotFound
+	}
+	receiver, ok := wallets[receiverID]
+	if !ok {
+		// получателя удалили между поиском и блокировкой
+		ur.Logger.Infof("%v. More details: receiver - %s -", ErrUserNotFound, receiverID)
+		return ErrUserNotFound
+	}
+	if !receiver.active {
+		ur.Logger.Infof("%v. More details: receiver - %s -", ErrRecipientInactive, receiverID)
+		return ErrRecipientInactive
+	}
+
+	if sender.amount < amount {
+		return &InsufficientFundsError{Required: amount, Available: sender.amount}
+	}
+	if err = ur.Transfers.checkDailyLimit(ctx, userID, amount, tx, ur.Logger); err != nil {
+		return err
+	}
+
+	// списание со счета отправителя
+	if err = chargeOffFromWallet(ctx, userID, amount, tx, ur.Logger); err != nil {
+		return err
+	}
+
+	// зачисление на счет получателя
+	if err = sendCoinsToWallet(ctx, receiverID, amount, tx, ur.Logger); err != nil {
+		return err
+	}
+
+	// запись о переводе для истории
+	if err = addNewTransaction(ctx, userID, receiverID, amount, tx, ur.Logger); err != nil {
+		return err
+	}
+
+	// проводка перевода в журнале
+	entry, err := ledger.Move(ledger.KindTransfer, "", ledger.UserAccount(userID),
+		ledger.UserAccount(receiverID), amount)
+	if err != nil {
+		return err
+	}
+	if _, err = ledger.Post(ctx, tx, entry, ur.Logger); err != nil {
+		return err
+	}
+
+	// балансы в событии - отправителя, получателя кладем в подробности
+	ev := audit.NewEvent(ctx, audit.ActionTransfer, receiverID).
+		WithActor(userID).
+		WithBalances(sender.amount, sender.amount-amount).
+		WithDetails(map[string]interface{}{
+			"amount":                amount,
+			"receiverBalanceBefore": receiver.amount,
+			"receiverBalanceAfter":  receiver.amount + amount,
+		})
+	if _, err = audit.Record(ctx, tx, ev, ur.Logger); err != nil {
+		return err
+	}
+
+	// результат для повторов с тем же Idempotency-Key
+	return idempotency.CompleteTx(ctx, tx, ur.Logger)
+}
+
+// user_id получателя. Строку не блокируем, это сделает lockWallets.
+func resolveRecipient(ctx context.Context, to Recipient, tx *sql.Tx, l *zap.SugaredLogger) (string, error) {
+	var (
+		q   string
+		arg string
+	)
+	switch {
+	case to.UserID != "":
+		// мусор в колонку UUID postgres не примет, это не 500
+		if _, err := uuid.Parse(to.UserID); err != nil {
+			return "", ErrUserNotFound
+		}
+		q, arg = `SELECT user_id FROM users WHERE user_id = $1`, to.UserID
+	case to.Login != "":
+		q, arg = `SELECT user_id FROM users WHERE login = $1`, to.Login
+	default:
+		return "", ErrUserNotFound
+	}
+
+	var receiverID string
+	err := tx.QueryRowContext(ctx, q, arg).Scan(&receiverID)
+	if errors.Is(err, sql.ErrNoRows) {
+		l.Infof("%v. More details: recipient - %s -", ErrUserNotFound, arg)
+		return "", ErrUserNotFound
+	} else if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return "", dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return receiverID, nil
+}
+
+type wallet struct {
+	amount int
+	active bool
+}
+
+// Блокировка кошельков участников перевода. ORDER BY выполняется до
+// FOR UPDATE, так что строки блокируются по возрастанию user_id
+// независимо от того, кто отправитель.
+func lockWallets(ctx context.Context, tx *sql.Tx, l *zap.SugaredLogger, userIDs ...string) (map[string]wallet, error) {
+	q := `
+	SELECT user_id, amount_in_wallet, active
+	FROM users
+	WHERE user_id = ANY($1)
+	ORDER BY user_id
+	FOR UPDATE
+	`
+	rows, err := tx.QueryContext(ctx, q, pq.Array(userIDs))
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, dbtx.Internal(err, ErrInternalDB)
+	}
+	defer func() {
+		err = rows.Close()
+		if err != nil {
+			l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		}
+	}()
+
+	wallets := make(map[string]wallet, len(userIDs))
+	for rows.Next() {
+		var (
+			id string
+			w  wallet
+		)
+		if err = rows.Scan(&id, &w.amount, &w.active); err != nil {
+			l.Errorf("%v. More details: %v", ErrInternalDB, err)
+			return nil, dbtx.Internal(err, ErrInternalDB)
+		}
+		wallets[id] = w
+	}
+
+	if err = rows.Err(); err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return nil, dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return wallets, nil
+}
+
+// Зачисление на счет получателя.
+func sendCoinsToWallet(ctx context.Context, receiverID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
+	q := `
+	UPDATE users
+	SET amount_in_wallet = amount_in_wallet + $1
+	WHERE user_id = $2
+	`
+	res, err := tx.ExecContext(ctx, q, amount, receiverID)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pqOutOfRange {
+			l.Infof("%v. More details: %v", ErrBalanceOverflow, err)
+			return ErrBalanceOverflow
+		}
+
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+	if n != 1 {
+		l.Errorf("%v. More details: receiver - %s - not updated", ErrUserNotFound, receiverID)
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+func addNewTransaction(ctx context.Context, senderID, receiverID string, amount int, tx *sql.Tx, l *zap.SugaredLogger) error {
+	q := `
+	INSERT INTO transactions (sender, receiver, amount)
+	VALUES ($1, $2, $3)
+	`
+	_, err := tx.ExecContext(ctx, q, senderID, receiverID, amount)
+	if err != nil {
+		l.Errorf("%v. More details: %v", ErrInternalDB, err)
+		return dbtx.Internal(err, ErrInternalDB)
+	}
+
+	return nil
+}
@@ -0,0 +1,309 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"proj/internal/audit"
+	"proj/internal/ledger"
+	"proj/internal/metrics"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	lockWalletsQuery = `SELECT user_id, amount_in_wallet, active FROM users WHERE user_id = ANY\(\$1\) ORDER BY user_id FOR UPDATE`
+)
+
+func expectResolveByLogin(mock sqlmock.Sqlmock, login, userID string) {
+	mock.ExpectQuery(`SELECT user_id FROM users WHERE login = \$1`).
+		WithArgs(login).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(userID))
+}
+
+func expectLockWallets(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(lockWalletsQuery).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(rows)
+}
+
+func walletRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"user_id", "amount_in_wallet", "active"})
+}
+
+func TestUserDBRepository_SendCoin(t *testing.T) {
+	tests := []struct {
+		name          string
+		to            Recipient
+		amount        int
+		policy        TransferPolicy
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name:   "Success",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+
+				// Получателя находим до движения денег
+				expectResolveByLogin(mock, "user2", "user2")
+
+				// Оба кошелька блокируются одним запросом по возрастанию user_id
+				expectLockWallets(mock, walletRows().AddRow("user1", 100, true).AddRow("user2", 0, true))
+
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
+					WithArgs(50, "user1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1 WHERE user_id = \$2`).
+					WithArgs(50, "user2").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO transactions \(sender, receiver, amount\) VALUES \(\$1, \$2, \$3\)`).
+					WithArgs("user1", "user2", 50).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectLedgerPost(mock, ledger.KindTransfer, "",
+					ledger.UserAccount("user1"), -50, ledger.UserAccount("user2"), 50)
+				expectAudit(mock, audit.ActionTransfer)
+
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "SuccessByUserID",
+			to:     Recipient{UserID: "7f1b3c2e-5d4a-4b6e-9c8d-1a2b3c4d5e6f"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				const receiver = "7f1b3c2e-5d4a-4b6e-9c8d-1a2b3c4d5e6f"
+
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT user_id FROM users WHERE user_id = \$1`).
+					WithArgs(receiver).
+					WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(receiver))
+				expectLockWallets(mock, walletRows().AddRow(receiver, 0, true).AddRow("user1", 100, true))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1`).
+					WithArgs(50, "user1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1`).
+					WithArgs(50, receiver).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO transactions`).
+					WithArgs("user1", receiver, 50).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectLedgerPost(mock, ledger.KindTransfer, "",
+					ledger.UserAccount("user1"), -50, ledger.UserAccount(receiver), 50)
+				expectAudit(mock, audit.ActionTransfer)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "InsufficientFunds",
+			to:     Recipient{Login: "user2"},
+			amount: 150,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				expectLockWallets(mock, walletRows().AddRow("user1", 100, true).AddRow("user2", 0, true))
+				mock.ExpectRollback()
+			},
+			expectedError: &InsufficientFundsError{Required: 150, Available: 100},
+		},
+		{
+			name:   "SenderNotFound",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				expectLockWallets(mock, walletRows().AddRow("user2", 0, true))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrUserNotFound,
+		},
+		{
+			name:   "ReceiverNotFound",
+			to:     Recipient{Login: "ghost"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				// Денег не трогаем: ни блокировок, ни UPDATE
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT user_id FROM users WHERE login = \$1`).
+					WithArgs("ghost").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrUserNotFound,
+		},
+		{
+			name:   "MalformedUserID",
+			to:     Recipient{UserID: "not-a-uuid"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectRollback()
+			},
+			expectedError: ErrUserNotFound,
+		},
+		{
+			name:   "DatabaseError",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT user_id FROM users WHERE login = \$1`).
+					WithArgs("user2").
+					WillReturnError(errors.New("database error"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrInternalDB,
+		},
+		{
+			name:          "NegativeAmount",
+			to:            Recipient{Login: "user2"},
+			amount:        -50,
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrInvalidAmount,
+		},
+		{
+			name:          "ZeroAmount",
+			to:            Recipient{Login: "user2"},
+			amount:        0,
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrInvalidAmount,
+		},
+		{
+			name:          "OverPerTransferLimit",
+			to:            Recipient{Login: "user2"},
+			amount:        101,
+			policy:        TransferPolicy{MaxAmount: 100},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: &LimitError{Err: ErrTransferLimit, Limit: 100, Remaining: 100},
+		},
+		{
+			name:          "Int32Overflow",
+			to:            Recipient{Login: "user2"},
+			amount:        maxTransferAmount + 1,
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: &LimitError{Err: ErrTransferLimit, Limit: maxTransferAmount, Remaining: maxTransferAmount},
+		},
+		{
+			name:   "OverDailyLimit",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			policy: TransferPolicy{DailyLimit: 100},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				expectLockWallets(mock, walletRows().AddRow("user1", 100, true).AddRow("user2", 0, true))
+
+				// за сутки уже отправлено 70
+				mock.ExpectQuery(`SELECT COALESCE\(SUM\(amount\), 0\) FROM transactions WHERE sender = \$1`).
+					WithArgs("user1").
+					WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow(70))
+				mock.ExpectRollback()
+			},
+			expectedError: &LimitError{Err: ErrDailyLimit, Limit: 100, Remaining: 30},
+		},
+		{
+			name:   "SelfTransfer",
+			to:     Recipient{Login: "login1"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "login1", "user1")
+				mock.ExpectRollback()
+			},
+			expectedError: ErrSelfTransfer,
+		},
+		{
+			name:   "RecipientInactive",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				expectLockWallets(mock, walletRows().AddRow("user1", 100, true).AddRow("user2", 0, false))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrRecipientInactive,
+		},
+		{
+			name:   "DeadlockRetried",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				// первая попытка упала на блокировке, перевод повторяется целиком
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				mock.ExpectQuery(lockWalletsQuery).
+					WithArgs(sqlmock.AnyArg()).
+					WillReturnError(&pq.Error{Code: "40P01"})
+				mock.ExpectRollback()
+
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				expectLockWallets(mock, walletRows().AddRow("user1", 100, true).AddRow("user2", 0, true))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1`).
+					WithArgs(50, "user1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1`).
+					WithArgs(50, "user2").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`INSERT INTO transactions`).
+					WithArgs("user1", "user2", 50).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectLedgerPost(mock, ledger.KindTransfer, "",
+					ledger.UserAccount("user1"), -50, ledger.UserAccount("user2"), 50)
+				expectAudit(mock, audit.ActionTransfer)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:   "ReceiverBalanceOverflow",
+			to:     Recipient{Login: "user2"},
+			amount: 50,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectResolveByLogin(mock, "user2", "user2")
+				expectLockWallets(mock, walletRows().AddRow("user1", 100, true).AddRow("user2", maxTransferAmount, true))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1`).
+					WithArgs(50, "user1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1`).
+					WithArgs(50, "user2").
+					WillReturnError(&pq.Error{Code: pqOutOfRange})
+				mock.ExpectRollback()
+			},
+			expectedError: ErrBalanceOverflow,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			repo.Transfers = tt.policy
+			tt.mockDBSetup(mock)
+			transferred := testutil.ToFloat64(metrics.CoinsTransferred)
+			rejected := testutil.ToFloat64(metrics.InsufficientFunds.WithLabelValues(metrics.OpTransfer))
+
+			err := repo.SendCoin(context.Background(), "user1", tt.to, tt.amount)
+
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+
+			// в метрики попадают только завершенные переводы и отказы из-за баланса
+			var ife *InsufficientFundsError
+			switch {
+			case err == nil:
+				assert.Equal(t, transferred+float64(tt.amount), testutil.ToFloat64(metrics.CoinsTransferred))
+			case errors.As(err, &ife):
+				assert.Equal(t, rejected+1, testutil.ToFloat64(metrics.InsufficientFunds.WithLabelValues(metrics.OpTransfer)))
+			default:
+				assert.Equal(t, transferred, testutil.ToFloat64(metrics.CoinsTransferred))
+			}
+		})
+	}
+}
@@ -1,18 +1,68 @@
 package user
 
-import "proj/internal/types"
+import (
+	"context"
+	"proj/internal/types"
+	"time"
+)
 
 type User struct {
 	UserID         string `json:"user_id"`
 	Login          string `json:"login"`
 	passwordHash   string
-	AmountInWallet int `json:"amount_in_wallet"`
+	AmountInWallet int      `json:"amount_in_wallet"`
+	Roles          []string `json:"roles"`
 }
 
+// Приглашение на регистрацию, одноразовое и с ограниченным сроком.
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy string    `json:"createdBy"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Строка пакетного начисления GrantBalances.
+type Grant struct {
+	UserID string
+	Amount int
+}
+
+// Кто может зарегистрироваться через Register.
+type RegistrationPolicy struct {
+	// Логины, которым регистрация разрешена без приглашения
+	AllowedLogins []string
+	// Сколько действует выданное приглашение
+	InviteTTL time.Duration
+}
+
+func (p RegistrationPolicy) allows(login string) bool {
+	for _, l := range p.AllowedLogins {
+		if l == login {
+			return true
+		}
+	}
+	return false
+}
+
+// Все методы принимают контекст запроса: клиент ушел или вышел
+// таймаут маршрута - запросы к базе отменяются.
 type UserRepo interface {
-	Authorize(login, password string) (User, error)
+	// Старый вход с автоматической регистрацией неизвестного логина
+	Authorize(ctx context.Context, login, password string) (User, error)
+	Login(ctx context.Context, login, password string) (User, error)
+	Register(ctx context.Context, login, password, invite string) (User, error)
+	CreateInvite(ctx context.Context, createdBy string) (Invite, error)
+	GetByLogin(ctx context.Context, login string) (User, error)
+
+	Info(ctx context.Context, userID string) (types.InfoResponse, error)
+	SendCoin(ctx context.Context, userID string, to Recipient, amount int) error
+	BuyItem(ctx context.Context, userID, itemTitle string) error
+	History(ctx context.Context, userID string, filter types.HistoryFilter) (types.HistoryPage, error)
 
-	Info(userID string) (types.InfoResponse, error)
-	SendCoin(userID, toUserLogin string, amount int) error
-	BuyItem(userID, itemTitle string) error
+	AdjustBalance(ctx context.Context, userID string, delta int, reason string) error
+	GrantBalances(ctx context.Context, grants []Grant, reason string) error
+	SetRoles(ctx context.Context, login string, roles []string) error
+	SetActive(ctx context.Context, login string, active bool) error
+	CreateUser(ctx context.Context, login, password string, roles []string) (User, error)
+	ResetPassword(ctx context.Context, login, password string) error
 }
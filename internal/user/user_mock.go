@@ -5,6 +5,7 @@
 package user
 
 import (
+	context "context"
 	types "proj/internal/types"
 	reflect "reflect"
 
@@ -34,60 +35,220 @@ func (m *MockUserRepo) EXPECT() *MockUserRepoMockRecorder {
 	return m.recorder
 }
 
+// AdjustBalance mocks base method.
+func (m *MockUserRepo) AdjustBalance(ctx context.Context, userID string, delta int, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdjustBalance", ctx, userID, delta, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AdjustBalance indicates an expected call of AdjustBalance.
+func (mr *MockUserRepoMockRecorder) AdjustBalance(ctx, userID, delta, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdjustBalance", reflect.TypeOf((*MockUserRepo)(nil).AdjustBalance), ctx, userID, delta, reason)
+}
+
 // Authorize mocks base method.
-func (m *MockUserRepo) Authorize(login, password string) (User, error) {
+func (m *MockUserRepo) Authorize(ctx context.Context, login, password string) (User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Authorize", login, password)
+	ret := m.ctrl.Call(m, "Authorize", ctx, login, password)
 	ret0, _ := ret[0].(User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Authorize indicates an expected call of Authorize.
-func (mr *MockUserRepoMockRecorder) Authorize(login, password interface{}) *gomock.Call {
+func (mr *MockUserRepoMockRecorder) Authorize(ctx, login, password interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authorize", reflect.TypeOf((*MockUserRepo)(nil).Authorize), login, password)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authorize", reflect.TypeOf((*MockUserRepo)(nil).Authorize), ctx, login, password)
 }
 
 // BuyItem mocks base method.
-func (m *MockUserRepo) BuyItem(userID, itemTitle string) error {
+func (m *MockUserRepo) BuyItem(ctx context.Context, userID, itemTitle string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "BuyItem", userID, itemTitle)
+	ret := m.ctrl.Call(m, "BuyItem", ctx, userID, itemTitle)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // BuyItem indicates an expected call of BuyItem.
-func (mr *MockUserRepoMockRecorder) BuyItem(userID, itemTitle interface{}) *gomock.Call {
+func (mr *MockUserRepoMockRecorder) BuyItem(ctx, userID, itemTitle interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuyItem", reflect.TypeOf((*MockUserRepo)(nil).BuyItem), ctx, userID, itemTitle)
+}
+
+// CreateInvite mocks base method.
+func (m *MockUserRepo) CreateInvite(ctx context.Context, createdBy string) (Invite, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateInvite", ctx, createdBy)
+	ret0, _ := ret[0].(Invite)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateInvite indicates an expected call of CreateInvite.
+func (mr *MockUserRepoMockRecorder) CreateInvite(ctx, createdBy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateInvite", reflect.TypeOf((*MockUserRepo)(nil).CreateInvite), ctx, createdBy)
+}
+
+// CreateUser mocks base method.
+func (m *MockUserRepo) CreateUser(ctx context.Context, login, password string, roles []string) (User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUser", ctx, login, password, roles)
+	ret0, _ := ret[0].(User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateUser indicates an expected call of CreateUser.
+func (mr *MockUserRepoMockRecorder) CreateUser(ctx, login, password, roles interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockUserRepo)(nil).CreateUser), ctx, login, password, roles)
+}
+
+// GetByLogin mocks base method.
+func (m *MockUserRepo) GetByLogin(ctx context.Context, login string) (User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetByLogin", ctx, login)
+	ret0, _ := ret[0].(User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetByLogin indicates an expected call of GetByLogin.
+func (mr *MockUserRepoMockRecorder) GetByLogin(ctx, login interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByLogin", reflect.TypeOf((*MockUserRepo)(nil).GetByLogin), ctx, login)
+}
+
+// GrantBalances mocks base method.
+func (m *MockUserRepo) GrantBalances(ctx context.Context, grants []Grant, reason string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GrantBalances", ctx, grants, reason)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// GrantBalances indicates an expected call of GrantBalances.
+func (mr *MockUserRepoMockRecorder) GrantBalances(ctx, grants, reason interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GrantBalances", reflect.TypeOf((*MockUserRepo)(nil).GrantBalances), ctx, grants, reason)
+}
+
+// History mocks base method.
+func (m *MockUserRepo) History(ctx context.Context, userID string, filter types.HistoryFilter) (types.HistoryPage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "History", ctx, userID, filter)
+	ret0, _ := ret[0].(types.HistoryPage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// History indicates an expected call of History.
+func (mr *MockUserRepoMockRecorder) History(ctx, userID, filter interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuyItem", reflect.TypeOf((*MockUserRepo)(nil).BuyItem), userID, itemTitle)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockUserRepo)(nil).History), ctx, userID, filter)
 }
 
 // Info mocks base method.
-func (m *MockUserRepo) Info(userID string) (types.InfoResponse, error) {
+func (m *MockUserRepo) Info(ctx context.Context, userID string) (types.InfoResponse, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Info", userID)
+	ret := m.ctrl.Call(m, "Info", ctx, userID)
 	ret0, _ := ret[0].(types.InfoResponse)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Info indicates an expected call of Info.
-func (mr *MockUserRepoMockRecorder) Info(userID interface{}) *gomock.Call {
+func (mr *MockUserRepoMockRecorder) Info(ctx, userID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Info", reflect.TypeOf((*MockUserRepo)(nil).Info), ctx, userID)
+}
+
+// Login mocks base method.
+func (m *MockUserRepo) Login(ctx context.Context, login, password string) (User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Login", ctx, login, password)
+	ret0, _ := ret[0].(User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Login indicates an expected call of Login.
+func (mr *MockUserRepoMockRecorder) Login(ctx, login, password interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Login", reflect.TypeOf((*MockUserRepo)(nil).Login), ctx, login, password)
+}
+
+// Register mocks base method.
+func (m *MockUserRepo) Register(ctx context.Context, login, password, invite string) (User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Register", ctx, login, password, invite)
+	ret0, _ := ret[0].(User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Register indicates an expected call of Register.
+func (mr *MockUserRepoMockRecorder) Register(ctx, login, password, invite interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Register", reflect.TypeOf((*MockUserRepo)(nil).Register), ctx, login, password, invite)
+}
+
+// ResetPassword mocks base method.
+func (m *MockUserRepo) ResetPassword(ctx context.Context, login, password string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetPassword", ctx, login, password)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetPassword indicates an expected call of ResetPassword.
+func (mr *MockUserRepoMockRecorder) ResetPassword(ctx, login, password interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Info", reflect.TypeOf((*MockUserRepo)(nil).Info), userID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetPassword", reflect.TypeOf((*MockUserRepo)(nil).ResetPassword), ctx, login, password)
 }
 
 // SendCoin mocks base method.
-func (m *MockUserRepo) SendCoin(userID, toUserLogin string, amount int) error {
+func (m *MockUserRepo) SendCoin(ctx context.Context, userID string, to Recipient, amount int) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SendCoin", userID, toUserLogin, amount)
+	ret := m.ctrl.Call(m, "SendCoin", ctx, userID, to, amount)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // SendCoin indicates an expected call of SendCoin.
-func (mr *MockUserRepoMockRecorder) SendCoin(userID, toUserLogin, amount interface{}) *gomock.Call {
+func (mr *MockUserRepoMockRecorder) SendCoin(ctx, userID, to, amount interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendCoin", reflect.TypeOf((*MockUserRepo)(nil).SendCoin), ctx, userID, to, amount)
+}
+
+// SetActive mocks base method.
+func (m *MockUserRepo) SetActive(ctx context.Context, login string, active bool) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetActive", ctx, login, active)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetActive indicates an expected call of SetActive.
+func (mr *MockUserRepoMockRecorder) SetActive(ctx, login, active interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetActive", reflect.TypeOf((*MockUserRepo)(nil).SetActive), ctx, login, active)
+}
+
+// SetRoles mocks base method.
+func (m *MockUserRepo) SetRoles(ctx context.Context, login string, roles []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetRoles", ctx, login, roles)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetRoles indicates an expected call of SetRoles.
+func (mr *MockUserRepoMockRecorder) SetRoles(ctx, login, roles interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SendCoin", reflect.TypeOf((*MockUserRepo)(nil).SendCoin), userID, toUserLogin, amount)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetRoles", reflect.TypeOf((*MockUserRepo)(nil).SetRoles), ctx, login, roles)
 }
@@ -1,12 +1,21 @@
 package user
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"net/http"
+	"proj/internal/audit"
+	"proj/internal/idempotency"
+	"proj/internal/ledger"
+	"proj/internal/rbac"
 	"proj/internal/types"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
@@ -24,6 +33,42 @@ func newTestDBRepository(t *testing.T) (*UserDBRepository, sqlmock.Sqlmock) {
 	return NewUserDBRepository(db, logger), mock
 }
 
+// expectLedgerPost мокирует запись в журнал: сама запись и ее проводки
+func expectLedgerPost(mock sqlmock.Sqlmock, kind, reference string, args ...driver.Value) {
+	mock.ExpectQuery(`INSERT INTO ledger_entries \(kind, reference\) VALUES \(\$1, \$2\) RETURNING entry_id`).
+		WithArgs(kind, reference).
+		WillReturnRows(sqlmock.NewRows([]string{"entry_id"}).AddRow(1))
+
+	mock.ExpectExec(`INSERT INTO ledger_postings \(entry_id, account, amount\) VALUES`).
+		WithArgs(append([]driver.Value{int64(1)}, args...)...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(args)/2)))
+}
+
+// expectAudit мокирует запись события аудита в транзакции
+func expectAudit(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectQuery(`INSERT INTO audit_events`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"event_id"}).AddRow(1))
+}
+
+// expectAuditWrite мокирует событие аудита в отдельной транзакции
+func expectAuditWrite(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectBegin()
+	expectAudit(mock, action)
+	mock.ExpectCommit()
+}
+
+const storeItemQuery = `SELECT "type", slug, display_name, description, price, active, sort_order, price_version FROM store WHERE slug = \$1 AND active FOR SHARE`
+
+// expectStoreItem мокирует поиск товара в каталоге
+func expectStoreItem(mock sqlmock.Sqlmock, code int, slug string, price int) {
+	mock.ExpectQuery(storeItemQuery).
+		WithArgs(slug).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "slug", "display_name", "description", "price", "active", "sort_order", "price_version"}).
+			AddRow(code, slug, slug, "", price, true, code, 1))
+}
+
 func TestUserDBRepository_Authorize(t *testing.T) {
 	// Генерируем реальный хэш пароля для теста
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte("correct_password"), bcrypt.DefaultCost)
@@ -45,16 +90,17 @@ func TestUserDBRepository_Authorize(t *testing.T) {
 			password: "correct_password",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				// Мокируем запрос для поиска существующего пользователя
-				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet FROM users WHERE login = \\$1").
+				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet, roles FROM users WHERE login = \\$1").
 					WithArgs("existing_user").
-					WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "hash_password", "amount_in_wallet"}).
-						AddRow("user1", "existing_user", hashedPassword, 100))
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "hash_password", "amount_in_wallet", "roles"}).
+						AddRow("user1", "existing_user", hashedPassword, 100, "{employee,finance}"))
 			},
 			expectedUser: User{
 				UserID:         "user1",
 				Login:          "existing_user",
 				passwordHash:   string(hashedPassword),
 				AmountInWallet: 100,
+				Roles:          []string{"employee", "finance"},
 			},
 			expectedError: nil,
 		},
@@ -63,17 +109,25 @@ func TestUserDBRepository_Authorize(t *testing.T) {
 			login:    "new_user",
 			password: "new_password",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet FROM users WHERE login = \\$1").
+				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet, roles FROM users WHERE login = \\$1").
 					WithArgs("new_user").
 					WillReturnError(sql.ErrNoRows)
 
-				mock.ExpectExec("INSERT INTO users \\(user_id, login, hash_password, amount_in_wallet\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").
-					WithArgs(sqlmock.AnyArg(), "new_user", sqlmock.AnyArg(), startAmountOfMoney).
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO users \\(user_id, login, hash_password, amount_in_wallet, roles\\) VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5\\)").
+					WithArgs(sqlmock.AnyArg(), "new_user", sqlmock.AnyArg(), startAmountOfMoney, sqlmock.AnyArg()).
 					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				// Стартовое начисление в журнале
+				expectLedgerPost(mock, ledger.KindSignupGrant, "",
+					ledger.AccountIssuance, -startAmountOfMoney, sqlmock.AnyArg(), startAmountOfMoney)
+				expectAudit(mock, audit.ActionRegister)
+				mock.ExpectCommit()
 			},
 			expectedUser: User{
 				Login:          "new_user",
 				AmountInWallet: startAmountOfMoney,
+				Roles:          rbac.DefaultRoles,
 			},
 			expectedError: nil,
 		},
@@ -82,10 +136,11 @@ func TestUserDBRepository_Authorize(t *testing.T) {
 			login:    "existing_user",
 			password: "wrong_password",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet FROM users WHERE login = \\$1").
+				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet, roles FROM users WHERE login = \\$1").
 					WithArgs("existing_user").
-					WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "hash_password", "amount_in_wallet"}).
-						AddRow("user1", "existing_user", "$2a$10$hashed_password", 100))
+					WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "hash_password", "amount_in_wallet", "roles"}).
+						AddRow("user1", "existing_user", "$2a$10$hashed_password", 100, "{employee}"))
+				expectAuditWrite(mock, audit.ActionLoginFailed)
 			},
 			expectedUser:  User{},
 			expectedError: ErrBadPassword,
@@ -95,7 +150,7 @@ func TestUserDBRepository_Authorize(t *testing.T) {
 			login:    "existing_user",
 			password: "correct_password",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet FROM users WHERE login = \\$1").
+				mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet, roles FROM users WHERE login = \\$1").
 					WithArgs("existing_user").
 					WillReturnError(errors.New("database error"))
 			},
@@ -111,7 +166,7 @@ func TestUserDBRepository_Authorize(t *testing.T) {
 			// Настраиваем мок базы данных
 			tt.mockDBSetup(mock)
 
-			user, err := repo.Authorize(tt.login, tt.password)
+			user, err := repo.Authorize(context.Background(), tt.login, tt.password)
 
 			// Проверяем результаты
 			if tt.expectedError != nil {
@@ -120,6 +175,7 @@ func TestUserDBRepository_Authorize(t *testing.T) {
 				assert.NoError(t, err)
 				assert.Equal(t, tt.expectedUser.Login, user.Login)
 				assert.Equal(t, tt.expectedUser.AmountInWallet, user.AmountInWallet)
+				assert.Equal(t, tt.expectedUser.Roles, user.Roles)
 				// Для нового пользователя проверяем, что UserID и passwordHash были сгенерированы
 				if tt.name == "SuccessNewUser" {
 					assert.NotEmpty(t, user.UserID)
@@ -150,29 +206,29 @@ func TestUserDBRepository_Info(t *testing.T) {
 					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
 
 				// Мокируем запрос для получения инвентаря
-				mock.ExpectQuery("SELECT type, quantity FROM items WHERE user_id = \\$1").
+				mock.ExpectQuery(`SELECT s.slug, i.quantity FROM items i JOIN store s ON s."type" = i."type" WHERE i.user_id = \$1`).
 					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"type", "quantity"}).
-						AddRow(0, 2). // TypeItemTShirt
-						AddRow(1, 1)) // TypeItemCup
+					WillReturnRows(sqlmock.NewRows([]string{"slug", "quantity"}).
+						AddRow("t-shirt", 2).
+						AddRow("cup", 1))
 
 				// Мокируем запрос для получения полученных транзакций
-				mock.ExpectQuery("SELECT u_from.login AS from_user, t.amount FROM transactions t JOIN users u_from ON t.sender = u_from.user_id WHERE t.receiver = \\$1").
-					WithArgs("user1").
+				mock.ExpectQuery("SELECT u_from.login AS from_user, t.amount FROM transactions t JOIN users u_from ON t.sender = u_from.user_id WHERE t.receiver = \\$1 ORDER BY t.created_at DESC, t.trans_id DESC LIMIT \\$2").
+					WithArgs("user1", InfoHistoryLimit).
 					WillReturnRows(sqlmock.NewRows([]string{"from_user", "amount"}).
 						AddRow("user2", 50))
 
 				// Мокируем запрос для отправленных транзакций
-				mock.ExpectQuery("SELECT u_to.login AS to_user, t.amount FROM transactions t JOIN users u_to ON t.receiver = u_to.user_id WHERE t.sender = \\$1").
-					WithArgs("user1").
+				mock.ExpectQuery("SELECT u_to.login AS to_user, t.amount FROM transactions t JOIN users u_to ON t.receiver = u_to.user_id WHERE t.sender = \\$1 ORDER BY t.created_at DESC, t.trans_id DESC LIMIT \\$2").
+					WithArgs("user1", InfoHistoryLimit).
 					WillReturnRows(sqlmock.NewRows([]string{"to_user", "amount"}).
 						AddRow("user3", 30))
 			},
 			expectedInfo: types.InfoResponse{
 				Coins: 100,
 				Inventory: []types.Item{
-					{Type: "t-shirt", Quantity: 2}, // TypeItemTShirt
-					{Type: "cup", Quantity: 1},     // TypeItemCup
+					{Type: "t-shirt", Quantity: 2},
+					{Type: "cup", Quantity: 1},
 				},
 				CoinHistory: types.Transaction{
 					Received: []types.ReceivedTrans{
@@ -221,7 +277,7 @@ func TestUserDBRepository_Info(t *testing.T) {
 			tt.mockDBSetup(mock)
 
 			// Вызываем метод Info
-			info, err := repo.Info(tt.userID)
+			info, err := repo.Info(context.Background(), tt.userID)
 
 			// Проверяем результаты
 			assert.Equal(t, tt.expectedInfo, info)
@@ -233,324 +289,693 @@ func TestUserDBRepository_Info(t *testing.T) {
 	}
 }
 
-func TestUserDBRepository_SendCoin(t *testing.T) {
+func TestUserDBRepository_BuyItem(t *testing.T) {
 	// Тестовые случаи
 	tests := []struct {
 		name          string
 		userID        string
-		toUserLogin   string
-		amount        int
-		mockDBSetup   func(sqlmock.Sqlmock) // Настройка мока базы данных
+		itemTitle     string
+		mockDBSetup   func(sqlmock.Sqlmock)
 		expectedError error
 	}{
 		{
-			name:        "Success",
-			userID:      "user1",
-			toUserLogin: "user2",
-			amount:      50,
+			name:      "SuccessNewItem",
+			userID:    "user1",
+			itemTitle: "t-shirt",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
 
-				// Проверка баланса (FOR UPDATE)
+				// getItemByTitle
+				expectStoreItem(mock, 0, "t-shirt", 50)
+
+				// enoughCoinsInWallet
 				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
 					WithArgs("user1").
 					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
 
-				// Списание средств
+				// chargeOffFromWallet
 				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
 					WithArgs(50, "user1").
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
-				// Зачисление средств получателю
-				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1 WHERE login = \$2`).
-					WithArgs(50, "user2").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// Поиск ID получателя
-				mock.ExpectQuery(`SELECT user_id FROM users WHERE login = \$1`).
-					WithArgs("user2").
-					WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("user2"))
+				// addItemInInventory (item not exists)
+				mock.ExpectQuery(`SELECT type FROM items WHERE user_id = \$1 AND type = \$2`).
+					WithArgs("user1", 0).
+					WillReturnError(sql.ErrNoRows)
 
-				// Добавление транзакции
-				mock.ExpectExec(`INSERT INTO transactions \(sender, receiver, amount\) VALUES \(\$1, \$2, \$3\)`).
-					WithArgs("user1", "user2", 50).
+				mock.ExpectExec(`INSERT INTO items \(user_id, type, quantity\) VALUES \(\$1, \$2, \$3\)`).
+					WithArgs("user1", 0, 1).
 					WillReturnResult(sqlmock.NewResult(1, 1))
 
+				expectLedgerPost(mock, ledger.KindPurchase, "t-shirt@v1",
+					ledger.UserAccount("user1"), -50, ledger.AccountStore, 50)
+				expectAudit(mock, audit.ActionPurchase)
+
 				mock.ExpectCommit()
 			},
 			expectedError: nil,
 		},
 		{
-			name:        "InsufficientFunds",
-			userID:      "user1",
-			toUserLogin: "user2",
-			amount:      150,
+			name:      "SuccessExistingItem",
+			userID:    "user1",
+			itemTitle: "cup",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				// Мокируем начало транзакции
 				mock.ExpectBegin()
 
-				// Мокируем запрос для проверки баланса отправителя
+				// getItemByTitle
+				expectStoreItem(mock, 1, "cup", 30)
+
+				// enoughCoinsInWallet
 				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
 					WithArgs("user1").
 					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
 
-				// Мокируем откат транзакции
-				mock.ExpectRollback()
+				// chargeOffFromWallet
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
+					WithArgs(30, "user1").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				// addItemInInventory (item exists)
+				mock.ExpectQuery(`SELECT type FROM items WHERE user_id = \$1 AND type = \$2`).
+					WithArgs("user1", 1).
+					WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow(1))
+
+				mock.ExpectExec(`UPDATE items SET quantity = quantity \+ 1 WHERE user_id = \$1 AND type = \$2`).
+					WithArgs("user1", 1).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+
+				expectLedgerPost(mock, ledger.KindPurchase, "cup@v1",
+					ledger.UserAccount("user1"), -30, ledger.AccountStore, 30)
+				expectAudit(mock, audit.ActionPurchase)
+
+				mock.ExpectCommit()
 			},
-			expectedError: ErrInsufficientFunds,
+			expectedError: nil,
 		},
 		{
-			name:        "SenderNotFound",
-			userID:      "user1",
-			toUserLogin: "user2",
-			amount:      50,
+			name:      "InsufficientFunds",
+			userID:    "user1",
+			itemTitle: "book",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				// Мокируем начало транзакции
 				mock.ExpectBegin()
 
-				// Мокируем запрос для проверки баланса отправителя
+				// getItemByTitle
+				expectStoreItem(mock, 2, "book", 100)
+
+				// enoughCoinsInWallet
 				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
 					WithArgs("user1").
-					WillReturnError(sql.ErrNoRows)
+					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(50))
 
-				// Мокируем откат транзакции
 				mock.ExpectRollback()
 			},
-			expectedError: ErrUserNotFound,
+			expectedError: &InsufficientFundsError{Required: 100, Available: 50},
+		},
+		{
+			name:      "ItemNotFound",
+			userID:    "user1",
+			itemTitle: "invalid-item",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(storeItemQuery).
+					WithArgs("invalid-item").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			expectedError: ErrItemNotFound,
 		},
 		{
-			name:        "ReceiverNotFound",
-			userID:      "user1",
-			toUserLogin: "user2",
-			amount:      50,
+			name:      "UserNotFound",
+			userID:    "user1",
+			itemTitle: "t-shirt",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				// Мокируем начало транзакции
 				mock.ExpectBegin()
 
-				// Мокируем запрос для проверки баланса отправителя
+				// getItemByTitle
+				expectStoreItem(mock, 0, "t-shirt", 50)
+
+				// enoughCoinsInWallet
 				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
 					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
-
-				// Мокируем запрос для списания средств с отправителя
-				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
-					WithArgs(50, "user1").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// Мокируем запрос для зачисления средств получателю
-				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1 WHERE login = \$2`).
-					WithArgs(50, "user2").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// Мокируем запрос для получения ID получателя
-				mock.ExpectQuery(`SELECT user_id FROM users WHERE login = \$1`).
-					WithArgs("user2").
-					WillReturnError(sql.ErrNoRows) // Пользователь не найден
+					WillReturnError(sql.ErrNoRows)
 
-				// Мокируем откат транзакции
 				mock.ExpectRollback()
 			},
 			expectedError: ErrUserNotFound,
 		},
 		{
-			name:        "DatabaseError",
-			userID:      "user1",
-			toUserLogin: "user2",
-			amount:      50,
+			name:      "DatabaseError",
+			userID:    "user1",
+			itemTitle: "t-shirt",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
-				// Мокируем начало транзакции
 				mock.ExpectBegin()
 
-				// Мокируем запрос для проверки баланса отправителя
-				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
-					WithArgs("user1").
-					WillReturnError(errors.New("database error"))
+				// getItemByTitle
+				mock.ExpectQuery(storeItemQuery).
+					WithArgs("t-shirt").
+					WillReturnError(errors.New("db error"))
 
-				// Мокируем откат транзакции
 				mock.ExpectRollback()
 			},
 			expectedError: ErrInternalDB,
 		},
 	}
 
-	// Запуск тестов
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Создаем мок базы данных и репозиторий
 			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
 
-			// Настраиваем мок базы данных
+			err := repo.BuyItem(context.Background(), tt.userID, tt.itemTitle)
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// С Idempotency-Key результат покупки сохраняется в той же транзакции.
+func TestUserDBRepository_BuyItemIdempotent(t *testing.T) {
+	const completeQuery = `UPDATE idempotency_keys SET status_code = \$4, response = NULL`
+	rec := &idempotency.Record{UserID: "user1", Key: "key1", CreatedAt: time.Now()}
+
+	expectPurchase := func(mock sqlmock.Sqlmock) {
+		mock.ExpectBegin()
+		expectStoreItem(mock, 3, "pen", 10)
+		mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
+			WithArgs("user1").
+			WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
+		mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
+			WithArgs(10, "user1").
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery(`SELECT type FROM items WHERE user_id = \$1 AND type = \$2`).
+			WithArgs("user1", 3).
+			WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow(3))
+		mock.ExpectExec(`UPDATE items SET quantity = quantity \+ 1 WHERE user_id = \$1 AND type = \$2`).
+			WithArgs("user1", 3).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		expectLedgerPost(mock, ledger.KindPurchase, "pen@v1",
+			ledger.UserAccount("user1"), -10, ledger.AccountStore, 10)
+		expectAudit(mock, audit.ActionPurchase)
+	}
+
+	tests := map[string]func(t *testing.T){
+		"result stored with the purchase": func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			expectPurchase(mock)
+			mock.ExpectExec(completeQuery).
+				WithArgs("user1", "key1", rec.CreatedAt, http.StatusOK, 0).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			err := repo.BuyItem(idempotency.WithReservation(context.Background(), rec), "user1", "pen")
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		},
+
+		"lease lost rolls the purchase back": func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			expectPurchase(mock)
+			mock.ExpectExec(completeQuery).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectRollback()
+
+			err := repo.BuyItem(idempotency.WithReservation(context.Background(), rec), "user1", "pen")
+			assert.ErrorIs(t, err, idempotency.ErrRequestInProgress)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, test)
+	}
+}
+
+func TestUserDBRepository_History(t *testing.T) {
+	cols := []string{"trans_id", "created_at", "amount", "direction", "counterparty"}
+	now := time.Now().UTC()
+
+	tests := []struct {
+		name           string
+		filter         types.HistoryFilter
+		mockDBSetup    func(sqlmock.Sqlmock)
+		expectedItems  int
+		expectedCursor bool
+		expectedError  error
+	}{
+		{
+			name:   "FirstPageWithNext",
+			filter: types.HistoryFilter{Limit: 2},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WHERE \(t.sender = \$1 OR t.receiver = \$1\) ORDER BY t.created_at DESC, t.trans_id DESC LIMIT \$2`).
+					WithArgs("user1", 3).
+					WillReturnRows(sqlmock.NewRows(cols).
+						AddRow(3, now, 10, "sent", "user2").
+						AddRow(2, now.Add(-time.Minute), 20, "received", "user3").
+						AddRow(1, now.Add(-time.Hour), 30, "sent", "user2"))
+			},
+			expectedItems:  2,
+			expectedCursor: true,
+		},
+		{
+			name: "FiltersLastPage",
+			filter: types.HistoryFilter{
+				Direction:    types.DirectionSent,
+				Counterparty: "user2",
+				MinAmount:    5,
+				MaxAmount:    50,
+			},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`WHERE t.sender = \$1 AND CASE .* END = \$2 AND t.amount >= \$3 AND t.amount <= \$4 ORDER BY`).
+					WithArgs("user1", "user2", 5, 50, HistoryDefaultLimit+1).
+					WillReturnRows(sqlmock.NewRows(cols).
+						AddRow(3, now, 10, "sent", "user2"))
+			},
+			expectedItems:  1,
+			expectedCursor: false,
+		},
+		{
+			name:   "WithCursor",
+			filter: types.HistoryFilter{Cursor: encodeHistoryCursor(historyCursor{CreatedAt: now, ID: 3})},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`AND \(t.created_at, t.trans_id\) < \(\$2, \$3\)`).
+					WithArgs("user1", sqlmock.AnyArg(), int64(3), HistoryDefaultLimit+1).
+					WillReturnRows(sqlmock.NewRows(cols))
+			},
+			expectedItems: 0,
+		},
+		{
+			name:          "InvalidCursor",
+			filter:        types.HistoryFilter{Cursor: "!!!"},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrInvalidCursor,
+		},
+		{
+			name:          "InvalidDirection",
+			filter:        types.HistoryFilter{Direction: "sideways"},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrInvalidHistoryFilter,
+		},
+		{
+			name:   "DatabaseError",
+			filter: types.HistoryFilter{},
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM transactions t`).
+					WillReturnError(errors.New("database error"))
+			},
+			expectedError: ErrInternalDB,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
 			tt.mockDBSetup(mock)
 
-			// Вызываем метод SendCoin
-			err := repo.SendCoin(tt.userID, tt.toUserLogin, tt.amount)
+			page, err := repo.History(context.Background(), "user1", tt.filter)
 
-			// Проверяем результаты
 			assert.Equal(t, tt.expectedError, err)
-
-			// Проверяем, что все ожидания мока базы данных выполнены
+			assert.Len(t, page.Items, tt.expectedItems)
+			assert.Equal(t, tt.expectedCursor, page.NextCursor != "")
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
 
-func TestUserDBRepository_BuyItem(t *testing.T) {
-	// Тестовые случаи
+func TestUserDBRepository_AdjustBalance(t *testing.T) {
 	tests := []struct {
 		name          string
-		userID        string
-		itemTitle     string
+		delta         int
 		mockDBSetup   func(sqlmock.Sqlmock)
 		expectedError error
 	}{
 		{
-			name:      "SuccessNewItem",
-			userID:    "user1",
-			itemTitle: "t-shirt",
+			name:  "Credit",
+			delta: 100,
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-
-				// getItemByTitle
-				mock.ExpectQuery(`SELECT price FROM store WHERE type = \$1`).
-					WithArgs(types.TypeItemTShirt).
-					WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(50))
-
-				// enoughCoinsInWallet
 				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
 					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
-
-				// chargeOffFromWallet
-				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
-					WithArgs(50, "user1").
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
-				// addItemInInventory (item not exists)
-				mock.ExpectQuery(`SELECT type FROM items WHERE user_id = \$1 AND type = \$2`).
-					WithArgs("user1", types.TypeItemTShirt).
-					WillReturnError(sql.ErrNoRows)
-
-				mock.ExpectExec(`INSERT INTO items \(user_id, type, quantity\) VALUES \(\$1, \$2, \$3\)`).
-					WithArgs("user1", types.TypeItemTShirt, 1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
-
+					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(10))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1 WHERE user_id = \$2`).
+					WithArgs(100, "user1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectLedgerPost(mock, ledger.KindAdjustment, "bonus",
+					ledger.AccountAdjustments, -100, ledger.UserAccount("user1"), 100)
+				expectAudit(mock, audit.ActionAdjust)
 				mock.ExpectCommit()
 			},
-			expectedError: nil,
 		},
 		{
-			name:      "SuccessExistingItem",
-			userID:    "user1",
-			itemTitle: "cup",
+			name:  "DebitBelowZero",
+			delta: -100,
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
+					WithArgs("user1").
+					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(10))
+				mock.ExpectRollback()
+			},
+			expectedError: &InsufficientFundsError{Required: 100, Available: 10},
+		},
+		{
+			// корректировка идет через Tx.Run и повторяется после конфликта сериализации
+			name:  "SerializationFailureRetried",
+			delta: 100,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
+					WithArgs("user1").
+					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(10))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet`).
+					WillReturnError(&pq.Error{Code: "40001"})
+				mock.ExpectRollback()
 
-				// getItemByTitle
-				mock.ExpectQuery(`SELECT price FROM store WHERE type = \$1`).
-					WithArgs(types.TypeItemCup).
-					WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(30))
-
-				// enoughCoinsInWallet
+				mock.ExpectBegin()
 				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
 					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(100))
+					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(10))
+				mock.ExpectExec(`UPDATE users SET amount_in_wallet`).
+					WithArgs(100, "user1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectLedgerPost(mock, ledger.KindAdjustment, "bonus",
+					ledger.AccountAdjustments, -100, ledger.UserAccount("user1"), 100)
+				expectAudit(mock, audit.ActionAdjust)
+				mock.ExpectCommit()
+			},
+		},
+		{
+			name:          "ZeroDelta",
+			delta:         0,
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ledger.ErrInvalidAmount,
+		},
+	}
 
-				// chargeOffFromWallet
-				mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet - \$1 WHERE user_id = \$2`).
-					WithArgs(30, "user1").
-					WillReturnResult(sqlmock.NewResult(1, 1))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
 
-				// addItemInInventory (item exists)
-				mock.ExpectQuery(`SELECT type FROM items WHERE user_id = \$1 AND type = \$2`).
-					WithArgs("user1", types.TypeItemCup).
-					WillReturnRows(sqlmock.NewRows([]string{"type"}).AddRow(types.TypeItemCup))
+			err := repo.AdjustBalance(context.Background(), "user1", tt.delta, "bonus")
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
 
-				mock.ExpectExec(`UPDATE items SET quantity = quantity \+ 1 WHERE user_id = \$1 AND type = \$2`).
-					WithArgs("user1", types.TypeItemCup).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+func TestUserDBRepository_GrantBalances(t *testing.T) {
+	grants := []Grant{{UserID: "user1", Amount: 10}, {UserID: "user2", Amount: 20}}
+
+	expectGrant := func(mock sqlmock.Sqlmock, userID string, amount int) {
+		mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
+			WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(0))
+		mock.ExpectExec(`UPDATE users SET amount_in_wallet = amount_in_wallet \+ \$1 WHERE user_id = \$2`).
+			WithArgs(amount, userID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectLedgerPost(mock, ledger.KindAdjustment, "hackathon",
+			ledger.AccountAdjustments, -amount, ledger.UserAccount(userID), amount)
+		expectAudit(mock, audit.ActionAdjust)
+	}
 
+	tests := []struct {
+		name          string
+		grants        []Grant
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name:   "AllRows",
+			grants: grants,
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				expectGrant(mock, "user1", 10)
+				expectGrant(mock, "user2", 20)
 				mock.ExpectCommit()
 			},
-			expectedError: nil,
 		},
 		{
-			name:      "InsufficientFunds",
-			userID:    "user1",
-			itemTitle: "book",
+			// первая строка уже проведена в транзакции, откатывается вместе с ней
+			name:   "FailsMidBatch",
+			grants: grants,
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
+				expectGrant(mock, "user1", 10)
+				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
+					WithArgs("user2").
+					WillReturnError(errors.New("connection reset"))
+				mock.ExpectRollback()
+			},
+			expectedError: ErrInternalDB,
+		},
+		{
+			name:          "NonPositiveAmount",
+			grants:        []Grant{{UserID: "user1", Amount: 10}, {UserID: "user2", Amount: -5}},
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ledger.ErrInvalidAmount,
+		},
+	}
 
-				// getItemByTitle
-				mock.ExpectQuery(`SELECT price FROM store WHERE type = \$1`).
-					WithArgs(types.TypeItemBook).
-					WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(100))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newTestDBRepository(t)
+			tt.mockDBSetup(mock)
 
-				// enoughCoinsInWallet
-				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
-					WithArgs("user1").
-					WillReturnRows(sqlmock.NewRows([]string{"amount_in_wallet"}).AddRow(50))
+			err := repo.GrantBalances(context.Background(), tt.grants, "hackathon")
+			assert.Equal(t, tt.expectedError, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
 
-				mock.ExpectRollback()
+func TestUserDBRepository_SetRoles(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET roles = \$1 WHERE login = \$2`).
+		WithArgs(sqlmock.AnyArg(), "login1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActionSetRoles)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET roles = \$1 WHERE login = \$2`).
+		WithArgs(sqlmock.AnyArg(), "ghost").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.NoError(t, repo.SetRoles(context.Background(), "login1", []string{rbac.RoleEmployee, rbac.RoleFinance}))
+	assert.Equal(t, ErrUserNotFound, repo.SetRoles(context.Background(), "ghost", []string{rbac.RoleEmployee}))
+	assert.Equal(t, rbac.ErrUnknownRole, repo.SetRoles(context.Background(), "login1", []string{"root"}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserDBRepository_SetActive(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET active = \$1 WHERE login = \$2`).
+		WithArgs(false, "login1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActionUserOff)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET active = \$1 WHERE login = \$2`).
+		WithArgs(true, "ghost").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.NoError(t, repo.SetActive(context.Background(), "login1", false))
+	assert.Equal(t, ErrUserNotFound, repo.SetActive(context.Background(), "ghost", true))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserDBRepository_Login(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectQuery("SELECT user_id, login, hash_password, amount_in_wallet, roles FROM users WHERE login = \\$1").
+		WithArgs("typo_user").
+		WillReturnError(sql.ErrNoRows)
+	expectAuditWrite(mock, audit.ActionLoginFailed)
+
+	// неизвестный логин не создает пользователя, в отличие от Authorize
+	_, err := repo.Login(context.Background(), "typo_user", "password")
+	assert.Equal(t, ErrUserNotFound, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserDBRepository_Register(t *testing.T) {
+	const insertUser = "INSERT INTO users \\(user_id, login, hash_password, amount_in_wallet, roles\\) VALUES \\(\\$1, \\$2, \\$3, \\$4, \\$5\\)"
+	const redeem = `UPDATE invites SET used_by = \$1, used_at = NOW\(\) WHERE code = \$2 AND used_by IS NULL AND expires_at > NOW\(\)`
+
+	tests := []struct {
+		name          string
+		login         string
+		invite        string
+		mockDBSetup   func(sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name:  "AllowedLogin",
+			login: "allowed",
+			mockDBSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec(insertUser).
+					WithArgs(sqlmock.AnyArg(), "allowed", sqlmock.AnyArg(), startAmountOfMoney, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				expectLedgerPost(mock, ledger.KindSignupGrant, "",
+					ledger.AccountIssuance, -startAmountOfMoney, sqlmock.AnyArg(), startAmountOfMoney)
+				expectAudit(mock, audit.ActionRegister)
+				mock.ExpectCommit()
 			},
-			expectedError: ErrInsufficientFunds,
 		},
 		{
-			name:      "ItemNotFound",
-			userID:    "user1",
-			itemTitle: "invalid-item",
+			name:          "NoInvite",
+			login:         "stranger",
+			mockDBSetup:   func(mock sqlmock.Sqlmock) {},
+			expectedError: ErrRegistrationClosed,
+		},
+		{
+			name:   "ValidInvite",
+			login:  "invited",
+			invite: "code1",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-				mock.ExpectRollback()
+				mock.ExpectExec(insertUser).
+					WithArgs(sqlmock.AnyArg(), "invited", sqlmock.AnyArg(), startAmountOfMoney, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(redeem).
+					WithArgs(sqlmock.AnyArg(), "code1").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				expectLedgerPost(mock, ledger.KindSignupGrant, "",
+					ledger.AccountIssuance, -startAmountOfMoney, sqlmock.AnyArg(), startAmountOfMoney)
+				expectAudit(mock, audit.ActionRegister)
+				mock.ExpectCommit()
 			},
-			expectedError: ErrItemNotFound,
 		},
 		{
-			name:      "UserNotFound",
-			userID:    "user1",
-			itemTitle: "t-shirt",
+			name:   "UsedInvite",
+			login:  "invited",
+			invite: "code1",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-
-				// getItemByTitle
-				mock.ExpectQuery(`SELECT price FROM store WHERE type = \$1`).
-					WithArgs(types.TypeItemTShirt).
-					WillReturnRows(sqlmock.NewRows([]string{"price"}).AddRow(50))
-
-				// enoughCoinsInWallet
-				mock.ExpectQuery(`SELECT amount_in_wallet FROM users WHERE user_id = \$1 FOR UPDATE`).
-					WithArgs("user1").
-					WillReturnError(sql.ErrNoRows)
-
+				mock.ExpectExec(insertUser).
+					WithArgs(sqlmock.AnyArg(), "invited", sqlmock.AnyArg(), startAmountOfMoney, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec(redeem).
+					WithArgs(sqlmock.AnyArg(), "code1").
+					WillReturnResult(sqlmock.NewResult(0, 0))
 				mock.ExpectRollback()
 			},
-			expectedError: ErrUserNotFound,
+			expectedError: ErrInvalidInvite,
 		},
 		{
-			name:      "DatabaseError",
-			userID:    "user1",
-			itemTitle: "t-shirt",
+			name:  "LoginTaken",
+			login: "allowed",
 			mockDBSetup: func(mock sqlmock.Sqlmock) {
 				mock.ExpectBegin()
-
-				// getItemByTitle
-				mock.ExpectQuery(`SELECT price FROM store WHERE type = \$1`).
-					WithArgs(types.TypeItemTShirt).
-					WillReturnError(errors.New("db error"))
-
+				mock.ExpectExec(insertUser).
+					WithArgs(sqlmock.AnyArg(), "allowed", sqlmock.AnyArg(), startAmountOfMoney, sqlmock.AnyArg()).
+					WillReturnError(&pq.Error{Code: pqUniqueViolation})
 				mock.ExpectRollback()
 			},
-			expectedError: ErrInternalDB,
+			expectedError: ErrUserExists,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			repo, mock := newTestDBRepository(t)
+			repo.Registration = RegistrationPolicy{AllowedLogins: []string{"allowed"}}
 			tt.mockDBSetup(mock)
 
-			err := repo.BuyItem(tt.userID, tt.itemTitle)
-			assert.Equal(t, tt.expectedError, err)
+			u, err := repo.Register(context.Background(), tt.login, "password", tt.invite)
+			if tt.expectedError != nil {
+				assert.Equal(t, tt.expectedError, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.login, u.Login)
+			}
 			assert.NoError(t, mock.ExpectationsWereMet())
 		})
 	}
 }
+
+func TestUserDBRepository_CreateUser(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+	// политика регистрации закрыта, но админа это не касается
+	repo.Registration = RegistrationPolicy{}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users \\(user_id, login, hash_password, amount_in_wallet, roles\\)").
+		WithArgs(sqlmock.AnyArg(), "manager", sqlmock.AnyArg(), startAmountOfMoney, `{"store_manager"}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectLedgerPost(mock, ledger.KindSignupGrant, "",
+		ledger.AccountIssuance, -startAmountOfMoney, sqlmock.AnyArg(), startAmountOfMoney)
+	expectAudit(mock, audit.ActionUserCreate)
+	mock.ExpectCommit()
+
+	u, err := repo.CreateUser(context.Background(), "manager", "password", []string{rbac.RoleStoreManager})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rbac.RoleStoreManager}, u.Roles)
+
+	_, err = repo.CreateUser(context.Background(), "root", "password", []string{"root"})
+	assert.Equal(t, rbac.ErrUnknownRole, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserDBRepository_ResetPassword(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET hash_password = \$1 WHERE login = \$2`).
+		WithArgs(sqlmock.AnyArg(), "login1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActionPasswordSet)
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE users SET hash_password = \$1 WHERE login = \$2`).
+		WithArgs(sqlmock.AnyArg(), "ghost").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.NoError(t, repo.ResetPassword(context.Background(), "login1", "new-password"))
+	assert.Equal(t, ErrUserNotFound, repo.ResetPassword(context.Background(), "ghost", "new-password"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserDBRepository_CreateInvite(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO invites \(code, created_by, expires_at\) VALUES \(\$1, \$2, \$3\)`).
+		WithArgs(sqlmock.AnyArg(), "admin1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActionInvite)
+	mock.ExpectCommit()
+
+	inv, err := repo.CreateInvite(context.Background(), "admin1")
+	assert.NoError(t, err)
+	assert.Len(t, inv.Code, 2*inviteCodeBytes)
+	assert.WithinDuration(t, time.Now().Add(DefaultInviteTTL), inv.ExpiresAt, time.Minute)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserDBRepository_GetByLogin(t *testing.T) {
+	repo, mock := newTestDBRepository(t)
+
+	mock.ExpectQuery(`SELECT user_id, login, amount_in_wallet, roles FROM users WHERE login = \$1`).
+		WithArgs("login1").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "login", "amount_in_wallet", "roles"}).
+			AddRow("user1", "login1", 100, "{employee}"))
+	mock.ExpectQuery(`SELECT user_id, login, amount_in_wallet, roles FROM users WHERE login = \$1`).
+		WithArgs("ghost").
+		WillReturnError(sql.ErrNoRows)
+
+	u, err := repo.GetByLogin(context.Background(), "login1")
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", u.UserID)
+
+	_, err = repo.GetByLogin(context.Background(), "ghost")
+	assert.Equal(t, ErrUserNotFound, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"proj/internal/handlers"
+	"proj/internal/types"
+	"sync"
+	"testing"
+)
+
+/*
+В данном тесте, мы сделаем следующее:
+  - Авторизуем двух пользователей
+  - Одновременно отправим много встречных переводов A->B и B->A,
+    раньше такие переводы вставали в дедлок и падали с 500
+  - Проверим, что ни один запрос не вернул 5xx и что сумма
+    балансов не изменилась
+*/
+func TestConcurrentSendCoin(t *testing.T) {
+	users := []string{"racer1", "racer2"}
+	tokens := make(map[string]string)
+
+	for _, username := range users {
+		authReq := handlers.AuthRequest{
+			Username: username,
+			Password: "testpass",
+		}
+
+		authBody, _ := json.Marshal(authReq)
+		authRes, err := http.Post("http://localhost:8080/api/auth",
+			"application/json", bytes.NewBuffer(authBody))
+		if err != nil {
+			t.Fatalf("auth failed: %v", err)
+		}
+
+		var authResp handlers.AuthResponse
+		json.NewDecoder(authRes.Body).Decode(&authResp)
+		tokens[username] = authResp.Token
+		authRes.Body.Close()
+	}
+
+	client := &http.Client{}
+
+	getCoins := func(username string) int {
+		req, _ := http.NewRequest("GET", "http://localhost:8080/api/info", nil)
+		req.Header.Set("Authorization", "Bearer "+tokens[username])
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("info failed: %v", err)
+		}
+		defer res.Body.Close()
+
+		var info types.InfoResponse
+		json.NewDecoder(res.Body).Decode(&info)
+		return info.Coins
+	}
+
+	totalBefore := getCoins("racer1") + getCoins("racer2")
+
+	sendMoney := func(from, to string) int {
+		sendBody, _ := json.Marshal(handlers.SendCoinRequest{ToUser: to, Amount: 1})
+		req, _ := http.NewRequest("POST", "http://localhost:8080/api/sendCoin",
+			bytes.NewBuffer(sendBody))
+		req.Header.Set("Authorization", "Bearer "+tokens[from])
+		req.Header.Set("Content-Type", "application/json")
+
+		res, err := client.Do(req)
+		if err != nil {
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	const rounds = 50
+	var wg sync.WaitGroup
+	statuses := make(chan int, 2*rounds)
+	for i := 0; i < rounds; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			statuses <- sendMoney("racer1", "racer2")
+		}()
+		go func() {
+			defer wg.Done()
+			statuses <- sendMoney("racer2", "racer1")
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		if status == 0 || status >= http.StatusInternalServerError {
+			t.Errorf("Unexpected status of concurrent transfer: %d", status)
+		}
+	}
+
+	totalAfter := getCoins("racer1") + getCoins("racer2")
+	if totalAfter != totalBefore {
+		t.Errorf("Total balance changed: before %d, after %d", totalBefore, totalAfter)
+	}
+}